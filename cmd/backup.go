@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"varconf-server/core/dao"
+	daocommon "varconf-server/core/dao/common"
+)
+
+// manageBackup runs "backup file", it dumps the apps, configs, releases,
+// release logs and users of the database of the config in dir to a json
+// archive which any storage driver can restore.
+func manageBackup(dir string, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: varconf [-p dir] backup file")
+		return
+	}
+	db, err := openBackupStorage(dir)
+	if err != nil {
+		fmt.Println("Backup failed!", err.Error())
+		return
+	}
+	defer db.Close()
+
+	backupData, err := dao.NewBackupDao(db).Dump(context.Background())
+	if err != nil {
+		fmt.Println("Backup failed!", err.Error())
+		return
+	}
+	content, err := json.MarshalIndent(backupData, "", "  ")
+	if err != nil {
+		fmt.Println("Backup failed!", err.Error())
+		return
+	}
+
+	// the archive holds the passwords of the users
+	tmpFile := args[0] + ".tmp"
+	err = ioutil.WriteFile(tmpFile, content, 0600)
+	if err == nil {
+		err = os.Rename(tmpFile, args[0])
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		fmt.Println("Backup failed!", err.Error())
+		return
+	}
+	fmt.Printf("Backed up %d app(s), %d config(s), %d release log(s), %d user(s) to %s!\n",
+		len(backupData.Apps), len(backupData.Configs), len(backupData.ReleaseLogs), len(backupData.Users), args[0])
+}
+
+// manageRestore runs "restore file", it migrates the database of the config
+// in dir and restores the archive into it, the database must hold no data so
+// it runs before the server starts on it.
+func manageRestore(dir string, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: varconf [-p dir] restore file")
+		return
+	}
+	content, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Println("Restore failed!", err.Error())
+		return
+	}
+	backupData := dao.BackupData{}
+	err = json.Unmarshal(content, &backupData)
+	if err != nil {
+		fmt.Println("Restore failed! bad archive", err.Error())
+		return
+	}
+
+	db, err := openBackupStorage(dir)
+	if err != nil {
+		fmt.Println("Restore failed!", err.Error())
+		return
+	}
+	defer db.Close()
+
+	_, err = dao.NewSchemaDao(db).MigrateUp(context.Background(), 0)
+	if err != nil {
+		fmt.Println("Restore failed!", err.Error())
+		return
+	}
+	err = dao.NewBackupDao(db).Restore(context.Background(), &backupData)
+	if err != nil {
+		fmt.Println("Restore failed!", err.Error())
+		return
+	}
+	fmt.Printf("Restored %d app(s), %d config(s), %d release log(s), %d user(s) from %s!\n",
+		len(backupData.Apps), len(backupData.Configs), len(backupData.ReleaseLogs), len(backupData.Users), args[0])
+}
+
+func openBackupStorage(dir string) (*daocommon.DB, error) {
+	configInfo := initConfig(path.Join(dir, "./config.json"))
+	if configInfo == nil {
+		return nil, fmt.Errorf("can't read config")
+	}
+
+	db, err := dao.OpenStorage(configInfo.DatabaseInfo.Driver, configInfo.DatabaseInfo.DataSource)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -8,6 +8,9 @@ import (
 	"os/exec"
 	"path"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
 func Execute() {
@@ -24,7 +27,13 @@ func Execute() {
 		flag.Usage()
 	}
 
-	if *s != "" {
+	if flag.Arg(0) == "migrate" {
+		manageMigrate(*p, flag.Args()[1:])
+	} else if flag.Arg(0) == "backup" {
+		manageBackup(*p, flag.Args()[1:])
+	} else if flag.Arg(0) == "restore" {
+		manageRestore(*p, flag.Args()[1:])
+	} else if *s != "" {
 		manageServer(*p, *s, *d)
 	} else {
 		flag.Usage()
@@ -56,8 +65,8 @@ func manageServer(dir, operate string, daemon bool) {
 			err = Start(configFile)
 			if err != nil {
 				fmt.Println("Start failed!", err.Error())
-				os.Remove(pidFile)
 			}
+			os.Remove(pidFile)
 		}
 		break
 
@@ -69,20 +78,21 @@ func manageServer(dir, operate string, daemon bool) {
 			return
 		}
 
-		pid := string(pb)
-		cmd := new(exec.Cmd)
+		pid := strings.TrimSpace(string(pb))
 		if runtime.GOOS == "windows" {
-			cmd = exec.Command("taskkill", "/f", "/pid", pid)
+			err = exec.Command("taskkill", "/f", "/pid", pid).Run()
+			if err == nil {
+				os.Remove(pidFile)
+			}
 		} else {
-			cmd = exec.Command("kill", pid)
+			// SIGTERM lets the server drain, it removes the pid file on exit
+			err = signalProcess(pid, syscall.SIGTERM)
 		}
 
-		err = cmd.Start()
 		if err == nil {
-			fmt.Printf("PID %s has been stopped!\n", pid)
-			os.Remove(pidFile)
+			fmt.Printf("PID %s is stopping!\n", pid)
 		} else {
-			fmt.Println("PID "+pid+" stop failed! %s\n", err)
+			fmt.Printf("PID %s stop failed! %s\n", pid, err)
 		}
 		break
 
@@ -90,3 +100,16 @@ func manageServer(dir, operate string, daemon bool) {
 		fmt.Println("Unknown operation!")
 	}
 }
+
+func signalProcess(pid string, sig os.Signal) error {
+	id, err := strconv.Atoi(pid)
+	if err != nil {
+		return err
+	}
+
+	process, err := os.FindProcess(id)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+
+	"varconf-server/core/dao"
+)
+
+// manageMigrate runs "migrate up|down|status [version]" against the database
+// of the config in dir, up goes to the latest and down one step back by default.
+func manageMigrate(dir string, args []string) {
+	configInfo := initConfig(path.Join(dir, "./config.json"))
+	if configInfo == nil {
+		fmt.Println("Migrate failed! can't read config")
+		return
+	}
+	if len(args) < 1 {
+		fmt.Println("Usage: varconf [-p dir] migrate up|down|status [version]")
+		return
+	}
+
+	target := -1
+	if len(args) > 1 {
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			fmt.Println("Migrate failed! bad version", args[1])
+			return
+		}
+		target = version
+	}
+
+	db, err := dao.OpenStorage(configInfo.DatabaseInfo.Driver, configInfo.DatabaseInfo.DataSource)
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		fmt.Println("Migrate failed!", err.Error())
+		return
+	}
+	defer db.Close()
+	schemaDao := dao.NewSchemaDao(db)
+
+	var migrations []*dao.Migration
+	switch args[0] {
+	case "up":
+		if target < 0 {
+			target = 0
+		}
+		migrations, err = schemaDao.MigrateUp(context.Background(), target)
+	case "down":
+		if target < 0 {
+			version, _ := schemaDao.Version(context.Background())
+			target = version - 1
+		}
+		migrations, err = schemaDao.MigrateDown(context.Background(), target)
+	case "status":
+		printMigrationStatus(schemaDao)
+		return
+	default:
+		fmt.Println("Unknown migrate operation!")
+		return
+	}
+
+	for _, migration := range migrations {
+		fmt.Printf("%s %d %s\n", args[0], migration.Version, migration.Name)
+	}
+	if err != nil {
+		fmt.Println("Migrate failed!", err.Error())
+		return
+	}
+	fmt.Printf("Migrated %s %d step(s)!\n", args[0], len(migrations))
+}
+
+func printMigrationStatus(schemaDao *dao.SchemaDao) {
+	statuses, err := schemaDao.MigrationStatus(context.Background())
+	if err != nil {
+		fmt.Println("Migrate failed!", err.Error())
+		return
+	}
+
+	for _, status := range statuses {
+		applied := "pending"
+		if status.ApplyTime != nil {
+			applied = status.ApplyTime.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%4d  %-24s %s\n", status.Version, status.Name, applied)
+	}
+}
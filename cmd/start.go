@@ -1,21 +1,40 @@
 package cmd
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"varconf-server/core/dao"
+	daocommon "varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+	"varconf-server/core/moudle/logger"
+	"varconf-server/core/moudle/mail"
 	"varconf-server/core/moudle/router"
 	"varconf-server/core/service"
+	"varconf-server/core/web/common"
 	"varconf-server/core/web/controller"
 	"varconf-server/core/web/interceptor"
 	"varconf-server/core/web/resolver"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	adminPasswordEnv = "VARCONF_ADMIN_PASSWORD"
+	databaseRetry    = 5 * time.Second
 )
 
 type DatabaseInfo struct {
@@ -24,19 +43,57 @@ type DatabaseInfo struct {
 }
 
 type ServerInfo struct {
-	IP     string `json:"ip"`
-	Port   int    `json:"port"`
-	Static string `json:"static"`
+	IP              string   `json:"ip"`
+	Port            int      `json:"port"`
+	Static          string   `json:"static"`
+	ShutdownTimeout int      `json:"shutdownTimeout"`
+	TrustedProxies  []string `json:"trustedProxies"`
+	MetricsPort     int      `json:"metricsPort"`
 }
 
 type ServiceInfo struct {
+	Cron        string `json:"cron"`
+	Bus         string `json:"bus"`
+	BusInterval int    `json:"busInterval"`
+	CacheSize   int    `json:"cacheSize"`
+	CacheTtl    int    `json:"cacheTtl"`
+}
+
+type MailInfo struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	TLS      bool   `json:"tls"`
+}
+
+type GitInfo struct {
+	Dir  string `json:"dir"`
 	Cron string `json:"cron"`
 }
 
+type FallbackInfo struct {
+	Dir      string `json:"dir"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+type LogInfo struct {
+	Level      string `json:"level"`
+	Format     string `json:"format"`
+	File       string `json:"file"`
+	MaxSize    int    `json:"maxSize"`
+	MaxBackups int    `json:"maxBackups"`
+}
+
 type ConfigInfo struct {
 	ServerInfo   ServerInfo   `json:"server"`
 	DatabaseInfo DatabaseInfo `json:"database"`
 	ServiceInfo  ServiceInfo  `json:"service"`
+	MailInfo     MailInfo     `json:"mail"`
+	GitInfo      GitInfo      `json:"git"`
+	FallbackInfo FallbackInfo `json:"fallback"`
+	LogInfo      LogInfo      `json:"log"`
 }
 
 func Start(configPath string) error {
@@ -45,7 +102,20 @@ func Start(configPath string) error {
 		return errors.New("can't read config")
 	}
 
-	dbConnect := initDatabase(configInfo.DatabaseInfo)
+	err := initLogger(configInfo.LogInfo)
+	if err != nil {
+		return err
+	}
+
+	trustedProxies, err := common.ParseProxies(configInfo.ServerInfo.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	if configInfo.FallbackInfo.Dir == "" {
+		configInfo.FallbackInfo.Dir = "./varconf-fallback"
+	}
+	dbConnect, dbReady := initDatabase(configInfo.DatabaseInfo, configInfo.FallbackInfo)
 	if dbConnect == nil {
 		return errors.New("database connect error")
 	}
@@ -55,9 +125,72 @@ func Start(configPath string) error {
 		return errors.New("router init error")
 	}
 
-	initMVC(routeMux, dbConnect, configInfo.ServiceInfo)
+	metricsMux := initMetricsRouter(routeMux, configInfo.ServerInfo)
+
+	hooks := initMVC(routeMux, metricsMux, dbConnect, dbReady, configInfo.ServiceInfo, configInfo.MailInfo, configInfo.GitInfo, configInfo.FallbackInfo,
+		trustedProxies)
+
+	// serve until stopped by a signal
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- routeMux.Run()
+	}()
+	if metricsMux != routeMux {
+		go func() {
+			errChan <- metricsMux.Run()
+		}()
+	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+
+	select {
+	case err := <-errChan:
+		routeMux.Stop()
+		metricsMux.Stop()
+		hooks.stop()
+		dbConnect.Close()
+		return err
+	case sig := <-signalChan:
+		logger.Info("start: shutting down", "signal", sig.String())
+	}
+
+	shutdown(routeMux, metricsMux, hooks, configInfo.ServerInfo)
+	dbConnect.Close()
+	return nil
+}
 
-	return routeMux.Run()
+// shutdownHooks are run by shutdown, drain once the listener is closed and
+// stop once the in-flight requests are done.
+type shutdownHooks struct {
+	drain func()
+	stop  func()
+}
+
+func shutdown(routeMux, metricsMux *router.Router, hooks *shutdownHooks, serverInfo ServerInfo) {
+	timeout := time.Duration(serverInfo.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// stop accepting, then let the long polls go so Shutdown isn't kept waiting
+	doneChan := make(chan error, 1)
+	go func() {
+		doneChan <- routeMux.Shutdown(ctx)
+	}()
+	hooks.drain()
+
+	err := <-doneChan
+	if err != nil {
+		logger.Error("start: shutdown error", "error", err)
+		routeMux.Stop()
+	}
+	if metricsMux != routeMux {
+		metricsMux.Stop()
+	}
+	hooks.stop()
 }
 
 func initConfig(configPath string) *ConfigInfo {
@@ -75,13 +208,111 @@ func initConfig(configPath string) *ConfigInfo {
 	return &configInfo
 }
 
-func initDatabase(database DatabaseInfo) *sql.DB {
-	db, err := sql.Open(database.Driver, database.DataSource)
+func initLogger(logInfo LogInfo) error {
+	var writer io.Writer = os.Stdout
+	if logInfo.File != "" {
+		maxSize := logInfo.MaxSize
+		if maxSize <= 0 {
+			maxSize = 100
+		}
+		rotateWriter, err := logger.NewRotateWriter(logInfo.File, int64(maxSize)<<20, logInfo.MaxBackups)
+		if err != nil {
+			return err
+		}
+		writer = rotateWriter
+	}
+
+	logger.SetDefault(logger.New(writer, logger.ParseLevel(logInfo.Level), logInfo.Format))
+	return nil
+}
+
+// initDatabase opens the database and gets it ready, the returned channel is
+// closed once it is. A database which can't be reached only stops the start
+// when there is nothing to serve without it: a read-only node or one with
+// fallback files starts, answers the clients from those files meanwhile and
+// retries the database in the background.
+func initDatabase(database DatabaseInfo, fallbackInfo FallbackInfo) (*daocommon.DB, <-chan struct{}) {
+	db, err := dao.OpenStorage(database.Driver, database.DataSource)
 	if err != nil {
-		panic(err)
+		logger.Error("start: open database error", "error", err)
+		return nil, nil
+	}
+
+	// sql.Open only checks the arguments, make sure the database answers
+	ready := make(chan struct{})
+	if err = db.Ping(); err != nil {
+		if !fallbackInfo.ReadOnly && !service.HasFallback(fallbackInfo.Dir) {
+			logger.Error("start: ping database error, no fallback releases to serve", "dir", fallbackInfo.Dir, "error", err)
+			db.Close()
+			return nil, nil
+		}
+		logger.Warn("start: ping database error, serving fallback releases", "error", err)
+		go retryDatabase(db, fallbackInfo.ReadOnly, ready)
+		return db, ready
+	}
+
+	err = prepareDatabase(db, fallbackInfo.ReadOnly)
+	if err != nil {
+		logger.Error("start: prepare database error", "error", err)
+		db.Close()
+		return nil, nil
 	}
-	db.Ping()
-	return db
+	close(ready)
+	return db, ready
+}
+
+// retryDatabase prepares the database once it answers and closes ready.
+func retryDatabase(db *daocommon.DB, readOnly bool, ready chan struct{}) {
+	for {
+		time.Sleep(databaseRetry)
+		if err := db.Ping(); err != nil {
+			logger.Warn("start: ping database error", "error", err)
+			continue
+		}
+		if err := prepareDatabase(db, readOnly); err != nil {
+			logger.Error("start: prepare database error", "error", err)
+			continue
+		}
+		logger.Info("start: database is ready")
+		close(ready)
+		return
+	}
+}
+
+// prepareDatabase brings the schema up to this build and creates the first
+// admin. A read-only node serves a replica migrated by the primary.
+func prepareDatabase(db *daocommon.DB, readOnly bool) error {
+	if readOnly {
+		return nil
+	}
+
+	migrations, err := dao.NewSchemaDao(db).MigrateUp(context.Background(), 0)
+	if err != nil {
+		return fmt.Errorf("migrate: %v", err)
+	}
+	for _, migration := range migrations {
+		logger.Info("start: applied migration", "version", migration.Version, "name", migration.Name)
+	}
+	if err = initAdmin(db); err != nil {
+		return fmt.Errorf("bootstrap admin: %v", err)
+	}
+	return nil
+}
+
+// initAdmin creates the admin of a new database, the password comes from
+// VARCONF_ADMIN_PASSWORD or is generated and printed this once.
+func initAdmin(db *daocommon.DB) error {
+	password := os.Getenv(adminPasswordEnv)
+	created, password, err := service.NewUserService(db).Bootstrap(context.Background(), password)
+	if err != nil || !created {
+		return err
+	}
+
+	if os.Getenv(adminPasswordEnv) == "" {
+		fmt.Printf("Created user admin with password %s, change it after login!\n", password)
+	}
+	logger.Info("start: created admin user")
+	return nil
 }
 
 func initRouter(serverInfo ServerInfo) *router.Router {
@@ -95,25 +326,129 @@ func initRouter(serverInfo ServerInfo) *router.Router {
 	return routeMux
 }
 
-func initMVC(routeMux *router.Router, dbConnect *sql.DB, serviceInfo ServiceInfo) {
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	routeMux.SetLogger(logger)
+// initMetricsRouter serves /metrics on server.metricsPort without any login so
+// it's scraped on an address kept off the public one. With no port it's served
+// by routeMux and needs a login like the other management routes.
+func initMetricsRouter(routeMux *router.Router, serverInfo ServerInfo) *router.Router {
+	if serverInfo.MetricsPort <= 0 {
+		return routeMux
+	}
+	metricsMux := router.NewRouter()
+	metricsMux.SetAddress(serverInfo.IP, serverInfo.MetricsPort)
+	return metricsMux
+}
+
+func initBus(dbConnect *daocommon.DB, serviceInfo ServiceInfo, readOnly bool) bus.Bus {
+	// a read-only node can't clean the events, its release cron catches up
+	var releaseBus bus.Bus
+	switch {
+	case serviceInfo.Bus == bus.DB && !readOnly:
+		releaseBus = bus.NewDbBus(dbConnect, time.Duration(serviceInfo.BusInterval)*time.Millisecond)
+	default:
+		releaseBus = bus.NewLocalBus()
+	}
+
+	err := releaseBus.Start()
+	if err != nil {
+		panic(err)
+	}
+	return releaseBus
+}
+
+func initMailer(mailInfo MailInfo) *mail.Sender {
+	if mailInfo.Host == "" {
+		return nil
+	}
+
+	port := mailInfo.Port
+	if port == 0 {
+		port = 25
+	}
+	return &mail.Sender{
+		Host:     mailInfo.Host,
+		Port:     port,
+		Username: mailInfo.Username,
+		Password: mailInfo.Password,
+		From:     mailInfo.From,
+		TLS:      mailInfo.TLS,
+	}
+}
+
+func initMVC(routeMux, metricsMux *router.Router, dbConnect *daocommon.DB, dbReady <-chan struct{}, serviceInfo ServiceInfo, mailInfo MailInfo, gitInfo GitInfo,
+	fallbackInfo FallbackInfo, trustedProxies []*net.IPNet) *shutdownHooks {
+	if gitInfo.Dir == "" {
+		gitInfo.Dir = "./varconf-git"
+	}
+	if gitInfo.Cron == "" {
+		gitInfo.Cron = "0 */1 * * * ?"
+	}
+	routeMux.SetLogger(logger.Default())
+	routeMux.SetAccessFields(interceptor.AccessFields)
 
 	homeService := service.NewHomeService(dbConnect)
 	authService := service.NewAuthService(dbConnect)
 	userService := service.NewUserService(dbConnect)
-	appService := service.NewAppService(dbConnect)
-	configService := service.NewConfigService(dbConnect)
+	eventHub := service.NewEventHub()
+	appService := service.NewAppService(dbConnect, eventHub)
+	releaseBus := initBus(dbConnect, serviceInfo, fallbackInfo.ReadOnly)
+	releaseCache := cache.NewLruCache(serviceInfo.CacheSize, time.Duration(serviceInfo.CacheTtl)*time.Second)
+	configService := service.NewConfigService(dbConnect, releaseBus, releaseCache, eventHub)
+	clientService := service.NewClientService(dbConnect)
+	webhookService := service.NewWebhookService(dbConnect, eventHub)
+	notifyService := service.NewNotifyService(dbConnect, eventHub, initMailer(mailInfo))
+	gitSyncService := service.NewGitSyncService(dbConnect, eventHub, configService, gitInfo.Dir)
+	fallbackService := service.NewFallbackService(dbConnect, eventHub, releaseBus, configService, fallbackInfo.Dir)
+	metricsService := service.NewMetricsService(eventHub, configService)
+	routeMux.SetObserver(metricsService.ObserveRequest)
+	healthService := service.NewHealthService(dbConnect, configService)
 
-	interceptor.InitApiAuthInterceptor(routeMux, authService)
+	if fallbackInfo.ReadOnly {
+		interceptor.InitReadOnlyInterceptor(routeMux)
+	}
+	interceptor.InitApiAuthInterceptor(routeMux, authService, fallbackService)
 	interceptor.InitUserAuthInterceptor(routeMux, authService)
 	resolver.InitErrorRecover(routeMux)
 
-	controller.InitHomeController(routeMux, homeService)
-	controller.InitApiController(routeMux, authService, configService)
+	controller.InitHomeController(routeMux, homeService, configService)
+	controller.InitApiController(routeMux, authService, configService, clientService, fallbackService, trustedProxies)
 	controller.InitUserController(routeMux, authService, userService)
-	controller.InitAppController(routeMux, appService, configService)
+	controller.InitAppController(routeMux, appService, configService, clientService)
 	controller.InitConfigController(routeMux, configService)
+	controller.InitWebhookController(routeMux, webhookService)
+	controller.InitSubscriptionController(routeMux, notifyService)
+	controller.InitGitSyncController(routeMux, gitSyncService)
+	controller.InitMetricsController(metricsMux, metricsService)
+	controller.InitHealthController(routeMux, healthService)
+
+	// save the releases of the apps the clients haven't asked for yet
+	go func() {
+		<-dbReady
+		if err := fallbackService.SaveAll(context.Background()); err != nil {
+			logger.Error("start: save fallback releases error", "error", err)
+		}
+	}()
 
 	configService.CronRelease(serviceInfo.Cron)
+	if !fallbackInfo.ReadOnly {
+		clientService.CronFlush(serviceInfo.Cron)
+		clientService.CronClean("@hourly")
+		webhookService.CronClean("@hourly")
+		webhookService.CronRetry("@every 1m")
+		gitSyncService.CronPull(gitInfo.Cron)
+	}
+
+	return &shutdownHooks{
+		drain: func() {
+			logger.Info("start: released long polls", "count", configService.Drain())
+		},
+		stop: func() {
+			configService.Stop()
+			if !fallbackInfo.ReadOnly {
+				clientService.Stop()
+			}
+			webhookService.Stop()
+			gitSyncService.Stop()
+			releaseBus.Stop()
+		},
+	}
 }
@@ -2,12 +2,17 @@ package dao
 
 import (
 	"bytes"
-	"database/sql"
-	"strings"
+	"context"
 
 	"varconf-server/core/dao/common"
 )
 
+const (
+	// 1-私有、2-公共命名空间
+	APP_PRIVATE = 1
+	APP_PUBLIC  = 2
+)
+
 // App信息
 type AppData struct {
 	AppId        int64           `json:"appId" DB_COL:"app_id" DB_PK:"app_id" DB_TABLE:"app"`
@@ -15,9 +20,11 @@ type AppData struct {
 	Code         string          `json:"code" DB_COL:"code"`
 	Desc         string          `json:"desc" DB_COL:"desc"`
 	ApiKey       string          `json:"apiKey" DB_COL:"api_key"`
+	Public       int             `json:"public" DB_COL:"public"`
 	CreateTime   common.JsonTime `json:"createTime" DB_COL:"create_time"`
 	UpdateTime   common.JsonTime `json:"updateTime" DB_COL:"update_time"`
 	ReleaseIndex int             `json:"releaseIndex" DB_COL:"release_index"`
+	Version      int             `json:"version" DB_COL:"version"`
 }
 
 type QueryAppData struct {
@@ -26,6 +33,7 @@ type QueryAppData struct {
 	Code     string
 	LikeName string
 	ApiKey   string
+	Public   int
 	Start    int64
 	End      int64
 }
@@ -34,75 +42,70 @@ type AppDao struct {
 	common.Dao
 }
 
-func NewAppDao(db *sql.DB) *AppDao {
-	appDao := AppDao{common.Dao{DB: db}}
+func NewAppDao(db *common.DB) *AppDao {
+	appDao := AppDao{common.NewDao(db.DB, db.Dialect)}
 	return &appDao
 }
 
-func (_self *AppDao) QueryApps(queryAppData QueryAppData) []*AppData {
+func (_self *AppDao) QueryApps(ctx context.Context, queryAppData QueryAppData) ([]*AppData, error) {
 	sql, values := _self.prepareSelectedQuery(false, queryAppData)
 	apps := make([]*AppData, 0)
-	success, err := _self.StructSelect(&apps, sql, values...)
+	err := _self.StructSelect(ctx, &apps, sql, values...)
 	if err != nil {
-		panic(err)
-	}
-	if success {
-		return apps
+		return nil, err
 	}
-	return nil
-}
-
-func (_self *AppDao) CountApps(queryAppData QueryAppData) int64 {
-	sql, values := _self.prepareSelectedQuery(true, queryAppData)
-	return _self.Count(sql, values...)
+	return apps, nil
 }
 
-func (_self *AppDao) InsertApp(app *AppData) int64 {
-	rowCnt, err := _self.StructInsert(app, false)
+// QueryApp returns the app appId, ErrNotFound when there is none.
+func (_self *AppDao) QueryApp(ctx context.Context, appId int64) (*AppData, error) {
+	appData := AppData{}
+	err := _self.StructSelectByPK(ctx, &appData, appId)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return &appData, nil
+}
 
-	return rowCnt
+func (_self *AppDao) CountApps(ctx context.Context, queryAppData QueryAppData) (int64, error) {
+	sql, values := _self.prepareSelectedQuery(true, queryAppData)
+	return _self.Count(ctx, sql, values...)
 }
 
-func (_self *AppDao) SelectedUpdateApp(app AppData) int64 {
-	sql, values := _self.prepareSelectedUpdate(app)
-	rowCnt, err := _self.Exec(sql, values...)
-	if err != nil {
-		panic(err)
-	}
+func (_self *AppDao) InsertApp(ctx context.Context, app *AppData) (int64, error) {
+	return _self.StructInsert(ctx, app, false)
+}
 
-	return rowCnt
+// SelectedUpdateApp updates the fields of app and bumps its version, no row is
+// updated when app.Version is set and stale.
+func (_self *AppDao) SelectedUpdateApp(ctx context.Context, app AppData, fields common.Fields) (int64, error) {
+	sql, values := _self.prepareSelectedUpdate(app, fields)
+	return _self.Exec(ctx, sql, values...)
 }
 
-func (_self *AppDao) DeleteApp(appId int64) int64 {
+func (_self *AppDao) DeleteApp(ctx context.Context, appId int64) (int64, error) {
 	sql := "DELETE FROM `app` WHERE `app_id` = ?"
-	rowCnt, err := _self.Exec(sql, appId)
-	if err != nil {
-		panic(err)
-	}
-
-	return rowCnt
+	return _self.Exec(ctx, sql, appId)
 }
 
-func (_self *AppDao) ReleaseIndex(appId int64) int {
-	apps := _self.QueryApps(QueryAppData{AppId: appId})
-	if len(apps) != 1 {
-		return -1
+// ReleaseIndex bumps the release index of appId, ErrConflict when another
+// release bumped it first.
+func (_self *AppDao) ReleaseIndex(ctx context.Context, appId int64) (int, error) {
+	appData, err := _self.QueryApp(ctx, appId)
+	if err != nil {
+		return -1, err
 	}
-	appData := apps[0]
 
 	sql := "UPDATE `app` SET `release_index` = `release_index` + 1 WHERE `app_id` = ? And `release_index` = ?"
-	rowCnt, err := _self.Exec(sql, appData.AppId, appData.ReleaseIndex)
+	rowCnt, err := _self.Exec(ctx, sql, appData.AppId, appData.ReleaseIndex)
 	if err != nil {
-		panic(err)
+		return -1, err
 	}
 	if rowCnt != 1 {
-		return -1
+		return -1, common.Conflict("release index changed")
 	}
 
-	return appData.ReleaseIndex + 1
+	return appData.ReleaseIndex + 1, nil
 }
 
 func (_self *AppDao) prepareSelectedQuery(count bool, queryAppData QueryAppData) (string, []interface{}) {
@@ -125,50 +128,63 @@ func (_self *AppDao) prepareSelectedQuery(count bool, queryAppData QueryAppData)
 		values = append(values, queryAppData.Name)
 	}
 	if queryAppData.LikeName != "" {
-		buffer.WriteString(" AND `name` like '")
-		buffer.WriteString(queryAppData.LikeName)
-		buffer.WriteString("%'")
+		like, likeValues := _self.LikePrefix("name", queryAppData.LikeName)
+		buffer.WriteString(" AND" + like)
+		values = append(values, likeValues...)
 	}
 	if queryAppData.ApiKey != "" {
 		buffer.WriteString(" AND `api_key` = ?")
 		values = append(values, queryAppData.ApiKey)
 	}
+	if queryAppData.Public != 0 {
+		buffer.WriteString(" AND `public` = ?")
+		values = append(values, queryAppData.Public)
+	}
 	if queryAppData.Start >= 0 && queryAppData.End > 0 {
-		buffer.WriteString(" LIMIT ?, ?")
-		values = append(values, queryAppData.Start, queryAppData.End)
+		limit, limitValues := _self.Limit(queryAppData.Start, queryAppData.End)
+		buffer.WriteString(limit)
+		values = append(values, limitValues...)
 	}
 
 	return buffer.String(), values
 }
 
-func (_self *AppDao) prepareSelectedUpdate(app AppData) (string, []interface{}) {
+func (_self *AppDao) prepareSelectedUpdate(app AppData, fields common.Fields) (string, []interface{}) {
 	buffer := bytes.Buffer{}
 	buffer.WriteString("UPDATE `app` SET ")
 
 	values := make([]interface{}, 0)
-	if app.Name != "" {
+	if fields.Has("name") {
 		values = append(values, app.Name)
 		buffer.WriteString("`name` = ?,")
 	}
-	if app.Desc != "" {
+	if fields.Has("desc") {
 		values = append(values, app.Desc)
 		buffer.WriteString("`desc` = ?,")
 	}
-	if app.ApiKey != "" {
+	if fields.Has("apiKey") {
 		values = append(values, app.ApiKey)
 		buffer.WriteString("`api_key` = ?,")
 	}
-	if !app.CreateTime.IsZero() {
+	if fields.Has("public") {
+		values = append(values, app.Public)
+		buffer.WriteString("`public` = ?,")
+	}
+	if fields.Has("createTime") {
 		values = append(values, app.CreateTime)
 		buffer.WriteString("`create_time` = ?,")
 	}
-	if !app.UpdateTime.IsZero() {
+	if fields.Has("updateTime") {
 		values = append(values, app.UpdateTime)
 		buffer.WriteString("`update_time` = ?,")
 	}
 
-	sql := strings.TrimSuffix(buffer.String(), ",") + " WHERE `app_id` = ?"
+	buffer.WriteString("`version` = `version` + 1 WHERE `app_id` = ?")
 	values = append(values, app.AppId)
+	if app.Version != 0 {
+		buffer.WriteString(" AND `version` = ?")
+		values = append(values, app.Version)
+	}
 
-	return sql, values
+	return buffer.String(), values
 }
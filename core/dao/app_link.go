@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"context"
+
+	"varconf-server/core/dao/common"
+)
+
+// App关联的公共命名空间
+type AppLinkData struct {
+	Id         int64           `json:"id" DB_COL:"id" DB_PK:"id" DB_TABLE:"app_link"`
+	AppId      int64           `json:"appId" DB_COL:"app_id"`
+	LinkAppId  int64           `json:"linkAppId" DB_COL:"link_app_id"`
+	CreateTime common.JsonTime `json:"createTime" DB_COL:"create_time"`
+	CreateBy   string          `json:"createBy" DB_COL:"create_by"`
+}
+
+type AppLinkDao struct {
+	common.Dao
+}
+
+func NewAppLinkDao(db *common.DB) *AppLinkDao {
+	appLinkDao := AppLinkDao{common.NewDao(db.DB, db.Dialect)}
+	return &appLinkDao
+}
+
+// QueryAppLinks returns the namespaces linked by app.
+func (_self *AppLinkDao) QueryAppLinks(ctx context.Context, appId int64) ([]*AppLinkData, error) {
+	sql := "SELECT * FROM `app_link` WHERE `app_id` = ? ORDER BY `id`"
+
+	appLinks := make([]*AppLinkData, 0)
+	err := _self.StructSelect(ctx, &appLinks, sql, appId)
+	if err != nil {
+		return nil, err
+	}
+	return appLinks, nil
+}
+
+// QueryLinkedApps returns the links to the namespace.
+func (_self *AppLinkDao) QueryLinkedApps(ctx context.Context, linkAppId int64) ([]*AppLinkData, error) {
+	sql := "SELECT * FROM `app_link` WHERE `link_app_id` = ? ORDER BY `id`"
+
+	appLinks := make([]*AppLinkData, 0)
+	err := _self.StructSelect(ctx, &appLinks, sql, linkAppId)
+	if err != nil {
+		return nil, err
+	}
+	return appLinks, nil
+}
+
+func (_self *AppLinkDao) InsertAppLink(ctx context.Context, data *AppLinkData) (int64, error) {
+	return _self.StructInsert(ctx, data, false)
+}
+
+func (_self *AppLinkDao) DeleteAppLink(ctx context.Context, appId, linkAppId int64) (int64, error) {
+	sql := "DELETE FROM `app_link` WHERE `app_id` = ? AND `link_app_id` = ?"
+	return _self.Exec(ctx, sql, appId, linkAppId)
+}
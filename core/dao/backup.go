@@ -0,0 +1,207 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"varconf-server/core/dao/common"
+)
+
+// BACKUP_FORMAT is the version of the backup archive layout.
+const BACKUP_FORMAT = 1
+
+// 备份数据
+type BackupData struct {
+	Format      int               `json:"format"`
+	Schema      int               `json:"schema"`
+	CreateTime  common.JsonTime   `json:"createTime"`
+	Apps        []*AppData        `json:"apps"`
+	AppLinks    []*AppLinkData    `json:"appLinks"`
+	Configs     []*ConfigData     `json:"configs"`
+	Releases    []*ReleaseData    `json:"releases"`
+	ReleaseLogs []*ReleaseLogData `json:"releaseLogs"`
+	Users       []*UserData       `json:"users"`
+}
+
+type BackupDao struct {
+	common.Dao
+}
+
+func NewBackupDao(db *common.DB) *BackupDao {
+	backupDao := BackupDao{common.NewDao(db.DB, db.Dialect)}
+	return &backupDao
+}
+
+// Dump reads the apps, links, configs, releases, release logs and users in
+// one repeatable read transaction, so the archive is a single point in time.
+// Sqlite ignores the level, its transactions are serializable.
+func (_self *BackupDao) Dump(ctx context.Context) (*BackupData, error) {
+	tx, err := _self.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	schema, err := _self.CountWithTx(ctx, tx, "SELECT COALESCE(MAX(`version`), 0) FROM `schema_version`")
+	if err != nil {
+		return nil, err
+	}
+	backupData := &BackupData{Format: BACKUP_FORMAT, Schema: int(schema), CreateTime: common.NowJsonTime()}
+
+	tables := []struct {
+		dst interface{}
+		sql string
+	}{
+		{&backupData.Apps, "SELECT * FROM `app` ORDER BY `app_id`"},
+		{&backupData.AppLinks, "SELECT * FROM `app_link` ORDER BY `id`"},
+		{&backupData.Configs, "SELECT * FROM `config` ORDER BY `config_id`"},
+		{&backupData.Releases, "SELECT * FROM `release` ORDER BY `app_id`"},
+		{&backupData.ReleaseLogs, "SELECT * FROM `release_log` ORDER BY `id`"},
+		{&backupData.Users, "SELECT * FROM `user` ORDER BY `user_id`"},
+	}
+	for _, table := range tables {
+		err = _self.StructSelectWithTx(ctx, tx, table.dst, table.sql)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return backupData, nil
+}
+
+// Restore writes backupData into an empty database in one transaction, it
+// runs before the first start of the server since the start bootstraps an
+// admin into the user table. The rows get new ids, the references to apps and
+// configs, those inside the released config lists included, are remapped to
+// them.
+func (_self *BackupDao) Restore(ctx context.Context, backupData *BackupData) (err error) {
+	if backupData.Format != BACKUP_FORMAT {
+		return fmt.Errorf("unknown backup format %d", backupData.Format)
+	}
+	if backupData.Schema > LatestVersion() {
+		return fmt.Errorf("backup of schema version %d, newer than %d", backupData.Schema, LatestVersion())
+	}
+
+	tx, err := _self.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, table := range []string{"app", "app_link", "config", "release", "release_log", "user"} {
+		var count int64
+		count, err = _self.CountWithTx(ctx, tx, "SELECT COUNT(1) FROM `"+table+"`")
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			err = common.Conflict("table " + table + " isn't empty, restore before the server starts on the database")
+			return err
+		}
+	}
+
+	// apps first, the other rows point at them
+	appIds := make(map[int64]int64)
+	for _, appData := range backupData.Apps {
+		oldId := appData.AppId
+		if appData.Version == 0 {
+			appData.Version = 1
+		}
+		_, err = _self.StructInsertWithTx(ctx, tx, appData, false)
+		if err != nil {
+			return err
+		}
+		appIds[oldId] = appData.AppId
+	}
+
+	for _, appLink := range backupData.AppLinks {
+		appLink.AppId, appLink.LinkAppId = appIds[appLink.AppId], appIds[appLink.LinkAppId]
+		_, err = _self.StructInsertWithTx(ctx, tx, appLink, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	configIds := make(map[int64]int64)
+	for _, config := range backupData.Configs {
+		oldId := config.ConfigId
+		config.AppId = appIds[config.AppId]
+		if config.Version == 0 {
+			config.Version = 1
+		}
+		_, err = _self.StructInsertWithTx(ctx, tx, config, false)
+		if err != nil {
+			return err
+		}
+		configIds[oldId] = config.ConfigId
+	}
+
+	for _, releaseData := range backupData.Releases {
+		releaseData.AppId = appIds[releaseData.AppId]
+		releaseData.ConfigList, err = remapConfigList(releaseData.ConfigList, appIds, configIds)
+		if err != nil {
+			return err
+		}
+		_, err = _self.StructInsertWithTx(ctx, tx, releaseData, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, releaseLog := range backupData.ReleaseLogs {
+		releaseLog.AppId = appIds[releaseLog.AppId]
+		releaseLog.ConfigList, err = remapConfigList(releaseLog.ConfigList, appIds, configIds)
+		if err != nil {
+			return err
+		}
+		_, err = _self.StructInsertWithTx(ctx, tx, releaseLog, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, user := range backupData.Users {
+		_, err = _self.StructInsertWithTx(ctx, tx, user, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = _self.Commit(tx)
+	return err
+}
+
+// remapConfigList rewrites the appId and configId of the released configs in
+// configList, leaving their other fields as they are. A config deleted since
+// the release has no new id and gets 0.
+func remapConfigList(configList string, appIds, configIds map[int64]int64) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(configList)))
+	decoder.UseNumber()
+	configs := make([]map[string]interface{}, 0)
+	if err := decoder.Decode(&configs); err != nil {
+		return "", fmt.Errorf("bad config list: %v", err)
+	}
+
+	for _, config := range configs {
+		if id, ok := config["appId"].(json.Number); ok {
+			oldId, _ := id.Int64()
+			config["appId"] = appIds[oldId]
+		}
+		if id, ok := config["configId"].(json.Number); ok {
+			oldId, _ := id.Int64()
+			config["configId"] = configIds[oldId]
+		}
+	}
+
+	data, err := json.Marshal(configs)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
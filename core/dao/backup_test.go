@@ -0,0 +1,171 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"varconf-server/core/dao/common"
+)
+
+func openMigratedDb(t *testing.T) (*common.DB, func()) {
+	t.Helper()
+	db, closeDb := openEmptyDb(t)
+	if _, err := NewSchemaDao(db).MigrateUp(context.Background(), 0); err != nil {
+		closeDb()
+		t.Fatal(err)
+	}
+	return db, closeDb
+}
+
+// dumpAgain dumps db and decodes the archive as the restore command does.
+func dumpAgain(t *testing.T, db *common.DB) *BackupData {
+	t.Helper()
+	backupData, err := NewBackupDao(db).Dump(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(backupData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &BackupData{}
+	if err = json.Unmarshal(content, decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// TestBackupRoundTrip restores the dump of a database whose first app and
+// config were deleted, so every id moves in the fresh database.
+func TestBackupRoundTrip(t *testing.T) {
+	source, closeSource := openMigratedDb(t)
+	defer closeSource()
+	ctx := context.Background()
+
+	newApp := func(code string, public int) *AppData {
+		app := &AppData{Name: code, Code: code, ApiKey: "key-" + code, Public: public, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+		if _, err := NewAppDao(source).InsertApp(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+		return app
+	}
+	newConfig := func(appId int64, key, value string) *ConfigData {
+		config := &ConfigData{AppId: appId, Key: key, Value: value, Type: TYPE_TEXT, Status: STATUS_UN, Operate: OPERATE_NEW,
+			CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+		if _, err := NewConfigDao(source).InsertConfig(ctx, config); err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	gone := newApp("gone", APP_PRIVATE)
+	newConfig(gone.AppId, "gone", "x")
+	if err := NewManageTxDao(source).DeleteApp(ctx, gone.AppId); err != nil {
+		t.Fatal(err)
+	}
+	shared := newApp("shared", APP_PUBLIC)
+	service := newApp("service", APP_PRIVATE)
+	newConfig(shared.AppId, "db.host", "10.0.0.1")
+	newConfig(service.AppId, "timeout", "1s")
+	link := &AppLinkData{AppId: service.AppId, LinkAppId: shared.AppId, CreateTime: common.NowJsonTime(), CreateBy: "alice"}
+	if _, err := NewAppLinkDao(source).InsertAppLink(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, app := range []*AppData{shared, service} {
+		if _, _, err := NewManageTxDao(source).ReleaseConfig(ctx, app.AppId, 0, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user := &UserData{Name: "alice", Password: "secret", Permission: 1, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	if _, err := NewUserDao(source).InsertUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	backupData := dumpAgain(t, source)
+	if backupData.Schema != LatestVersion() || len(backupData.Apps) != 2 || len(backupData.Configs) != 2 {
+		t.Fatalf("dumped schema %d with %d apps and %d configs", backupData.Schema, len(backupData.Apps), len(backupData.Configs))
+	}
+
+	target, closeTarget := openMigratedDb(t)
+	defer closeTarget()
+	if err := NewBackupDao(target).Restore(ctx, backupData); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := dumpAgain(t, target)
+	appIds := make(map[string]int64)
+	for _, app := range restored.Apps {
+		appIds[app.Code] = app.AppId
+	}
+	if appIds["shared"] == shared.AppId || appIds["service"] == service.AppId {
+		t.Fatalf("restored apps kept the ids %v, the fixture doesn't move them", appIds)
+	}
+	if len(restored.AppLinks) != 1 || restored.AppLinks[0].AppId != appIds["service"] || restored.AppLinks[0].LinkAppId != appIds["shared"] {
+		t.Fatalf("restored links %+v, want service linking shared", restored.AppLinks)
+	}
+	configApps := map[string]string{"db.host": "shared", "timeout": "service"}
+	configIds := make(map[string]int64)
+	for _, config := range restored.Configs {
+		if config.AppId != appIds[configApps[config.Key]] {
+			t.Fatalf("config %s points at app %d", config.Key, config.AppId)
+		}
+		configIds[config.Key] = config.ConfigId
+	}
+
+	// the release of service merges db.host of shared, both point at the new rows
+	releaseData, err := NewReleaseDao(target).QueryRelease(ctx, appIds["service"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	released := make([]ConfigData, 0)
+	if err = json.Unmarshal([]byte(releaseData.ConfigList), &released); err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 2 {
+		t.Fatalf("released %d configs, want 2", len(released))
+	}
+	for _, config := range released {
+		wantApp := appIds[configApps[config.Key]]
+		if config.AppId != wantApp || config.ConfigId != configIds[config.Key] {
+			t.Fatalf("released %s points at app %d config %d, want %d and %d", config.Key, config.AppId, config.ConfigId, wantApp, configIds[config.Key])
+		}
+	}
+	releaseLog, err := NewReleaseLogDao(target).QueryReleaseLog(ctx, appIds["service"], releaseData.ReleaseIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if releaseLog.ConfigList != releaseData.ConfigList {
+		t.Fatalf("release log holds %s, want %s", releaseLog.ConfigList, releaseData.ConfigList)
+	}
+	if len(restored.Users) != 1 || restored.Users[0].Name != "alice" || restored.Users[0].Password != "secret" {
+		t.Fatalf("restored users %+v", restored.Users)
+	}
+
+	// the restored database isn't empty any more
+	err = NewBackupDao(target).Restore(ctx, dumpAgain(t, source))
+	if !errors.Is(err, common.ErrConflict) {
+		t.Fatalf("restored into a database with data, %v", err)
+	}
+	if again := dumpAgain(t, target); len(again.Apps) != 2 {
+		t.Fatalf("refused restore left %d apps", len(again.Apps))
+	}
+}
+
+func TestRestoreNewerSchema(t *testing.T) {
+	db, closeDb := openMigratedDb(t)
+	defer closeDb()
+
+	backupData := dumpAgain(t, db)
+	backupData.Schema = LatestVersion() + 1
+	backupData.Apps = []*AppData{{Name: "a", Code: "a", ApiKey: "k", CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}}
+	err := NewBackupDao(db).Restore(context.Background(), backupData)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("restored a backup of a newer schema, %v", err)
+	}
+	if count, _ := NewAppDao(db).Count(context.Background(), "SELECT COUNT(1) FROM `app`"); count != 0 {
+		t.Fatalf("refused restore wrote %d apps", count)
+	}
+}
@@ -0,0 +1,49 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"varconf-server/core/dao/common"
+)
+
+// 客户端实例
+type ClientData struct {
+	AppId        int64           `json:"appId" DB_COL:"app_id" DB_PK:"app_id,instance_id" DB_TABLE:"client"`
+	InstanceId   string          `json:"instanceId" DB_COL:"instance_id"`
+	Ip           string          `json:"ip" DB_COL:"ip"`
+	Hostname     string          `json:"hostname" DB_COL:"hostname"`
+	SdkVersion   string          `json:"sdkVersion" DB_COL:"sdk_version"`
+	LastIndex    int             `json:"lastIndex" DB_COL:"last_index"`
+	LastSeenTime common.JsonTime `json:"lastSeenTime" DB_COL:"last_seen_time"`
+}
+
+type ClientDao struct {
+	common.Dao
+}
+
+func NewClientDao(db *common.DB) *ClientDao {
+	clientDao := ClientDao{common.NewDao(db.DB, db.Dialect)}
+	return &clientDao
+}
+
+func (_self *ClientDao) QueryClients(ctx context.Context, appId int64) ([]*ClientData, error) {
+	sql := "SELECT * FROM `client` WHERE `app_id` = ? ORDER BY `last_seen_time` DESC"
+
+	clients := make([]*ClientData, 0)
+	err := _self.StructSelect(ctx, &clients, sql, appId)
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (_self *ClientDao) UpsertClient(ctx context.Context, data *ClientData) (int64, error) {
+	return _self.StructUpsert(ctx, data)
+}
+
+// DeleteClients drops the clients last seen before before.
+func (_self *ClientDao) DeleteClients(ctx context.Context, before time.Time) (int64, error) {
+	sql := "DELETE FROM `client` WHERE `last_seen_time` < ?"
+	return _self.Exec(ctx, sql, before)
+}
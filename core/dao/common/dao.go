@@ -2,15 +2,34 @@ package common
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
+
+	"varconf-server/core/moudle/logger"
 )
 
 type Dao struct {
-	DB *sql.DB
+	DB      *sql.DB
+	dialect Dialect
+}
+
+// NewDao returns a dao running the statements on db in dialect.
+func NewDao(db *sql.DB, dialect Dialect) Dao {
+	return Dao{DB: db, dialect: dialect}
+}
+
+// QueryObserver is told how long each statement took, by its operation and table.
+type QueryObserver func(operation, table string, duration time.Duration)
+
+var queryObserver QueryObserver
+
+func SetQueryObserver(observer QueryObserver) {
+	queryObserver = observer
 }
 
 const (
@@ -19,31 +38,46 @@ const (
 	PK    = "DB_PK"
 )
 
-func (_self *Dao) Insert(sql string, args ...interface{}) (int64, int64, error) {
-	stmt, err := _self.DB.Prepare(sql)
-	if err != nil {
-		return 0, 0, err
-	}
-	return _self.insertWithStmt(stmt, args)
+// executor is what a statement runs on, the db or a transaction.
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-func (_self *Dao) InsertWithTx(tx *sql.Tx, sql string, args ...interface{}) (int64, int64, error) {
-	stmt, err := tx.Prepare(sql)
-	if err != nil {
-		return 0, 0, err
-	}
-	return _self.insertWithStmt(stmt, args)
+func (_self *Dao) Insert(ctx context.Context, sql string, args ...interface{}) (lastId int64, rowCnt int64, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	return _self.insert(ctx, _self.DB, sql, args)
 }
 
-func (_self *Dao) insertWithStmt(stmt *sql.Stmt, args []interface{}) (int64, int64, error) {
-	res, err := stmt.Exec(args...)
-	if err != nil {
-		return 0, 0, err
+func (_self *Dao) InsertWithTx(ctx context.Context, tx *sql.Tx, sql string, args ...interface{}) (lastId int64, rowCnt int64, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	return _self.insert(ctx, tx, sql, args)
+}
+
+func (_self *Dao) insert(ctx context.Context, e executor, sql string, args []interface{}) (int64, int64, error) {
+	// drivers without LastInsertId answer the pk through a RETURNING clause
+	returning := _self.Dialect().Returning("") != ""
+	if returning && strings.Contains(sql, " RETURNING ") {
+		var lastId int64
+		err := e.QueryRowContext(ctx, _self.Dialect().Rebind(sql), args...).Scan(&lastId)
+		if err != nil {
+			return 0, 0, err
+		}
+		return lastId, 1, nil
 	}
-	lastId, err := res.LastInsertId()
+
+	res, err := e.ExecContext(ctx, _self.Dialect().Rebind(sql), args...)
 	if err != nil {
 		return 0, 0, err
 	}
+	var lastId int64
+	if !returning {
+		lastId, err = res.LastInsertId()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
 	rowCnt, err := res.RowsAffected()
 	if err != nil {
 		return 0, 0, err
@@ -51,24 +85,18 @@ func (_self *Dao) insertWithStmt(stmt *sql.Stmt, args []interface{}) (int64, int
 	return lastId, rowCnt, err
 }
 
-func (_self *Dao) Exec(sql string, args ...interface{}) (int64, error) {
-	stmt, err := _self.DB.Prepare(sql)
-	if err != nil {
-		return 0, err
-	}
-	return _self.execWithStmt(stmt, args)
+func (_self *Dao) Exec(ctx context.Context, sql string, args ...interface{}) (rowCnt int64, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	return _self.exec(ctx, _self.DB, sql, args)
 }
 
-func (_self *Dao) ExecWithTx(tx *sql.Tx, sql string, args ...interface{}) (int64, error) {
-	stmt, err := tx.Prepare(sql)
-	if err != nil {
-		return 0, err
-	}
-	return _self.execWithStmt(stmt, args)
+func (_self *Dao) ExecWithTx(ctx context.Context, tx *sql.Tx, sql string, args ...interface{}) (rowCnt int64, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	return _self.exec(ctx, tx, sql, args)
 }
 
-func (_self *Dao) execWithStmt(stmt *sql.Stmt, args []interface{}) (int64, error) {
-	res, err := stmt.Exec(args...)
+func (_self *Dao) exec(ctx context.Context, e executor, sql string, args []interface{}) (int64, error) {
+	res, err := e.ExecContext(ctx, _self.Dialect().Rebind(sql), args...)
 	if err != nil {
 		return 0, err
 	}
@@ -79,30 +107,73 @@ func (_self *Dao) execWithStmt(stmt *sql.Stmt, args []interface{}) (int64, error
 	return rowCnt, err
 }
 
-func (_self *Dao) Query(sql string, args ...interface{}) (*sql.Rows, error) {
-	return _self.DB.Query(sql, args...)
+func (_self *Dao) Query(ctx context.Context, sql string, args ...interface{}) (rows *sql.Rows, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	return _self.DB.QueryContext(ctx, _self.Dialect().Rebind(sql), args...)
 }
 
-func (_self *Dao) QueryWithTx(tx *sql.Tx, sql string, args ...interface{}) (*sql.Rows, error) {
-	return tx.Query(sql, args...)
+func (_self *Dao) QueryWithTx(ctx context.Context, tx *sql.Tx, sql string, args ...interface{}) (rows *sql.Rows, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	return tx.QueryContext(ctx, _self.Dialect().Rebind(sql), args...)
 }
 
-func (_self *Dao) Count(sql string, args ...interface{}) int64 {
-	row := _self.DB.QueryRow(sql, args...)
-	count := int64(0)
-	err := row.Scan(&count)
+func (_self *Dao) Count(ctx context.Context, sql string, args ...interface{}) (count int64, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	err = _self.DB.QueryRowContext(ctx, _self.Dialect().Rebind(sql), args...).Scan(&count)
 	if err != nil {
-		return 0
+		return 0, err
 	}
-	return count
+	return count, nil
 }
 
-func (_self *Dao) StructInsert(src interface{}, usePK bool) (int64, error) {
+func (_self *Dao) CountWithTx(ctx context.Context, tx *sql.Tx, sql string, args ...interface{}) (count int64, err error) {
+	defer _self.observe(sql, time.Now(), &err)
+	err = tx.QueryRowContext(ctx, _self.Dialect().Rebind(sql), args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Begin starts a transaction bound to ctx.
+func (_self *Dao) Begin(ctx context.Context) (tx *sql.Tx, err error) {
+	return _self.BeginTx(ctx, nil)
+}
+
+// BeginTx starts a transaction bound to ctx with the isolation level and
+// access mode of opts.
+func (_self *Dao) BeginTx(ctx context.Context, opts *sql.TxOptions) (tx *sql.Tx, err error) {
+	tx, err = _self.DB.BeginTx(ctx, opts)
+	return tx, wrapError(_self.Dialect(), err)
+}
+
+// Commit commits tx, classifying the error like a statement.
+func (_self *Dao) Commit(tx *sql.Tx) error {
+	return wrapError(_self.Dialect(), tx.Commit())
+}
+
+func (_self *Dao) Dialect() Dialect {
+	return _self.dialect
+}
+
+// Limit returns the paging clause and its args for the rows [start, start+count).
+func (_self *Dao) Limit(start, count int64) (string, []interface{}) {
+	return _self.Dialect().Limit(start, count)
+}
+
+// LikePrefix returns the condition and its arg matching the values of column
+// starting with prefix, the wildcards in prefix match themselves.
+func (_self *Dao) LikePrefix(column, prefix string) (string, []interface{}) {
+	replacer := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return " `" + column + "` LIKE ? ESCAPE '!'", []interface{}{replacer.Replace(prefix) + "%"}
+}
+
+func (_self *Dao) StructInsert(ctx context.Context, src interface{}, usePK bool) (int64, error) {
 	// Parse struct Insert
 	pk, mapper, sql, values := _self.structInsertParse(src, usePK)
 
 	// Exec sql
-	lastId, rowCnt, err := _self.Insert(sql, values...)
+	lastId, rowCnt, err := _self.Insert(ctx, sql, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -113,12 +184,12 @@ func (_self *Dao) StructInsert(src interface{}, usePK bool) (int64, error) {
 	return rowCnt, nil
 }
 
-func (_self *Dao) StructInsertWithTx(tx *sql.Tx, src interface{}, usePK bool) (int64, error) {
+func (_self *Dao) StructInsertWithTx(ctx context.Context, tx *sql.Tx, src interface{}, usePK bool) (int64, error) {
 	// Parse struct Insert
 	pk, mapper, sql, values := _self.structInsertParse(src, usePK)
 
 	// Exec sql
-	lastId, rowCnt, err := _self.InsertWithTx(tx, sql, values...)
+	lastId, rowCnt, err := _self.InsertWithTx(ctx, tx, sql, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -146,11 +217,14 @@ func (_self *Dao) structInsertParse(src interface{}, usePK bool) (string, map[st
 		values = append(values, v.Addr().Interface())
 	}
 	sql := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s) ", table, strings.Trim(col.String(), ", "), strings.Trim(val.String(), ", "))
+	if pk != "" && !usePK && !strings.Contains(pk, ",") {
+		sql += _self.Dialect().Returning(pk)
+	}
 
 	return pk, mapper, sql, values
 }
 
-func (_self *Dao) StructBatchInsert(usePK bool, beans ...interface{}) (int64, error) {
+func (_self *Dao) StructBatchInsert(ctx context.Context, usePK bool, beans ...interface{}) (int64, error) {
 	if len(beans) < 1 {
 		return 0, errors.New("no element")
 	}
@@ -182,7 +256,7 @@ func (_self *Dao) StructBatchInsert(usePK bool, beans ...interface{}) (int64, er
 	sql = strings.Trim(sql, ", ")
 
 	// Exec sql
-	_, rowCnt, err := _self.Insert(sql, values...)
+	_, rowCnt, err := _self.Insert(ctx, sql, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -190,7 +264,7 @@ func (_self *Dao) StructBatchInsert(usePK bool, beans ...interface{}) (int64, er
 	return rowCnt, nil
 }
 
-func (_self *Dao) StructUpdateByPK(src interface{}) (int64, error) {
+func (_self *Dao) StructUpdateByPK(ctx context.Context, src interface{}) (int64, error) {
 	table, pk, mapper := _self.structReflect(src)
 
 	// Concat sql string
@@ -209,7 +283,7 @@ func (_self *Dao) StructUpdateByPK(src interface{}) (int64, error) {
 	sql := fmt.Sprintf("UPDATE `%s` SET %s WHERE %s = ?", table, strings.Trim(col.String(), ", "), pk)
 
 	// Exec sql
-	rowCnt, err := _self.Exec(sql, values...)
+	rowCnt, err := _self.Exec(ctx, sql, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -217,12 +291,12 @@ func (_self *Dao) StructUpdateByPK(src interface{}) (int64, error) {
 	return rowCnt, nil
 }
 
-func (_self *Dao) StructUpsert(src interface{}) (int64, error) {
+func (_self *Dao) StructUpsert(ctx context.Context, src interface{}) (int64, error) {
 	// Parse upsert param
 	sql, values := _self.structUpsertParse(src)
 
 	// Exec sql
-	rowCnt, err := _self.Exec(sql, values...)
+	rowCnt, err := _self.Exec(ctx, sql, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -230,12 +304,12 @@ func (_self *Dao) StructUpsert(src interface{}) (int64, error) {
 	return rowCnt, nil
 }
 
-func (_self *Dao) StructUpsertWithTx(tx *sql.Tx, src interface{}) (int64, error) {
+func (_self *Dao) StructUpsertWithTx(ctx context.Context, tx *sql.Tx, src interface{}) (int64, error) {
 	// Parse upsert param
 	sql, values := _self.structUpsertParse(src)
 
 	// Exec sql
-	rowCnt, err := _self.ExecWithTx(tx, sql, values...)
+	rowCnt, err := _self.ExecWithTx(ctx, tx, sql, values...)
 	if err != nil {
 		return 0, err
 	}
@@ -245,84 +319,97 @@ func (_self *Dao) StructUpsertWithTx(tx *sql.Tx, src interface{}) (int64, error)
 
 func (_self *Dao) structUpsertParse(src interface{}) (string, []interface{}) {
 	// Get COL Mapper
-	table, _, mapper := _self.structReflect(src)
+	table, pk, mapper := _self.structReflect(src)
 
 	// Concat sql string
-	var col1 bytes.Buffer
-	var col2 bytes.Buffer
-	var val bytes.Buffer
-	values1 := make([]interface{}, 0)
-	values2 := make([]interface{}, 0)
+	columns := make([]string, 0, len(mapper))
+	values := make([]interface{}, 0, len(mapper))
 	for k, v := range mapper {
-		col1.WriteString(fmt.Sprintf("`%s`, ", k))
-		col2.WriteString(fmt.Sprintf("`%s` = ?, ", k))
-		val.WriteString("?, ")
-		values1 = append(values1, v.Addr().Interface())
-		values2 = append(values2, v.Addr().Interface())
+		columns = append(columns, k)
+		values = append(values, v.Addr().Interface())
 	}
-	sql := fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s", table, strings.Trim(col1.String(), ", "), strings.Trim(val.String(), ", "), strings.Trim(col2.String(), ", "))
-	values1 = append(values1, values2...)
+	sql := _self.Dialect().Upsert(table, strings.Split(pk, ","), columns)
 
-	return sql, values1
+	return sql, values
 }
 
-func (_self *Dao) StructSelectByPK(dst interface{}, arg interface{}) (bool, error) {
+// StructSelectByPK scans the row with the primary key arg into dst, ErrNotFound
+// when there is none.
+func (_self *Dao) StructSelectByPK(ctx context.Context, dst interface{}, arg interface{}) error {
 	table, pk, _ := _self.structReflect(dst)
 	sql := fmt.Sprintf("SELECT * FROM `%s` WHERE %s = ?", table, pk)
 
-	rows, err := _self.Query(sql, arg)
+	rows, err := _self.Query(ctx, sql, arg)
 	if err != nil {
-		return false, nil
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		err := _self.structScan(rows, dst)
-		if err != nil {
-			return false, err
-		}
-		return true, nil
+		return err
 	}
-	return false, nil
+	return _self.structScanOne(rows, table, dst)
 }
 
-func (_self *Dao) StructSelectByPKWithTx(tx *sql.Tx, dst interface{}, arg interface{}) (bool, error) {
+func (_self *Dao) StructSelectByPKWithTx(ctx context.Context, tx *sql.Tx, dst interface{}, arg interface{}) error {
 	table, pk, _ := _self.structReflect(dst)
 	sql := fmt.Sprintf("SELECT * FROM `%s` WHERE %s = ?", table, pk)
 
-	rows, err := _self.QueryWithTx(tx, sql, arg)
+	rows, err := _self.QueryWithTx(ctx, tx, sql, arg)
 	if err != nil {
-		return false, err
+		return err
 	}
-	defer rows.Close()
+	return _self.structScanOne(rows, table, dst)
+}
 
-	for rows.Next() {
-		err := _self.structScan(rows, dst)
-		if err != nil {
-			return false, err
-		}
-		return true, nil
+func (_self *Dao) StructSelect(ctx context.Context, dst interface{}, sql string, args ...interface{}) error {
+	if err := _self.checkSlicePtr(dst); err != nil {
+		return err
+	}
+
+	rows, err := _self.Query(ctx, sql, args...)
+	if err != nil {
+		return err
 	}
-	return false, nil
+	return _self.structScanSlice(rows, dst)
 }
 
-func (_self *Dao) StructSelect(dst interface{}, sql string, args ...interface{}) (bool, error) {
+func (_self *Dao) StructSelectWithTx(ctx context.Context, tx *sql.Tx, dst interface{}, sql string, args ...interface{}) error {
+	if err := _self.checkSlicePtr(dst); err != nil {
+		return err
+	}
+
+	rows, err := _self.QueryWithTx(ctx, tx, sql, args...)
+	if err != nil {
+		return err
+	}
+	return _self.structScanSlice(rows, dst)
+}
+
+func (_self *Dao) checkSlicePtr(dst interface{}) error {
 	value := reflect.ValueOf(dst)
 	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Type().Kind() != reflect.Slice {
-		return false, errors.New("must pass a none nil slice pointer")
+		return errors.New("must pass a none nil slice pointer")
+	}
+	return nil
+}
+
+func (_self *Dao) structScanOne(rows *sql.Rows, table string, dst interface{}) error {
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return wrapError(_self.Dialect(), err)
+		}
+		return NotFound(table)
 	}
+	return wrapError(_self.Dialect(), _self.structScan(rows, dst))
+}
+
+func (_self *Dao) structScanSlice(rows *sql.Rows, dst interface{}) error {
+	defer rows.Close()
 
+	value := reflect.ValueOf(dst)
 	direct := reflect.Indirect(value)
 	slice := reflect.Indirect(value.Elem())
 	elemType := reflect.TypeOf(dst).Elem().Elem()
 	isPtr := elemType.Kind() == reflect.Ptr
 
-	rows, err := _self.Query(sql, args...)
-	if err != nil {
-		return false, err
-	}
-	defer rows.Close()
-
 	// Start scan
 	for rows.Next() {
 		// New struct
@@ -336,7 +423,7 @@ func (_self *Dao) StructSelect(dst interface{}, sql string, args ...interface{})
 		// Scan row data
 		err := _self.structScan(rows, elem.Interface())
 		if err != nil {
-			return false, err
+			return wrapError(_self.Dialect(), err)
 		}
 
 		// Append to slice
@@ -346,9 +433,12 @@ func (_self *Dao) StructSelect(dst interface{}, sql string, args ...interface{})
 			slice = reflect.Append(slice, reflect.Indirect(elem))
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return wrapError(_self.Dialect(), err)
+	}
 	direct.Set(slice)
 
-	return true, nil
+	return nil
 }
 
 func (_self *Dao) structScan(rows *sql.Rows, dst interface{}) error {
@@ -401,3 +491,33 @@ func (_self *Dao) structReflect(dst interface{}) (string, string, map[string]ref
 
 	return table, pk, mapper
 }
+
+// observe classifies the error of a statement, logs it and reports its duration.
+func (_self *Dao) observe(statement string, start time.Time, err *error) {
+	duration := time.Since(start)
+	*err = wrapError(_self.Dialect(), *err)
+	switch {
+	case *err == nil, errors.Is(*err, ErrNotFound):
+		logger.Debug("dao: statement", "sql", statement, "duration", duration)
+	case errors.Is(*err, ErrCanceled):
+		logger.Debug("dao: statement canceled", "sql", statement, "duration", duration)
+	default:
+		logger.Error("dao: statement error", "sql", statement, "error", *err)
+	}
+	if queryObserver == nil {
+		return
+	}
+
+	// the operation is the leading verb, the table the first quoted name
+	operation := statement
+	if n := strings.IndexAny(statement, " \t\n"); n != -1 {
+		operation = statement[:n]
+	}
+	table := ""
+	if n := strings.Index(statement, "`"); n != -1 {
+		if m := strings.Index(statement[n+1:], "`"); m != -1 {
+			table = statement[n+1 : n+1+m]
+		}
+	}
+	queryObserver(strings.ToUpper(operation), table, duration)
+}
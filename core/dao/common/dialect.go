@@ -0,0 +1,252 @@
+package common
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	MYSQL    = "mysql"
+	SQLITE   = "sqlite3"
+	POSTGRES = "postgres"
+)
+
+// Dialect is what a storage backend does differently from the MySQL flavoured
+// statements the daos are written in.
+type Dialect interface {
+	Name() string
+	// Rebind rewrites a statement written with backticks and ? placeholders.
+	Rebind(sql string) string
+	// Limit returns the paging clause for the rows [start, start+count).
+	Limit(start, count int64) (string, []interface{})
+	// Upsert returns the statement inserting columns into table, or updating
+	// them when the primary key pks exists.
+	Upsert(table string, pks []string, columns []string) string
+	// Returning returns the clause making an insert answer the generated pk,
+	// empty when the driver reports it through LastInsertId.
+	Returning(pk string) string
+	// ForUpdate returns the clause locking the rows a select reads until the
+	// transaction ends, empty when a write transaction locks the whole database.
+	ForUpdate() string
+	// Classify returns ErrConflict or ErrUnavailable for the driver errors
+	// meaning so, nil for the others.
+	Classify(err error) error
+}
+
+type mysqlDialect struct{}
+
+func (_self mysqlDialect) Name() string {
+	return MYSQL
+}
+
+func (_self mysqlDialect) Rebind(sql string) string {
+	return sql
+}
+
+func (_self mysqlDialect) Limit(start, count int64) (string, []interface{}) {
+	return " LIMIT ?, ?", []interface{}{start, count}
+}
+
+func (_self mysqlDialect) Returning(pk string) string {
+	return ""
+}
+
+func (_self mysqlDialect) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (_self mysqlDialect) Classify(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1062, 1213: // duplicate entry, deadlock
+			return ErrConflict
+		case 1040, 1205: // too many connections, lock wait timeout
+			return ErrUnavailable
+		}
+	}
+	if errors.Is(err, mysql.ErrInvalidConn) {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+func (_self mysqlDialect) Upsert(table string, pks []string, columns []string) string {
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		updates = append(updates, fmt.Sprintf("`%s` = VALUES(`%s`)", column, column))
+	}
+	return fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s) ON DUPLICATE KEY UPDATE %s",
+		table, quoteColumns(columns), placeholders(len(columns)), strings.Join(updates, ", "))
+}
+
+type sqliteDialect struct{}
+
+func (_self sqliteDialect) Name() string {
+	return SQLITE
+}
+
+// Rebind leaves the statement as is, sqlite takes backticks and ? placeholders.
+func (_self sqliteDialect) Rebind(sql string) string {
+	return sql
+}
+
+func (_self sqliteDialect) Limit(start, count int64) (string, []interface{}) {
+	return " LIMIT ? OFFSET ?", []interface{}{count, start}
+}
+
+func (_self sqliteDialect) Returning(pk string) string {
+	return ""
+}
+
+// ForUpdate is empty, sqlite has no row locks. The transactions of a db from
+// OpenStorage begin immediate, holding the database lock instead.
+func (_self sqliteDialect) ForUpdate() string {
+	return ""
+}
+
+func (_self sqliteDialect) Classify(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch {
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique, sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey:
+			return ErrConflict
+		case sqliteErr.Code == sqlite3.ErrBusy, sqliteErr.Code == sqlite3.ErrLocked, sqliteErr.Code == sqlite3.ErrCantOpen:
+			return ErrUnavailable
+		}
+	}
+	return nil
+}
+
+func (_self sqliteDialect) Upsert(table string, pks []string, columns []string) string {
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		updates = append(updates, fmt.Sprintf("`%s` = excluded.`%s`", column, column))
+	}
+	return fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, quoteColumns(columns), placeholders(len(columns)), quoteColumns(pks), strings.Join(updates, ", "))
+}
+
+type postgresDialect struct{}
+
+func (_self postgresDialect) Name() string {
+	return POSTGRES
+}
+
+// Rebind turns backticks into double quotes and ? into $n, leaving string literals alone.
+func (_self postgresDialect) Rebind(sql string) string {
+	buffer := bytes.Buffer{}
+	buffer.Grow(len(sql) + 16)
+
+	n := 0
+	quoted := false
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		switch {
+		case ch == '\'':
+			quoted = !quoted
+			buffer.WriteByte(ch)
+		case quoted:
+			buffer.WriteByte(ch)
+		case ch == '`':
+			buffer.WriteByte('"')
+		case ch == '?':
+			n++
+			buffer.WriteString("$" + strconv.Itoa(n))
+		default:
+			buffer.WriteByte(ch)
+		}
+	}
+	return buffer.String()
+}
+
+func (_self postgresDialect) Limit(start, count int64) (string, []interface{}) {
+	return " LIMIT ? OFFSET ?", []interface{}{count, start}
+}
+
+func (_self postgresDialect) Upsert(table string, pks []string, columns []string) string {
+	updates := make([]string, 0, len(columns))
+	for _, column := range columns {
+		updates = append(updates, fmt.Sprintf("`%s` = EXCLUDED.`%s`", column, column))
+	}
+	return fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, quoteColumns(columns), placeholders(len(columns)), quoteColumns(pks), strings.Join(updates, ", "))
+}
+
+func (_self postgresDialect) Returning(pk string) string {
+	return " RETURNING `" + pk + "`"
+}
+
+func (_self postgresDialect) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (_self postgresDialect) Classify(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "23505", pqErr.Code == "40001", pqErr.Code == "40P01": // unique, serialization, deadlock
+			return ErrConflict
+		case pqErr.Code.Class() == "08", pqErr.Code.Class() == "57": // connection, operator intervention
+			return ErrUnavailable
+		}
+	}
+	return nil
+}
+
+var (
+	dialectLock sync.RWMutex
+	dialectMap  = map[string]Dialect{MYSQL: mysqlDialect{}, SQLITE: sqliteDialect{}, POSTGRES: postgresDialect{}}
+)
+
+// RegisterDialect makes a dialect available to Open under its name.
+func RegisterDialect(dialect Dialect) {
+	dialectLock.Lock()
+	defer dialectLock.Unlock()
+
+	dialectMap[dialect.Name()] = dialect
+}
+
+// DB is an open database and the dialect of its driver, which the daos over
+// it speak.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Open opens db with driver and the dialect registered under its name.
+func Open(driver, dataSource string) (*DB, error) {
+	dialectLock.RLock()
+	dialect, exist := dialectMap[driver]
+	dialectLock.RUnlock()
+	if !exist {
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: db, Dialect: dialect}, nil
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quoted = append(quoted, "`"+column+"`")
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+)
+
+var (
+	// ErrNotFound is a row that doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is a write refused by a unique key or a concurrent change.
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable is a database that can't be reached or a statement timing out.
+	ErrUnavailable = errors.New("database unavailable")
+	// ErrCanceled is a statement abandoned by its caller, the client went away.
+	ErrCanceled = errors.New("canceled")
+)
+
+// Error is a failed statement, errors.Is matches it against its Kind.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (_self *Error) Error() string {
+	if _self.Kind != nil && _self.Kind != _self.Err {
+		return _self.Kind.Error() + ": " + _self.Err.Error()
+	}
+	return _self.Err.Error()
+}
+
+func (_self *Error) Unwrap() error {
+	return _self.Err
+}
+
+func (_self *Error) Is(target error) bool {
+	return _self.Kind != nil && _self.Kind == target
+}
+
+// NotFound returns an ErrNotFound telling what is missing, "not found: app".
+func NotFound(what string) error {
+	return &Error{Kind: ErrNotFound, Err: errors.New(what)}
+}
+
+// Conflict returns an ErrConflict telling why the write was refused.
+func Conflict(why string) error {
+	return &Error{Kind: ErrConflict, Err: errors.New(why)}
+}
+
+// StaleError is the ErrConflict of an edit based on an outdated version,
+// Current is the row as it is now.
+type StaleError struct {
+	Current interface{}
+}
+
+func (_self *StaleError) Error() string {
+	return "conflict: version is stale"
+}
+
+func (_self *StaleError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Stale returns a StaleError carrying the current row.
+func Stale(current interface{}) error {
+	return &StaleError{Current: current}
+}
+
+// wrapError classifies the error of a statement, the driver errors by the dialect.
+func wrapError(dialect Dialect, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	var stale *StaleError
+	if errors.As(err, &e) || errors.As(err, &stale) {
+		return err
+	}
+
+	var kind error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		kind = ErrNotFound
+	case errors.Is(err, context.Canceled):
+		kind = ErrCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		kind = ErrUnavailable
+	default:
+		kind = dialect.Classify(err)
+	}
+	return &Error{Kind: kind, Err: err}
+}
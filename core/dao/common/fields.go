@@ -0,0 +1,40 @@
+package common
+
+// Fields are the fields a selective update writes by their json name, a field
+// in the set is written even when it holds its zero value.
+type Fields map[string]bool
+
+func NewFields(names ...string) Fields {
+	fields := make(Fields, len(names))
+	for _, name := range names {
+		fields[name] = true
+	}
+	return fields
+}
+
+func (_self Fields) Has(name string) bool {
+	return _self[name]
+}
+
+// Only returns the fields among names.
+func (_self Fields) Only(names ...string) Fields {
+	fields := make(Fields, len(names))
+	for _, name := range names {
+		if _self[name] {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// With returns the fields plus names.
+func (_self Fields) With(names ...string) Fields {
+	fields := make(Fields, len(_self)+len(names))
+	for name := range _self {
+		fields[name] = true
+	}
+	for _, name := range names {
+		fields[name] = true
+	}
+	return fields
+}
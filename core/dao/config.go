@@ -2,7 +2,7 @@ package dao
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -14,6 +14,7 @@ type ConfigData struct {
 	AppId       int64            `json:"appId" DB_COL:"app_id"`
 	Key         string           `json:"key" DB_COL:"key"`
 	Value       string           `json:"value" DB_COL:"value"`
+	Type        int              `json:"type" DB_COL:"type"`
 	Desc        string           `json:"desc" DB_COL:"desc"`
 	Status      int              `json:"status" DB_COL:"status"`
 	Operate     int              `json:"operate" DB_COL:"operate"`
@@ -23,6 +24,7 @@ type ConfigData struct {
 	UpdateBy    string           `json:"updateBy" DB_COL:"update_by"`
 	ReleaseTime *common.JsonTime `json:"releaseTime" DB_COL:"release_time"`
 	ReleaseBy   *string          `json:"releaseBy" DB_COL:"release_by"`
+	Version     int              `json:"version" DB_COL:"version"`
 }
 
 type QueryConfigData struct {
@@ -41,6 +43,13 @@ const (
 	STATUS_IN = 2
 )
 
+const (
+	// 1-文本、2-特性开关、3-模板（可引用其他配置）
+	TYPE_TEXT     = 1
+	TYPE_FLAG     = 2
+	TYPE_TEMPLATE = 3
+)
+
 const (
 	// 1-新增、2-更新、3-删除
 	OPERATE_NEW    = 1
@@ -52,56 +61,43 @@ type ConfigDao struct {
 	common.Dao
 }
 
-func NewConfigDao(db *sql.DB) *ConfigDao {
-	configDao := ConfigDao{common.Dao{DB: db}}
+func NewConfigDao(db *common.DB) *ConfigDao {
+	configDao := ConfigDao{common.NewDao(db.DB, db.Dialect)}
 	return &configDao
 }
 
-func (_self *ConfigDao) QueryConfigs(query QueryConfigData) []*ConfigData {
+func (_self *ConfigDao) QueryConfigs(ctx context.Context, query QueryConfigData) ([]*ConfigData, error) {
 	sql, values := _self.prepareSelectedQuery(false, query)
 	configs := make([]*ConfigData, 0)
-	success, err := _self.StructSelect(&configs, sql, values...)
+	err := _self.StructSelect(ctx, &configs, sql, values...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	if success {
-		return configs
-	}
-	return nil
+	return configs, nil
 }
 
-func (_self *ConfigDao) CountConfigs(query QueryConfigData) int64 {
+func (_self *ConfigDao) CountConfigs(ctx context.Context, query QueryConfigData) (int64, error) {
 	sql, values := _self.prepareSelectedQuery(true, query)
-	return _self.Count(sql, values...)
+	return _self.Count(ctx, sql, values...)
 }
 
-func (_self *ConfigDao) InsertConfig(data *ConfigData) int64 {
-	rowCnt, err := _self.StructInsert(data, false)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+func (_self *ConfigDao) InsertConfig(ctx context.Context, data *ConfigData) (int64, error) {
+	return _self.StructInsert(ctx, data, false)
 }
 
-func (_self *ConfigDao) SelectedUpdateConfig(data ConfigData) int64 {
-	sql, values := _self.prepareSelectedUpdate(data)
-	rowCnt, err := _self.Exec(sql, values...)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+// SelectedUpdateConfig updates the fields of config and bumps its version, no
+// row is updated when data.Version is set and stale.
+func (_self *ConfigDao) SelectedUpdateConfig(ctx context.Context, data ConfigData, fields common.Fields) (int64, error) {
+	sql, values := _self.prepareSelectedUpdate(data, fields)
+	return _self.Exec(ctx, sql, values...)
 }
 
-func (_self *ConfigDao) DeleteConfig(appId, configId int64) int64 {
+func (_self *ConfigDao) DeleteConfig(ctx context.Context, appId, configId int64) (int64, error) {
 	sql := "DELETE FROM `config` WHERE `app_id` = ? AND `config_id` = ?"
-	rowCnt, err := _self.Exec(sql, appId, configId)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+	return _self.Exec(ctx, sql, appId, configId)
 }
 
-func (_self *ConfigDao) BatchUpdateConfig(status int, date time.Time, user string, configIds []int64) int64 {
+func (_self *ConfigDao) BatchUpdateConfig(ctx context.Context, status int, date time.Time, user string, configIds []int64) (int64, error) {
 	values := make([]interface{}, 0)
 	sql := "UPDATE `config` SET `status` = ?, `update_time` = ?, `update_by` = ? WHERE `config_id` in "
 
@@ -114,14 +110,10 @@ func (_self *ConfigDao) BatchUpdateConfig(status int, date time.Time, user strin
 	}
 	sql = sql + "(" + strings.Trim(ids.String(), ", ") + ")"
 
-	rowCnt, err := _self.Exec(sql, values...)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+	return _self.Exec(ctx, sql, values...)
 }
 
-func (_self *ConfigDao) BatchDeleteConfig(configIds []int64) int64 {
+func (_self *ConfigDao) BatchDeleteConfig(ctx context.Context, configIds []int64) (int64, error) {
 	values := make([]interface{}, 0)
 	sql := "DELETE FROM `config` WHERE `config_id` in "
 
@@ -131,11 +123,7 @@ func (_self *ConfigDao) BatchDeleteConfig(configIds []int64) int64 {
 	}
 	sql = sql + "(" + strings.Trim(ids.String(), ", ") + ")"
 
-	rowCnt, err := _self.Exec(sql, values...)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+	return _self.Exec(ctx, sql, values...)
 }
 
 func (_self *ConfigDao) prepareSelectedQuery(count bool, query QueryConfigData) (string, []interface{}) {
@@ -166,72 +154,79 @@ func (_self *ConfigDao) prepareSelectedQuery(count bool, query QueryConfigData)
 		values = append(values, query.Key)
 	}
 	if query.LikeKey != "" {
-		buffer.WriteString(" AND `key` like '" + query.LikeKey + "%'")
+		like, likeValues := _self.LikePrefix("key", query.LikeKey)
+		buffer.WriteString(" AND" + like)
+		values = append(values, likeValues...)
 	}
 	if query.Start >= 0 && query.End > 0 {
-		buffer.WriteString(" LIMIT ?, ?")
-		values = append(values, query.Start, query.End)
+		limit, limitValues := _self.Limit(query.Start, query.End)
+		buffer.WriteString(limit)
+		values = append(values, limitValues...)
 	}
 
 	return buffer.String(), values
 }
 
-func (_self *ConfigDao) prepareSelectedUpdate(data ConfigData) (string, []interface{}) {
+func (_self *ConfigDao) prepareSelectedUpdate(data ConfigData, fields common.Fields) (string, []interface{}) {
 	buffer := bytes.Buffer{}
 	buffer.WriteString("UPDATE `config` SET ")
 
 	values := make([]interface{}, 0)
-	if data.AppId != 0 {
-		values = append(values, data.AppId)
-		buffer.WriteString("`app_id` = ?,")
-	}
-	if data.Key != "" {
+	if fields.Has("key") {
 		values = append(values, data.Key)
 		buffer.WriteString("`key` = ?,")
 	}
-	if data.Value != "" {
+	if fields.Has("value") {
 		values = append(values, data.Value)
 		buffer.WriteString("`value` = ?,")
 	}
-	if data.Desc != "" {
+	if fields.Has("type") {
+		values = append(values, data.Type)
+		buffer.WriteString("`type` = ?,")
+	}
+	if fields.Has("desc") {
 		values = append(values, data.Desc)
 		buffer.WriteString("`desc` = ?,")
 	}
-	if data.Status != 0 {
+	if fields.Has("status") {
 		values = append(values, data.Status)
 		buffer.WriteString("`status` = ?,")
 	}
-	if data.Operate != 0 {
+	if fields.Has("operate") {
 		values = append(values, data.Operate)
 		buffer.WriteString("`operate` = ?,")
 	}
-	if !data.CreateTime.IsZero() {
+	if fields.Has("createTime") {
 		values = append(values, data.CreateTime)
 		buffer.WriteString("`create_time` = ?,")
 	}
-	if data.CreateBy != "" {
+	if fields.Has("createBy") {
 		values = append(values, data.CreateBy)
 		buffer.WriteString("`create_by` = ?,")
 	}
-	if !data.UpdateTime.IsZero() {
+	if fields.Has("updateTime") {
 		values = append(values, data.UpdateTime)
 		buffer.WriteString("`update_time` = ?,")
 	}
-	if data.UpdateBy != "" {
+	if fields.Has("updateBy") {
 		values = append(values, data.UpdateBy)
 		buffer.WriteString("`update_by` = ?,")
 	}
-	if data.ReleaseTime != nil {
+	if fields.Has("releaseTime") {
 		values = append(values, data.ReleaseTime)
 		buffer.WriteString("`release_time` = ?,")
 	}
-	if data.ReleaseBy != nil {
+	if fields.Has("releaseBy") {
 		values = append(values, data.ReleaseBy)
 		buffer.WriteString("`release_by` = ?,")
 	}
 
-	sql := strings.TrimSuffix(buffer.String(), ",") + " WHERE `config_id` = ?"
-	values = append(values, data.ConfigId)
+	buffer.WriteString("`version` = `version` + 1 WHERE `app_id` = ? AND `config_id` = ?")
+	values = append(values, data.AppId, data.ConfigId)
+	if data.Version != 0 {
+		buffer.WriteString(" AND `version` = ?")
+		values = append(values, data.Version)
+	}
 
-	return sql, values
+	return buffer.String(), values
 }
@@ -0,0 +1,92 @@
+package dao
+
+import (
+	"context"
+
+	"varconf-server/core/dao/common"
+)
+
+const (
+	// 1-启用、2-停用
+	GITOPS_ENABLED  = 1
+	GITOPS_DISABLED = 2
+)
+
+const (
+	GIT_FORMAT_JSON       = "json"
+	GIT_FORMAT_PROPERTIES = "properties"
+)
+
+// 应用Git同步
+type GitSyncData struct {
+	AppId      int64            `json:"appId" DB_COL:"app_id" DB_PK:"app_id" DB_TABLE:"git_sync"`
+	Repo       string           `json:"repo" DB_COL:"repo"`
+	Branch     string           `json:"branch" DB_COL:"branch"`
+	Path       string           `json:"path" DB_COL:"path"`
+	Format     string           `json:"format" DB_COL:"format"`
+	GitOps     int              `json:"gitOps" DB_COL:"git_ops"`
+	LastCommit string           `json:"lastCommit" DB_COL:"last_commit"`
+	LastError  string           `json:"lastError" DB_COL:"last_error"`
+	SyncTime   *common.JsonTime `json:"syncTime" DB_COL:"sync_time"`
+	CreateTime common.JsonTime  `json:"createTime" DB_COL:"create_time"`
+	CreateBy   string           `json:"createBy" DB_COL:"create_by"`
+	UpdateTime common.JsonTime  `json:"updateTime" DB_COL:"update_time"`
+}
+
+type GitSyncDao struct {
+	common.Dao
+}
+
+func NewGitSyncDao(db *common.DB) *GitSyncDao {
+	gitSyncDao := GitSyncDao{common.NewDao(db.DB, db.Dialect)}
+	return &gitSyncDao
+}
+
+// QueryGitSync returns the git sync of app, ErrNotFound when there is none.
+func (_self *GitSyncDao) QueryGitSync(ctx context.Context, appId int64) (*GitSyncData, error) {
+	gitSync := GitSyncData{}
+	err := _self.StructSelectByPK(ctx, &gitSync, appId)
+	if err != nil {
+		return nil, err
+	}
+	return &gitSync, nil
+}
+
+func (_self *GitSyncDao) QueryGitOpsSyncs(ctx context.Context) ([]*GitSyncData, error) {
+	sql := "SELECT * FROM `git_sync` WHERE `git_ops` = ? ORDER BY `app_id`"
+
+	gitSyncs := make([]*GitSyncData, 0)
+	err := _self.StructSelect(ctx, &gitSyncs, sql, GITOPS_ENABLED)
+	if err != nil {
+		return nil, err
+	}
+	return gitSyncs, nil
+}
+
+func (_self *GitSyncDao) UpsertGitSync(ctx context.Context, data *GitSyncData) (int64, error) {
+	return _self.StructUpsert(ctx, data)
+}
+
+func (_self *GitSyncDao) DeleteGitSync(ctx context.Context, appId int64) (int64, error) {
+	sql := "DELETE FROM `git_sync` WHERE `app_id` = ?"
+	return _self.Exec(ctx, sql, appId)
+}
+
+// ClaimCommit moves the last commit of app from lastCommit to commit, no row
+// is updated when another node claimed it first.
+func (_self *GitSyncDao) ClaimCommit(ctx context.Context, appId int64, lastCommit, commit string) (int64, error) {
+	sql := "UPDATE `git_sync` SET `last_commit` = ? WHERE `app_id` = ? AND `last_commit` = ?"
+	return _self.Exec(ctx, sql, commit, appId, lastCommit)
+}
+
+// UpdateSyncResult records the outcome of a sync, an empty commit keeps the
+// last one.
+func (_self *GitSyncDao) UpdateSyncResult(ctx context.Context, appId int64, commit, syncError string) (int64, error) {
+	syncTime := common.NowJsonTime()
+	if commit == "" {
+		sql := "UPDATE `git_sync` SET `last_error` = ?, `sync_time` = ? WHERE `app_id` = ?"
+		return _self.Exec(ctx, sql, syncError, syncTime, appId)
+	}
+	sql := "UPDATE `git_sync` SET `last_commit` = ?, `last_error` = ?, `sync_time` = ? WHERE `app_id` = ?"
+	return _self.Exec(ctx, sql, commit, syncError, syncTime, appId)
+}
@@ -2,6 +2,7 @@ package dao
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -15,121 +16,172 @@ type ManageTxDao struct {
 	common.Dao
 }
 
-func NewManageTxDao(db *sql.DB) *ManageTxDao {
-	manageTxDao := ManageTxDao{common.Dao{DB: db}}
+func NewManageTxDao(db *common.DB) *ManageTxDao {
+	manageTxDao := ManageTxDao{common.NewDao(db.DB, db.Dialect)}
 	return &manageTxDao
 }
 
-func (_self *ManageTxDao) ReleaseConfig(appId int64, allConfigs []*ConfigData, user string) (bool, []string) {
+// ReleaseConfig releases the pending configs of app on top of releaseIndex,
+// the index the caller saw, and returns the released keys and the new index.
+// The index bump comes first and locks the app until commit, so a concurrent
+// release waits for this one and then fails with ErrConflict.
+func (_self *ManageTxDao) ReleaseConfig(ctx context.Context, appId int64, releaseIndex int, user string) (keys []string, newIndex int, err error) {
 	// start tx
-	tx, err := _self.DB.Begin()
+	tx, err := _self.Begin(ctx)
 	if err != nil {
-		return false, nil
+		return nil, 0, err
 	}
 	defer func() {
-		if err != nil && tx != nil {
+		if err != nil {
 			tx.Rollback()
 		}
 	}()
 
-	// parse allConfigs and update config status
-	releaseConfigs, keys, err := _self.batchReleaseConfigTx(tx, allConfigs, user)
+	// lock the app by bumping its release index
+	newIndex, err = _self.bumpReleaseIndexTx(ctx, tx, appId, releaseIndex)
 	if err != nil {
-		return false, nil
+		return nil, 0, err
 	}
 
-	// encode json
-	configList, err := json.Marshal(releaseConfigs)
+	// read the configs under the lock and update their status
+	configs, err := _self.queryConfigsTx(ctx, tx, appId)
 	if err != nil {
-		return false, nil
+		return nil, 0, err
+	}
+	if len(configs) < 1 {
+		return nil, 0, errors.New("no config to release")
+	}
+	releaseConfigs, keys, err := _self.batchReleaseConfigTx(ctx, tx, configs, user)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// get release index
-	releaseIndex, err := _self.releaseIndexTx(tx, appId)
-	if releaseIndex < 0 || err != nil {
-		return false, nil
+	// merge linked namespaces
+	releaseConfigs, err = _self.mergeLinkedTx(ctx, tx, appId, releaseConfigs)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// upsert release data
-	releaseData := &ReleaseData{
-		AppId:        appId,
-		ConfigList:   string(configList),
-		ReleaseTime:  common.NowJsonTime(),
-		ReleaseIndex: releaseIndex,
+	// upsert release data and log
+	err = _self.saveReleaseTx(ctx, tx, appId, releaseConfigs, newIndex, user)
+	if err != nil {
+		return nil, 0, err
 	}
-	_, err = _self.upsertReleaseTx(tx, releaseData)
+
+	// commit tx
+	err = _self.Commit(tx)
 	if err != nil {
-		return false, nil
+		return nil, 0, err
 	}
+	return keys, newIndex, nil
+}
 
-	// upsert release log
-	releaseLogData := &ReleaseLogData{
-		AppId:        releaseData.AppId,
-		ConfigList:   releaseData.ConfigList,
-		ReleaseTime:  releaseData.ReleaseTime,
-		ReleaseIndex: releaseData.ReleaseIndex,
-		ReleaseBy:    user,
+// RefreshRelease re-merges the linked namespaces into the released snapshot of app,
+// leaving its pending configs untouched, and returns the keys whose value changed.
+func (_self *ManageTxDao) RefreshRelease(ctx context.Context, appId int64, user string) (keys []string, releaseIndex int, err error) {
+	// start tx
+	tx, err := _self.Begin(ctx)
+	if err != nil {
+		return nil, 0, err
 	}
-	_, err = _self.insertReleaseLogTx(tx, releaseLogData)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// lock the app, the bump is rolled back when nothing changed
+	releaseIndex, err = _self.bumpReleaseIndexTx(ctx, tx, appId, -1)
 	if err != nil {
-		return false, nil
+		return nil, 0, err
+	}
+
+	// query current release
+	oldConfigs, err := _self.queryReleaseConfigsTx(ctx, tx, appId)
+	if err != nil {
+		return nil, 0, err
+	}
+	ownConfigs := make([]*ConfigData, 0, len(oldConfigs))
+	for _, config := range oldConfigs {
+		if config.AppId == appId {
+			ownConfigs = append(ownConfigs, config)
+		}
+	}
+
+	// merge linked namespaces
+	releaseConfigs, err := _self.mergeLinkedTx(ctx, tx, appId, ownConfigs)
+	if err != nil {
+		return nil, 0, err
+	}
+	keys = _self.diffKeys(oldConfigs, releaseConfigs)
+	if len(keys) == 0 {
+		return keys, 0, tx.Rollback()
+	}
+
+	// upsert release data and log
+	err = _self.saveReleaseTx(ctx, tx, appId, releaseConfigs, releaseIndex, user)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	// commit tx
-	err = tx.Commit()
+	err = _self.Commit(tx)
 	if err != nil {
-		return false, nil
+		return nil, 0, err
 	}
-	return true, keys
+	return keys, releaseIndex, nil
 }
 
-func (_self *ManageTxDao) DeleteApp(appId int64) bool {
+func (_self *ManageTxDao) DeleteApp(ctx context.Context, appId int64) (err error) {
 	// start tx
-	tx, err := _self.DB.Begin()
+	tx, err := _self.Begin(ctx)
 	if err != nil {
-		return false
+		return err
 	}
 	defer func() {
-		if err != nil && tx != nil {
+		if err != nil {
 			tx.Rollback()
 		}
 	}()
 
 	// delete app's data
 	sql := "DELETE FROM `app` WHERE `app_id` = ?"
-	_, err = _self.ExecWithTx(tx, sql, appId)
+	_, err = _self.ExecWithTx(ctx, tx, sql, appId)
 	if err != nil {
-		return false
+		return err
 	}
 	sql = "DELETE FROM `config` WHERE `app_id` = ?"
-	_, err = _self.ExecWithTx(tx, sql, appId)
+	_, err = _self.ExecWithTx(ctx, tx, sql, appId)
 	if err != nil {
-		return false
+		return err
 	}
 	sql = "DELETE FROM `release` WHERE `app_id` = ?"
-	_, err = _self.ExecWithTx(tx, sql, appId)
+	_, err = _self.ExecWithTx(ctx, tx, sql, appId)
 	if err != nil {
-		return false
+		return err
 	}
 	sql = "DELETE FROM `release_log` WHERE `app_id` = ?"
-	_, err = _self.ExecWithTx(tx, sql, appId)
+	_, err = _self.ExecWithTx(ctx, tx, sql, appId)
 	if err != nil {
-		return false
+		return err
 	}
-
-	// commit tx
-	err = tx.Commit()
+	sql = "DELETE FROM `client` WHERE `app_id` = ?"
+	_, err = _self.ExecWithTx(ctx, tx, sql, appId)
 	if err != nil {
-		return false
+		return err
 	}
-	return true
-}
-
-func (_self *ManageTxDao) batchReleaseConfigTx(tx *sql.Tx, configs []*ConfigData, user string) ([]*ConfigData, []string, error) {
-	if len(configs) < 1 {
-		return nil, nil, errors.New("configs is empty")
+	sql = "DELETE FROM `app_link` WHERE `app_id` = ? OR `link_app_id` = ?"
+	_, err = _self.ExecWithTx(ctx, tx, sql, appId, appId)
+	if err != nil {
+		return err
 	}
 
+	// commit tx
+	err = _self.Commit(tx)
+	return err
+}
+
+func (_self *ManageTxDao) batchReleaseConfigTx(ctx context.Context, tx *sql.Tx, configs []*ConfigData, user string) ([]*ConfigData, []string, error) {
 	// parse data
 	now := common.NowJsonTime()
 	releaseConfigs := make([]*ConfigData, 0)
@@ -137,8 +189,8 @@ func (_self *ManageTxDao) batchReleaseConfigTx(tx *sql.Tx, configs []*ConfigData
 	updateIds := make([]int64, 0)
 	deleteIds := make([]int64, 0)
 	for _, config := range configs {
-		keys = append(keys, config.Key)
 		if config.Status == STATUS_UN {
+			keys = append(keys, config.Key)
 			config.ReleaseTime = &now
 			config.ReleaseBy = &user
 
@@ -152,21 +204,23 @@ func (_self *ManageTxDao) batchReleaseConfigTx(tx *sql.Tx, configs []*ConfigData
 	}
 
 	// update data
-	var err error
 	if len(updateIds) > 0 {
-		_, err = _self.batchUpdateConfigTx(tx, STATUS_IN, now.Time, user, updateIds)
+		_, err := _self.batchUpdateConfigTx(ctx, tx, STATUS_IN, now.Time, user, updateIds)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 	if len(deleteIds) > 0 {
-		_, err = _self.batchDeleteConfigTx(tx, deleteIds)
-	}
-	if err != nil {
-		return nil, keys, err
+		_, err := _self.batchDeleteConfigTx(ctx, tx, deleteIds)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	return releaseConfigs, keys, nil
 }
 
-func (_self *ManageTxDao) batchUpdateConfigTx(tx *sql.Tx, status int, date time.Time, user string, configIds []int64) (int64, error) {
+func (_self *ManageTxDao) batchUpdateConfigTx(ctx context.Context, tx *sql.Tx, status int, date time.Time, user string, configIds []int64) (int64, error) {
 	values := make([]interface{}, 0)
 	sql := "UPDATE `config` SET `status` = ?, `release_time` = ?, `release_by` = ? WHERE `config_id` in "
 
@@ -179,10 +233,10 @@ func (_self *ManageTxDao) batchUpdateConfigTx(tx *sql.Tx, status int, date time.
 	}
 	sql = sql + "(" + strings.Trim(ids.String(), ", ") + ")"
 
-	return _self.Exec(sql, values...)
+	return _self.ExecWithTx(ctx, tx, sql, values...)
 }
 
-func (_self *ManageTxDao) batchDeleteConfigTx(tx *sql.Tx, configIds []int64) (int64, error) {
+func (_self *ManageTxDao) batchDeleteConfigTx(ctx context.Context, tx *sql.Tx, configIds []int64) (int64, error) {
 	values := make([]interface{}, 0)
 	sql := "DELETE FROM `config` WHERE `config_id` in "
 
@@ -192,28 +246,143 @@ func (_self *ManageTxDao) batchDeleteConfigTx(tx *sql.Tx, configIds []int64) (in
 	}
 	sql = sql + "(" + strings.Trim(ids.String(), ", ") + ")"
 
-	return _self.Exec(sql, values...)
+	return _self.ExecWithTx(ctx, tx, sql, values...)
 }
 
-func (_self *ManageTxDao) releaseIndexTx(tx *sql.Tx, appId int64) (int, error) {
-	appData := AppData{}
-	success, err := _self.StructSelectByPKWithTx(tx, &appData, appId)
-	if !success || err != nil {
+// mergeLinkedTx appends the released configs of the linked namespaces, the app's own keys
+// and the earlier links take precedence.
+func (_self *ManageTxDao) mergeLinkedTx(ctx context.Context, tx *sql.Tx, appId int64, configs []*ConfigData) ([]*ConfigData, error) {
+	appLinks := make([]*AppLinkData, 0)
+	sql := "SELECT * FROM `app_link` WHERE `app_id` = ? ORDER BY `id`"
+	err := _self.StructSelectWithTx(ctx, tx, &appLinks, sql, appId)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := make(map[string]bool)
+	for _, config := range configs {
+		keySet[config.Key] = true
+	}
+	for _, appLink := range appLinks {
+		linkConfigs, err := _self.queryReleaseConfigsTx(ctx, tx, appLink.LinkAppId)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range linkConfigs {
+			if config.AppId != appLink.LinkAppId || keySet[config.Key] {
+				continue
+			}
+			keySet[config.Key] = true
+			configs = append(configs, config)
+		}
+	}
+	return configs, nil
+}
+
+func (_self *ManageTxDao) queryReleaseConfigsTx(ctx context.Context, tx *sql.Tx, appId int64) ([]*ConfigData, error) {
+	releaseData := ReleaseData{}
+	err := _self.StructSelectByPKWithTx(ctx, tx, &releaseData, appId)
+	if errors.Is(err, common.ErrNotFound) {
+		return make([]*ConfigData, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]*ConfigData, 0)
+	err = json.Unmarshal([]byte(releaseData.ConfigList), &configs)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+func (_self *ManageTxDao) diffKeys(oldConfigs, newConfigs []*ConfigData) []string {
+	oldValues := make(map[string]string)
+	for _, config := range oldConfigs {
+		oldValues[config.Key] = config.Value
+	}
+
+	keys := make([]string, 0)
+	for _, config := range newConfigs {
+		value, exist := oldValues[config.Key]
+		if !exist || value != config.Value {
+			keys = append(keys, config.Key)
+		}
+		delete(oldValues, config.Key)
+	}
+	for key := range oldValues {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// bumpReleaseIndexTx moves the release index of app past releaseIndex, which
+// locks the app row until the transaction ends, and returns the new index. It
+// fails with ErrConflict when the index moved on since the caller read it, a
+// negative releaseIndex bumps whatever the index is.
+func (_self *ManageTxDao) bumpReleaseIndexTx(ctx context.Context, tx *sql.Tx, appId int64, releaseIndex int) (int, error) {
+	values := []interface{}{appId}
+	sql := "UPDATE `app` SET `release_index` = `release_index` + 1 WHERE `app_id` = ?"
+	if releaseIndex >= 0 {
+		sql = sql + " AND `release_index` = ?"
+		values = append(values, releaseIndex)
+	}
+	rowCnt, err := _self.ExecWithTx(ctx, tx, sql, values...)
+	if err != nil {
 		return -1, err
 	}
 
-	sql := "UPDATE `app` SET `release_index` = `release_index` + 1 WHERE `app_id` = ? And `release_index` = ?"
-	rowCnt, err := _self.ExecWithTx(tx, sql, appId, appData.ReleaseIndex)
-	if rowCnt != 1 || err != nil {
+	appData := AppData{}
+	err = _self.StructSelectByPKWithTx(ctx, tx, &appData, appId)
+	if err != nil {
 		return -1, err
 	}
-	return appData.ReleaseIndex + 1, nil
+	if rowCnt != 1 {
+		return -1, common.Conflict(fmt.Sprintf("app was released concurrently, release index %d is now %d",
+			releaseIndex, appData.ReleaseIndex))
+	}
+	return appData.ReleaseIndex, nil
 }
 
-func (_self *ManageTxDao) upsertReleaseTx(tx *sql.Tx, data *ReleaseData) (int64, error) {
-	return _self.StructUpsertWithTx(tx, data)
+// queryConfigsTx reads the configs of app and locks them against edits until
+// the transaction ends.
+func (_self *ManageTxDao) queryConfigsTx(ctx context.Context, tx *sql.Tx, appId int64) ([]*ConfigData, error) {
+	configs := make([]*ConfigData, 0)
+	sql := "SELECT * FROM `config` WHERE `app_id` = ?" + _self.Dialect().ForUpdate()
+	err := _self.StructSelectWithTx(ctx, tx, &configs, sql, appId)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
 }
 
-func (_self *ManageTxDao) insertReleaseLogTx(tx *sql.Tx, data *ReleaseLogData) (int64, error) {
-	return _self.StructInsertWithTx(tx, data, false)
+// saveReleaseTx upserts the released snapshot of app and appends it to the log.
+func (_self *ManageTxDao) saveReleaseTx(ctx context.Context, tx *sql.Tx, appId int64, configs []*ConfigData, releaseIndex int, user string) error {
+	// encode json
+	configList, err := json.Marshal(configs)
+	if err != nil {
+		return err
+	}
+
+	releaseData := &ReleaseData{
+		AppId:        appId,
+		ConfigList:   string(configList),
+		ReleaseTime:  common.NowJsonTime(),
+		ReleaseIndex: releaseIndex,
+	}
+	_, err = _self.StructUpsertWithTx(ctx, tx, releaseData)
+	if err != nil {
+		return err
+	}
+
+	releaseLogData := &ReleaseLogData{
+		AppId:        releaseData.AppId,
+		ConfigList:   releaseData.ConfigList,
+		ReleaseTime:  releaseData.ReleaseTime,
+		ReleaseIndex: releaseData.ReleaseIndex,
+		ReleaseBy:    user,
+	}
+	_, err = _self.StructInsertWithTx(ctx, tx, releaseLogData, false)
+	return err
 }
@@ -0,0 +1,174 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"varconf-server/core/dao/common"
+)
+
+// TestReleaseConcurrent releases one app from several goroutines on the same
+// release index, one wins and the others fail with ErrConflict.
+func TestReleaseConcurrent(t *testing.T) {
+	db, closeDb := openEmptyDb(t)
+	defer closeDb()
+	ctx := context.Background()
+	if _, err := NewSchemaDao(db).MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &AppData{Name: "a", Code: "a", ApiKey: "k", Public: APP_PRIVATE, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	if _, err := NewAppDao(db).InsertApp(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	appId := app.AppId
+	for i := 0; i < 20; i++ {
+		config := &ConfigData{AppId: appId, Key: fmt.Sprint("key", i), Value: "1", Type: TYPE_TEXT, Status: STATUS_UN, Operate: OPERATE_NEW,
+			CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+		if _, err := NewConfigDao(db).InsertConfig(ctx, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const releases = 8
+	var wait sync.WaitGroup
+	errs := make(chan error, releases)
+	start := make(chan struct{})
+	wait.Add(releases)
+	for i := 0; i < releases; i++ {
+		go func() {
+			defer wait.Done()
+			<-start
+			_, _, err := NewManageTxDao(db).ReleaseConfig(ctx, appId, 0, "user")
+			errs <- err
+		}()
+	}
+	close(start)
+	wait.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, common.ErrConflict):
+			t.Fatalf("release failed with %v, want a conflict", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d releases succeeded, want 1", succeeded)
+	}
+}
+
+// TestReleaseNamespaces merges the released keys of the linked namespaces
+// under the own keys of the app, the earlier link wins a key of both.
+func TestReleaseNamespaces(t *testing.T) {
+	db, closeDb := openMigratedDb(t)
+	defer closeDb()
+	ctx := context.Background()
+	manageTxDao := NewManageTxDao(db)
+
+	newApp := func(code string, public int) int64 {
+		app := &AppData{Name: code, Code: code, ApiKey: "key-" + code, Public: public, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+		if _, err := NewAppDao(db).InsertApp(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+		return app.AppId
+	}
+	set := func(appId int64, key, value string) {
+		config := &ConfigData{AppId: appId, Key: key, Value: value, Type: TYPE_TEXT, Status: STATUS_UN, Operate: OPERATE_NEW,
+			CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+		if _, err := NewConfigDao(db).InsertConfig(ctx, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(appId, linkAppId int64) {
+		appLink := &AppLinkData{AppId: appId, LinkAppId: linkAppId, CreateTime: common.NowJsonTime(), CreateBy: "alice"}
+		if _, err := NewAppLinkDao(db).InsertAppLink(ctx, appLink); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release := func(appId int64) {
+		appData, err := NewAppDao(db).QueryApp(ctx, appId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = manageTxDao.ReleaseConfig(ctx, appId, appData.ReleaseIndex, "alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	released := func(appId int64) map[string]string {
+		releaseData, err := NewReleaseDao(db).QueryRelease(ctx, appId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configs := make([]*ConfigData, 0)
+		if err = json.Unmarshal([]byte(releaseData.ConfigList), &configs); err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]string)
+		for _, config := range configs {
+			values[config.Key] = fmt.Sprintf("%s@%d", config.Value, config.AppId)
+		}
+		return values
+	}
+
+	kafka := newApp("kafka", APP_PUBLIC)
+	tracing := newApp("tracing", APP_PUBLIC)
+	base := newApp("base", APP_PUBLIC)
+	service := newApp("service", APP_PRIVATE)
+
+	set(base, "log.level", "debug")
+	release(base)
+	set(kafka, "brokers", "k1")
+	set(kafka, "timeout", "5s")
+	set(kafka, "endpoint", "kafka")
+	link(kafka, base)
+	release(kafka)
+	set(kafka, "acks", "all") // pending, not released
+	set(tracing, "endpoint", "tracing")
+	set(tracing, "sample", "0.1")
+	release(tracing)
+
+	set(service, "timeout", "1s")
+	link(service, kafka)
+	link(service, tracing)
+	release(service)
+
+	want := map[string]string{
+		"timeout":  fmt.Sprintf("1s@%d", service),  // own key over the namespace
+		"brokers":  fmt.Sprintf("k1@%d", kafka),    // namespace key
+		"endpoint": fmt.Sprintf("kafka@%d", kafka), // earlier link over the later one
+		"sample":   fmt.Sprintf("0.1@%d", tracing), // later link
+	}
+	got := released(service)
+	if len(got) != len(want) {
+		t.Fatalf("released %v, want %v", got, want)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Fatalf("released %s as %s, want %s", key, got[key], value)
+		}
+	}
+	if _, exist := got["log.level"]; exist {
+		t.Fatal("namespace linked by a namespace was merged")
+	}
+
+	// a new release of the namespace reaches the app on its refresh
+	release(kafka)
+	keys, _, err := manageTxDao.RefreshRelease(ctx, service, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "acks" || released(service)["acks"] != fmt.Sprintf("all@%d", kafka) {
+		t.Fatalf("refresh changed %v, want acks", keys)
+	}
+	if keys, _, err = manageTxDao.RefreshRelease(ctx, service, "alice"); err != nil || len(keys) != 0 {
+		t.Fatalf("unchanged refresh changed %v, %v", keys, err)
+	}
+}
@@ -0,0 +1,219 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// Migration is one step of the schema, Up and Down hold the statements of
+// each dialect separated by ";\n".
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[string]string
+	Down    map[string]string
+}
+
+// Migrations are the steps of the schema in version order, a feature adding a
+// table appends one here instead of editing the released ones.
+var Migrations = []*Migration{
+	migrationInit,
+	migrationClient,
+	migrationReleaseEvent,
+	migrationNamespace,
+	migrationConfigType,
+	migrationWebhook,
+	migrationSubscription,
+	migrationVersion,
+	migrationGitSync,
+}
+
+// LatestVersion is the version the server expects the database at.
+func LatestVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// 数据库版本
+type SchemaVersionData struct {
+	Version   int             `json:"version" DB_COL:"version" DB_PK:"version" DB_TABLE:"schema_version"`
+	Name      string          `json:"name" DB_COL:"name"`
+	ApplyTime common.JsonTime `json:"applyTime" DB_COL:"apply_time"`
+}
+
+// MigrationStatus is a migration and when it was applied, nil while pending.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	ApplyTime *common.JsonTime
+}
+
+var schemaVersionTable = map[string]string{
+	common.MYSQL: "CREATE TABLE IF NOT EXISTS `schema_version` (" +
+		"`version` int(11) NOT NULL COMMENT '版本', " +
+		"`name` varchar(255) NOT NULL COMMENT '名称', " +
+		"`apply_time` datetime NOT NULL COMMENT '执行时间', " +
+		"PRIMARY KEY (`version`)" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='数据库版本表'",
+	common.SQLITE: "CREATE TABLE IF NOT EXISTS `schema_version` (" +
+		"`version` INT NOT NULL PRIMARY KEY, " +
+		"`name` VARCHAR(255) NOT NULL, " +
+		"`apply_time` DATETIME NOT NULL)",
+	common.POSTGRES: "CREATE TABLE IF NOT EXISTS `schema_version` (" +
+		"`version` INTEGER NOT NULL PRIMARY KEY, " +
+		"`name` VARCHAR(255) NOT NULL, " +
+		"`apply_time` TIMESTAMP NOT NULL)",
+}
+
+// MigrateUp applies the pending migrations up to target, the latest when
+// target is 0, and returns the ones it applied.
+func (_self *SchemaDao) MigrateUp(ctx context.Context, target int) ([]*Migration, error) {
+	if target <= 0 {
+		target = LatestVersion()
+	}
+	applied, err := _self.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]*Migration, 0)
+	for _, migration := range Migrations {
+		if migration.Version > target {
+			break
+		}
+		if applied[migration.Version] != nil {
+			continue
+		}
+		err = _self.migrate(ctx, migration, migration.Up, true)
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the applied migrations above target, newest first, and
+// returns the ones it reverted.
+func (_self *SchemaDao) MigrateDown(ctx context.Context, target int) ([]*Migration, error) {
+	applied, err := _self.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make([]*Migration, 0)
+	for i := len(Migrations) - 1; i >= 0; i-- {
+		migration := Migrations[i]
+		if migration.Version <= target {
+			break
+		}
+		if applied[migration.Version] == nil {
+			continue
+		}
+		err = _self.migrate(ctx, migration, migration.Down, false)
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrationStatus lists every migration with its apply time.
+func (_self *SchemaDao) MigrationStatus(ctx context.Context) ([]*MigrationStatus, error) {
+	applied, err := _self.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*MigrationStatus, 0, len(Migrations))
+	for _, migration := range Migrations {
+		status := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if versionData := applied[migration.Version]; versionData != nil {
+			status.ApplyTime = &versionData.ApplyTime
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Version returns the newest applied migration, 0 for an empty database.
+func (_self *SchemaDao) Version(ctx context.Context) (int, error) {
+	version, err := _self.Count(ctx, "SELECT COALESCE(MAX(`version`), 0) FROM `schema_version`")
+	return int(version), err
+}
+
+func (_self *SchemaDao) appliedVersions(ctx context.Context) (map[int]*SchemaVersionData, error) {
+	ddl, ok := schemaVersionTable[_self.Dialect().Name()]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for %s", _self.Dialect().Name())
+	}
+	_, err := _self.Exec(ctx, ddl)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*SchemaVersionData, 0)
+	err = _self.StructSelect(ctx, &versions, "SELECT * FROM `schema_version`")
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]*SchemaVersionData)
+	for _, versionData := range versions {
+		applied[versionData.Version] = versionData
+	}
+	return applied, nil
+}
+
+// migrate runs the statements of one migration and records it in the same
+// transaction, mysql commits the DDL on its own.
+func (_self *SchemaDao) migrate(ctx context.Context, migration *Migration, statements map[string]string, up bool) error {
+	script, ok := statements[_self.Dialect().Name()]
+	if !ok {
+		return fmt.Errorf("no statements for %s", _self.Dialect().Name())
+	}
+
+	tx, err := _self.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, statement := range splitStatements(script) {
+		_, err = _self.ExecWithTx(ctx, tx, statement)
+		if err != nil {
+			return err
+		}
+	}
+	if up {
+		_, err = _self.StructUpsertWithTx(ctx, tx, &SchemaVersionData{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			ApplyTime: common.NowJsonTime(),
+		})
+	} else {
+		_, err = _self.ExecWithTx(ctx, tx, "DELETE FROM `schema_version` WHERE `version` = ?", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	err = _self.Commit(tx)
+	return err
+}
+
+func splitStatements(script string) []string {
+	statements := make([]string, 0)
+	for _, statement := range strings.Split(script, ";\n") {
+		statement = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(statement), ";"))
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
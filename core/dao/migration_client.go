@@ -0,0 +1,70 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 2 adds the client instances seen polling each app.
+var migrationClient = &Migration{
+	Version: 2,
+	Name:    "client",
+	Up: map[string]string{
+		common.MYSQL:    mysqlClient,
+		common.SQLITE:   sqliteClient,
+		common.POSTGRES: postgresClient,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropClient,
+		common.SQLITE:   dropClient,
+		common.POSTGRES: dropClient,
+	},
+}
+
+var mysqlClient = strings.Replace(`
+CREATE TABLE IF NOT EXISTS "client" (
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "instance_id" varchar(255) NOT NULL COMMENT '实例ID',
+  "ip" varchar(64) NOT NULL COMMENT '客户端IP',
+  "hostname" varchar(255) NOT NULL DEFAULT '' COMMENT '主机名',
+  "sdk_version" varchar(64) NOT NULL DEFAULT '' COMMENT 'SDK版本',
+  "last_index" int(11) NOT NULL DEFAULT '0' COMMENT '最近上报的发布序号',
+  "last_seen_time" datetime NOT NULL COMMENT '最近访问时间',
+  PRIMARY KEY ("app_id","instance_id"),
+  KEY "idx_last_seen_time" ("last_seen_time")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='客户端实例表';
+`, `"`, "`", -1)
+
+const sqliteClient = `
+CREATE TABLE IF NOT EXISTS client (
+  app_id BIGINT NOT NULL,
+  instance_id VARCHAR(255) NOT NULL,
+  ip VARCHAR(64) NOT NULL,
+  hostname VARCHAR(255) NOT NULL DEFAULT '',
+  sdk_version VARCHAR(64) NOT NULL DEFAULT '',
+  last_index INT NOT NULL DEFAULT 0,
+  last_seen_time DATETIME NOT NULL,
+  PRIMARY KEY (app_id, instance_id)
+);
+CREATE INDEX IF NOT EXISTS idx_client_last_seen_time ON client (last_seen_time);
+`
+
+const postgresClient = `
+CREATE TABLE IF NOT EXISTS "client" (
+  "app_id" BIGINT NOT NULL,                        -- 应用ID
+  "instance_id" VARCHAR(255) NOT NULL,             -- 实例ID
+  "ip" VARCHAR(64) NOT NULL,                       -- 客户端IP
+  "hostname" VARCHAR(255) NOT NULL DEFAULT '',     -- 主机名
+  "sdk_version" VARCHAR(64) NOT NULL DEFAULT '',   -- SDK版本
+  "last_index" INTEGER NOT NULL DEFAULT 0,         -- 最近上报的发布序号
+  "last_seen_time" TIMESTAMP NOT NULL,             -- 最近访问时间
+  PRIMARY KEY ("app_id", "instance_id")
+);
+CREATE INDEX IF NOT EXISTS "idx_client_last_seen_time" ON "client" ("last_seen_time");
+COMMENT ON TABLE "client" IS '客户端实例表';
+`
+
+var dropClient = strings.Replace(`
+DROP TABLE IF EXISTS "client";
+`, `"`, "`", -1)
@@ -0,0 +1,39 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 5 adds the type of config, text, flag or template.
+var migrationConfigType = &Migration{
+	Version: 5,
+	Name:    "config_type",
+	Up: map[string]string{
+		common.MYSQL:    mysqlConfigType,
+		common.SQLITE:   sqliteConfigType,
+		common.POSTGRES: postgresConfigType,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropConfigType,
+		common.SQLITE:   dropConfigType,
+		common.POSTGRES: dropConfigType,
+	},
+}
+
+var mysqlConfigType = strings.Replace(`
+ALTER TABLE "config" ADD COLUMN "type" tinyint(4) NOT NULL DEFAULT '1' COMMENT '类型（1-文本、2-特性开关、3-模板）';
+`, `"`, "`", -1)
+
+const sqliteConfigType = `
+ALTER TABLE config ADD COLUMN type TINYINT NOT NULL DEFAULT 1;
+`
+
+const postgresConfigType = `
+ALTER TABLE "config" ADD COLUMN "type" SMALLINT NOT NULL DEFAULT 1; -- 类型（1-文本、2-特性开关、3-模板）
+`
+
+var dropConfigType = strings.Replace(`
+ALTER TABLE "config" DROP COLUMN "type";
+`, `"`, "`", -1)
@@ -0,0 +1,81 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 9 adds the git repository each app is mirrored to.
+var migrationGitSync = &Migration{
+	Version: 9,
+	Name:    "git_sync",
+	Up: map[string]string{
+		common.MYSQL:    mysqlGitSync,
+		common.SQLITE:   sqliteGitSync,
+		common.POSTGRES: postgresGitSync,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropGitSync,
+		common.SQLITE:   dropGitSync,
+		common.POSTGRES: dropGitSync,
+	},
+}
+
+var mysqlGitSync = strings.Replace(`
+CREATE TABLE IF NOT EXISTS "git_sync" (
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "repo" varchar(1024) NOT NULL COMMENT '仓库地址',
+  "branch" varchar(255) NOT NULL COMMENT '分支',
+  "path" varchar(255) NOT NULL DEFAULT '' COMMENT '仓库内目录',
+  "format" varchar(32) NOT NULL COMMENT '文件格式（json、properties）',
+  "git_ops" tinyint(4) NOT NULL DEFAULT '2' COMMENT 'GitOps（1-启用、2-停用）',
+  "last_commit" varchar(64) NOT NULL DEFAULT '' COMMENT '最近同步的提交',
+  "last_error" varchar(1024) NOT NULL DEFAULT '' COMMENT '最近同步错误',
+  "sync_time" datetime DEFAULT NULL COMMENT '最近同步时间',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "create_by" varchar(255) NOT NULL COMMENT '创建者',
+  "update_time" datetime NOT NULL COMMENT '修改时间',
+  PRIMARY KEY ("app_id")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='Git同步表';
+`, `"`, "`", -1)
+
+const sqliteGitSync = `
+CREATE TABLE IF NOT EXISTS git_sync (
+  app_id BIGINT NOT NULL PRIMARY KEY,
+  repo VARCHAR(1024) NOT NULL,
+  branch VARCHAR(255) NOT NULL,
+  path VARCHAR(255) NOT NULL DEFAULT '',
+  format VARCHAR(32) NOT NULL,
+  git_ops TINYINT NOT NULL DEFAULT 2,
+  last_commit VARCHAR(64) NOT NULL DEFAULT '',
+  last_error VARCHAR(1024) NOT NULL DEFAULT '',
+  sync_time DATETIME DEFAULT NULL,
+  create_time DATETIME NOT NULL,
+  create_by VARCHAR(255) NOT NULL,
+  update_time DATETIME NOT NULL
+);
+`
+
+const postgresGitSync = `
+CREATE TABLE IF NOT EXISTS "git_sync" (
+  "app_id" BIGINT NOT NULL,                       -- 应用ID
+  "repo" VARCHAR(1024) NOT NULL,                  -- 仓库地址
+  "branch" VARCHAR(255) NOT NULL,                 -- 分支
+  "path" VARCHAR(255) NOT NULL DEFAULT '',        -- 仓库内目录
+  "format" VARCHAR(32) NOT NULL,                  -- 文件格式（json、properties）
+  "git_ops" SMALLINT NOT NULL DEFAULT 2,          -- GitOps（1-启用、2-停用）
+  "last_commit" VARCHAR(64) NOT NULL DEFAULT '',  -- 最近同步的提交
+  "last_error" VARCHAR(1024) NOT NULL DEFAULT '', -- 最近同步错误
+  "sync_time" TIMESTAMP DEFAULT NULL,             -- 最近同步时间
+  "create_time" TIMESTAMP NOT NULL,               -- 创建时间
+  "create_by" VARCHAR(255) NOT NULL,              -- 创建者
+  "update_time" TIMESTAMP NOT NULL,               -- 修改时间
+  PRIMARY KEY ("app_id")
+);
+COMMENT ON TABLE "git_sync" IS 'Git同步表';
+`
+
+var dropGitSync = strings.Replace(`
+DROP TABLE IF EXISTS "git_sync";
+`, `"`, "`", -1)
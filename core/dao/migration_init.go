@@ -0,0 +1,230 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 1 creates the tables of the former varconf.sql, IF NOT EXISTS lets it
+// adopt databases set up before migrations existed. It must stay that schema,
+// the later features add their tables and columns in migrations of their own.
+var migrationInit = &Migration{
+	Version: 1,
+	Name:    "init",
+	Up: map[string]string{
+		common.MYSQL:    mysqlInit,
+		common.SQLITE:   sqliteInit,
+		common.POSTGRES: postgresInit,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropInit,
+		common.SQLITE:   dropInit,
+		common.POSTGRES: dropInit,
+	},
+}
+
+var mysqlInit = strings.Replace(`
+CREATE TABLE IF NOT EXISTS "app" (
+  "app_id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  "name" varchar(255) NOT NULL COMMENT '应用代码',
+  "code" varchar(255) NOT NULL COMMENT '应用代号',
+  "desc" varchar(255) DEFAULT NULL COMMENT '描述',
+  "api_key" varchar(255) DEFAULT NULL COMMENT 'API密钥',
+  "release_index" int(11) NOT NULL DEFAULT '0' COMMENT '发布INDEX',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "update_time" datetime NOT NULL COMMENT '更新时间',
+  PRIMARY KEY ("app_id"),
+  UNIQUE KEY "uniq_code" ("code"),
+  UNIQUE KEY "uniq_api_key" ("api_key"),
+  KEY "idx_name" ("name")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='App信息表';
+
+CREATE TABLE IF NOT EXISTS "config" (
+  "config_id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '配置ID',
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "key" varchar(255) NOT NULL COMMENT '配置Key',
+  "value" longtext CHARACTER SET utf8mb4 NOT NULL COMMENT '配置Value',
+  "desc" varchar(255) NOT NULL COMMENT '配置描述',
+  "status" tinyint(4) NOT NULL DEFAULT '1' COMMENT '状态（1-待发布、2-已发布）',
+  "operate" tinyint(4) NOT NULL DEFAULT '1' COMMENT '操作标志（1-新增、2-更新、3-删除）',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "create_by" varchar(255) NOT NULL COMMENT '创建者',
+  "update_time" datetime NOT NULL COMMENT '修改时间',
+  "update_by" varchar(255) NOT NULL COMMENT '修改者',
+  "release_by" varchar(255) CHARACTER SET utf8 DEFAULT NULL COMMENT '发布者',
+  "release_time" datetime DEFAULT NULL COMMENT '发布时间',
+  PRIMARY KEY ("config_id"),
+  UNIQUE KEY "uniq_app_key" ("app_id","key") USING BTREE,
+  KEY "idx_app_id" ("app_id"),
+  KEY "idx_key" ("key")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='配置明细表';
+
+CREATE TABLE IF NOT EXISTS "release" (
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "config_list" longtext CHARACTER SET utf8mb4 NOT NULL COMMENT '配置列表',
+  "release_time" datetime NOT NULL COMMENT '修改时间',
+  "release_index" int(11) NOT NULL COMMENT '发布序号',
+  PRIMARY KEY ("app_id") USING BTREE,
+  KEY "index_app_id" ("app_id")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='发布版本表';
+
+CREATE TABLE IF NOT EXISTS "release_log" (
+  "id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '配置ID',
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "config_list" longtext CHARACTER SET utf8mb4 NOT NULL COMMENT '配置列表',
+  "release_time" datetime NOT NULL COMMENT '发布时间',
+  "release_index" int(11) NOT NULL COMMENT '发布序号',
+  "release_by" varchar(255) CHARACTER SET utf8 NOT NULL COMMENT '发布者',
+  PRIMARY KEY ("id"),
+  KEY "index_app_id" ("app_id")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='版本历史表';
+
+CREATE TABLE IF NOT EXISTS "user" (
+  "user_id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '用户ID',
+  "name" varchar(255) NOT NULL COMMENT '用户名',
+  "password" varchar(255) NOT NULL COMMENT '用户密码',
+  "permission" tinyint(4) NOT NULL DEFAULT '1' COMMENT '权限（1-普通用户、2-管理员）',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "update_time" datetime NOT NULL COMMENT '修改时间',
+  PRIMARY KEY ("user_id"),
+  UNIQUE KEY "uniq_name" ("name")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='用户表';
+`, `"`, "`", -1)
+
+const sqliteInit = `
+CREATE TABLE IF NOT EXISTS app (
+  app_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(255) NOT NULL,
+  code VARCHAR(255) NOT NULL UNIQUE,
+  desc VARCHAR(255) DEFAULT NULL,
+  api_key VARCHAR(255) DEFAULT NULL UNIQUE,
+  release_index INT NOT NULL DEFAULT 0,
+  create_time DATETIME NOT NULL,
+  update_time DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_app_name ON app (name);
+
+CREATE TABLE IF NOT EXISTS config (
+  config_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  app_id BIGINT NOT NULL,
+  key VARCHAR(255) NOT NULL,
+  value TEXT NOT NULL,
+  desc VARCHAR(255) NOT NULL,
+  status TINYINT NOT NULL DEFAULT 1,
+  operate TINYINT NOT NULL DEFAULT 1,
+  create_time DATETIME NOT NULL,
+  create_by VARCHAR(255) NOT NULL,
+  update_time DATETIME NOT NULL,
+  update_by VARCHAR(255) NOT NULL,
+  release_by VARCHAR(255) DEFAULT NULL,
+  release_time DATETIME DEFAULT NULL,
+  UNIQUE (app_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_config_key ON config (key);
+
+CREATE TABLE IF NOT EXISTS release (
+  app_id BIGINT NOT NULL PRIMARY KEY,
+  config_list TEXT NOT NULL,
+  release_time DATETIME NOT NULL,
+  release_index INT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS release_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  app_id BIGINT NOT NULL,
+  config_list TEXT NOT NULL,
+  release_time DATETIME NOT NULL,
+  release_index INT NOT NULL,
+  release_by VARCHAR(255) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_release_log_app_id ON release_log (app_id);
+
+CREATE TABLE IF NOT EXISTS user (
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(255) NOT NULL UNIQUE,
+  password VARCHAR(255) NOT NULL,
+  permission TINYINT NOT NULL DEFAULT 1,
+  create_time DATETIME NOT NULL,
+  update_time DATETIME NOT NULL
+);
+`
+
+const postgresInit = `
+CREATE TABLE IF NOT EXISTS "app" (
+  "app_id" BIGSERIAL NOT NULL,                -- 主键ID
+  "name" VARCHAR(255) NOT NULL,               -- 应用代码
+  "code" VARCHAR(255) NOT NULL,               -- 应用代号
+  "desc" VARCHAR(255) DEFAULT NULL,           -- 描述
+  "api_key" VARCHAR(255) DEFAULT NULL,        -- API密钥
+  "release_index" INTEGER NOT NULL DEFAULT 0, -- 发布INDEX
+  "create_time" TIMESTAMP NOT NULL,           -- 创建时间
+  "update_time" TIMESTAMP NOT NULL,           -- 更新时间
+  PRIMARY KEY ("app_id"),
+  CONSTRAINT "uniq_code" UNIQUE ("code"),
+  CONSTRAINT "uniq_api_key" UNIQUE ("api_key")
+);
+CREATE INDEX IF NOT EXISTS "idx_app_name" ON "app" ("name");
+COMMENT ON TABLE "app" IS 'App信息表';
+
+CREATE TABLE IF NOT EXISTS "config" (
+  "config_id" BIGSERIAL NOT NULL,          -- 配置ID
+  "app_id" BIGINT NOT NULL,                -- 应用ID
+  "key" VARCHAR(255) NOT NULL,             -- 配置Key
+  "value" TEXT NOT NULL,                   -- 配置Value
+  "desc" VARCHAR(255) NOT NULL,            -- 配置描述
+  "status" SMALLINT NOT NULL DEFAULT 1,    -- 状态（1-待发布、2-已发布）
+  "operate" SMALLINT NOT NULL DEFAULT 1,   -- 操作标志（1-新增、2-更新、3-删除）
+  "create_time" TIMESTAMP NOT NULL,        -- 创建时间
+  "create_by" VARCHAR(255) NOT NULL,       -- 创建者
+  "update_time" TIMESTAMP NOT NULL,        -- 修改时间
+  "update_by" VARCHAR(255) NOT NULL,       -- 修改者
+  "release_by" VARCHAR(255) DEFAULT NULL,  -- 发布者
+  "release_time" TIMESTAMP DEFAULT NULL,   -- 发布时间
+  PRIMARY KEY ("config_id"),
+  CONSTRAINT "uniq_app_key" UNIQUE ("app_id", "key")
+);
+CREATE INDEX IF NOT EXISTS "idx_config_key" ON "config" ("key");
+COMMENT ON TABLE "config" IS '配置明细表';
+
+CREATE TABLE IF NOT EXISTS "release" (
+  "app_id" BIGINT NOT NULL,           -- 应用ID
+  "config_list" TEXT NOT NULL,        -- 配置列表
+  "release_time" TIMESTAMP NOT NULL,  -- 修改时间
+  "release_index" INTEGER NOT NULL,   -- 发布序号
+  PRIMARY KEY ("app_id")
+);
+COMMENT ON TABLE "release" IS '发布版本表';
+
+CREATE TABLE IF NOT EXISTS "release_log" (
+  "id" BIGSERIAL NOT NULL,             -- 配置ID
+  "app_id" BIGINT NOT NULL,            -- 应用ID
+  "config_list" TEXT NOT NULL,         -- 配置列表
+  "release_time" TIMESTAMP NOT NULL,   -- 发布时间
+  "release_index" INTEGER NOT NULL,    -- 发布序号
+  "release_by" VARCHAR(255) NOT NULL,  -- 发布者
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_release_log_app_id" ON "release_log" ("app_id");
+COMMENT ON TABLE "release_log" IS '版本历史表';
+
+CREATE TABLE IF NOT EXISTS "user" (
+  "user_id" BIGSERIAL NOT NULL,              -- 用户ID
+  "name" VARCHAR(255) NOT NULL,              -- 用户名
+  "password" VARCHAR(255) NOT NULL,          -- 用户密码
+  "permission" SMALLINT NOT NULL DEFAULT 1,  -- 权限（1-普通用户、2-管理员）
+  "create_time" TIMESTAMP NOT NULL,          -- 创建时间
+  "update_time" TIMESTAMP NOT NULL,          -- 修改时间
+  PRIMARY KEY ("user_id"),
+  CONSTRAINT "uniq_name" UNIQUE ("name")
+);
+COMMENT ON TABLE "user" IS '用户表';
+`
+
+var dropInit = strings.Replace(`
+DROP TABLE IF EXISTS "user";
+DROP TABLE IF EXISTS "release_log";
+DROP TABLE IF EXISTS "release";
+DROP TABLE IF EXISTS "config";
+DROP TABLE IF EXISTS "app";
+`, `"`, "`", -1)
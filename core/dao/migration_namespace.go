@@ -0,0 +1,73 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 4 adds public namespaces and the apps linking them.
+var migrationNamespace = &Migration{
+	Version: 4,
+	Name:    "namespace",
+	Up: map[string]string{
+		common.MYSQL:    mysqlNamespace,
+		common.SQLITE:   sqliteNamespace,
+		common.POSTGRES: postgresNamespace,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropNamespace,
+		common.SQLITE:   dropNamespace,
+		common.POSTGRES: dropNamespace,
+	},
+}
+
+var mysqlNamespace = strings.Replace(`
+ALTER TABLE "app" ADD COLUMN "public" tinyint(4) NOT NULL DEFAULT '1' COMMENT '公共命名空间（1-私有、2-公共）';
+
+CREATE TABLE IF NOT EXISTS "app_link" (
+  "id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '主键ID',
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "link_app_id" bigint(20) NOT NULL COMMENT '公共命名空间应用ID',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "create_by" varchar(255) NOT NULL COMMENT '创建者',
+  PRIMARY KEY ("id"),
+  UNIQUE KEY "uniq_app_link" ("app_id","link_app_id"),
+  KEY "idx_link_app_id" ("link_app_id")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='公共命名空间关联表';
+`, `"`, "`", -1)
+
+const sqliteNamespace = `
+ALTER TABLE app ADD COLUMN public TINYINT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS app_link (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  app_id BIGINT NOT NULL,
+  link_app_id BIGINT NOT NULL,
+  create_time DATETIME NOT NULL,
+  create_by VARCHAR(255) NOT NULL,
+  UNIQUE (app_id, link_app_id)
+);
+CREATE INDEX IF NOT EXISTS idx_app_link_link_app_id ON app_link (link_app_id);
+`
+
+const postgresNamespace = `
+ALTER TABLE "app" ADD COLUMN "public" SMALLINT NOT NULL DEFAULT 1; -- 公共命名空间（1-私有、2-公共）
+
+CREATE TABLE IF NOT EXISTS "app_link" (
+  "id" BIGSERIAL NOT NULL,               -- 主键ID
+  "app_id" BIGINT NOT NULL,              -- 应用ID
+  "link_app_id" BIGINT NOT NULL,         -- 公共命名空间应用ID
+  "create_time" TIMESTAMP NOT NULL,      -- 创建时间
+  "create_by" VARCHAR(255) NOT NULL,     -- 创建者
+  PRIMARY KEY ("id"),
+  CONSTRAINT "uniq_app_link" UNIQUE ("app_id", "link_app_id")
+);
+CREATE INDEX IF NOT EXISTS "idx_app_link_link_app_id" ON "app_link" ("link_app_id");
+COMMENT ON TABLE "app_link" IS '公共命名空间关联表';
+`
+
+var dropNamespace = strings.Replace(`
+DROP TABLE IF EXISTS "app_link";
+ALTER TABLE "app" DROP COLUMN "public";
+`, `"`, "`", -1)
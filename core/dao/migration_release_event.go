@@ -0,0 +1,66 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 3 adds the release events the nodes of a cluster tail.
+var migrationReleaseEvent = &Migration{
+	Version: 3,
+	Name:    "release_event",
+	Up: map[string]string{
+		common.MYSQL:    mysqlReleaseEvent,
+		common.SQLITE:   sqliteReleaseEvent,
+		common.POSTGRES: postgresReleaseEvent,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropReleaseEvent,
+		common.SQLITE:   dropReleaseEvent,
+		common.POSTGRES: dropReleaseEvent,
+	},
+}
+
+var mysqlReleaseEvent = strings.Replace(`
+CREATE TABLE IF NOT EXISTS "release_event" (
+  "id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '事件ID',
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "key_list" longtext CHARACTER SET utf8mb4 NOT NULL COMMENT '变更Key列表',
+  "release_index" int(11) NOT NULL COMMENT '发布序号',
+  "node" varchar(64) NOT NULL COMMENT '发布节点',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY ("id"),
+  KEY "idx_create_time" ("create_time")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='发布事件表';
+`, `"`, "`", -1)
+
+const sqliteReleaseEvent = `
+CREATE TABLE IF NOT EXISTS release_event (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  app_id BIGINT NOT NULL,
+  key_list TEXT NOT NULL,
+  release_index INT NOT NULL,
+  node VARCHAR(64) NOT NULL,
+  create_time DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_release_event_create_time ON release_event (create_time);
+`
+
+const postgresReleaseEvent = `
+CREATE TABLE IF NOT EXISTS "release_event" (
+  "id" BIGSERIAL NOT NULL,            -- 事件ID
+  "app_id" BIGINT NOT NULL,           -- 应用ID
+  "key_list" TEXT NOT NULL,           -- 变更Key列表
+  "release_index" INTEGER NOT NULL,   -- 发布序号
+  "node" VARCHAR(64) NOT NULL,        -- 发布节点
+  "create_time" TIMESTAMP NOT NULL,   -- 创建时间
+  PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_release_event_create_time" ON "release_event" ("create_time");
+COMMENT ON TABLE "release_event" IS '发布事件表';
+`
+
+var dropReleaseEvent = strings.Replace(`
+DROP TABLE IF EXISTS "release_event";
+`, `"`, "`", -1)
@@ -0,0 +1,66 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 7 adds the mail subscriptions of each app.
+var migrationSubscription = &Migration{
+	Version: 7,
+	Name:    "subscription",
+	Up: map[string]string{
+		common.MYSQL:    mysqlSubscription,
+		common.SQLITE:   sqliteSubscription,
+		common.POSTGRES: postgresSubscription,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropSubscription,
+		common.SQLITE:   dropSubscription,
+		common.POSTGRES: dropSubscription,
+	},
+}
+
+var mysqlSubscription = strings.Replace(`
+CREATE TABLE IF NOT EXISTS "subscription" (
+  "subscription_id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '订阅ID',
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "email" varchar(255) NOT NULL COMMENT '邮箱',
+  "events" varchar(255) NOT NULL COMMENT '订阅事件（逗号分隔）',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "create_by" varchar(255) NOT NULL COMMENT '创建者',
+  PRIMARY KEY ("subscription_id"),
+  KEY "idx_app_id" ("app_id")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='邮件订阅表';
+`, `"`, "`", -1)
+
+const sqliteSubscription = `
+CREATE TABLE IF NOT EXISTS subscription (
+  subscription_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  app_id BIGINT NOT NULL,
+  email VARCHAR(255) NOT NULL,
+  events VARCHAR(255) NOT NULL,
+  create_time DATETIME NOT NULL,
+  create_by VARCHAR(255) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_subscription_app_id ON subscription (app_id);
+`
+
+const postgresSubscription = `
+CREATE TABLE IF NOT EXISTS "subscription" (
+  "subscription_id" BIGSERIAL NOT NULL,  -- 订阅ID
+  "app_id" BIGINT NOT NULL,              -- 应用ID
+  "email" VARCHAR(255) NOT NULL,         -- 邮箱
+  "events" VARCHAR(255) NOT NULL,        -- 订阅事件（逗号分隔）
+  "create_time" TIMESTAMP NOT NULL,      -- 创建时间
+  "create_by" VARCHAR(255) NOT NULL,     -- 创建者
+  PRIMARY KEY ("subscription_id")
+);
+CREATE INDEX IF NOT EXISTS "idx_subscription_app_id" ON "subscription" ("app_id");
+COMMENT ON TABLE "subscription" IS '邮件订阅表';
+`
+
+var dropSubscription = strings.Replace(`
+DROP TABLE IF EXISTS "subscription";
+`, `"`, "`", -1)
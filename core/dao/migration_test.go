@@ -0,0 +1,101 @@
+package dao
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"varconf-server/core/dao/common"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openEmptyDb(t *testing.T) (*common.DB, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "varconf-dao")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenStorage("sqlite", "file:"+filepath.Join(dir, "varconf.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestMigrateBaseline upgrades a database set up from varconf.sql before
+// migrations existed, the data stays and the later columns are added.
+func TestMigrateBaseline(t *testing.T) {
+	db, closeDb := openEmptyDb(t)
+	defer closeDb()
+	ctx := context.Background()
+
+	for _, statement := range splitStatements(sqliteInit) {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := db.Exec("INSERT INTO app (name, code, desc, api_key, release_index, create_time, update_time) VALUES ('old', 'old', '', 'k1', 0, ?, ?)",
+		common.NowJsonTime(), common.NowJsonTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schemaDao := NewSchemaDao(db)
+	if _, err = schemaDao.MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = schemaDao.CheckVersion(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	appDao := NewAppDao(db)
+	old, err := appDao.QueryApp(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Code != "old" || old.Public != APP_PRIVATE {
+		t.Fatalf("baseline app upgraded to code %q public %d", old.Code, old.Public)
+	}
+	app := &AppData{Name: "new", Code: "new", ApiKey: "k2", Public: APP_PUBLIC, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	if _, err = appDao.InsertApp(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	config := &ConfigData{AppId: app.AppId, Key: "a", Value: "1", Type: TYPE_FLAG, Status: STATUS_UN, Operate: OPERATE_NEW,
+		CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	if _, err = NewConfigDao(db).InsertConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMigrateDown reverts every migration above the baseline and applies them
+// again.
+func TestMigrateDown(t *testing.T) {
+	db, closeDb := openEmptyDb(t)
+	defer closeDb()
+	ctx := context.Background()
+
+	schemaDao := NewSchemaDao(db)
+	if _, err := schemaDao.MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := schemaDao.MigrateDown(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(Migrations)-1 {
+		t.Fatalf("reverted %d migrations, want %d", len(reverted), len(Migrations)-1)
+	}
+	if version, _ := schemaDao.Version(ctx); version != 1 {
+		t.Fatalf("at version %d, want 1", version)
+	}
+	if _, err = schemaDao.MigrateUp(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 8 adds the version of app and config which guards the edits
+// against overwriting each other.
+var migrationVersion = &Migration{
+	Version: 8,
+	Name:    "version",
+	Up: map[string]string{
+		common.MYSQL:    mysqlVersion,
+		common.SQLITE:   sqliteVersion,
+		common.POSTGRES: postgresVersion,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropVersion,
+		common.SQLITE:   dropVersion,
+		common.POSTGRES: dropVersion,
+	},
+}
+
+var mysqlVersion = strings.Replace(`
+ALTER TABLE "app" ADD COLUMN "version" int(11) NOT NULL DEFAULT '1' COMMENT '版本号';
+ALTER TABLE "config" ADD COLUMN "version" int(11) NOT NULL DEFAULT '1' COMMENT '版本号';
+`, `"`, "`", -1)
+
+const sqliteVersion = `
+ALTER TABLE app ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE config ADD COLUMN version INT NOT NULL DEFAULT 1;
+`
+
+const postgresVersion = `
+ALTER TABLE "app" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;    -- 版本号
+ALTER TABLE "config" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1; -- 版本号
+`
+
+var dropVersion = strings.Replace(`
+ALTER TABLE "config" DROP COLUMN "version";
+ALTER TABLE "app" DROP COLUMN "version";
+`, `"`, "`", -1)
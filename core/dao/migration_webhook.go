@@ -0,0 +1,133 @@
+package dao
+
+import (
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// migration 6 adds the webhooks of each app and their deliveries.
+var migrationWebhook = &Migration{
+	Version: 6,
+	Name:    "webhook",
+	Up: map[string]string{
+		common.MYSQL:    mysqlWebhook,
+		common.SQLITE:   sqliteWebhook,
+		common.POSTGRES: postgresWebhook,
+	},
+	Down: map[string]string{
+		common.MYSQL:    dropWebhook,
+		common.SQLITE:   dropWebhook,
+		common.POSTGRES: dropWebhook,
+	},
+}
+
+var mysqlWebhook = strings.Replace(`
+CREATE TABLE IF NOT EXISTS "webhook" (
+  "webhook_id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT 'WebhookID',
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "url" varchar(1024) NOT NULL COMMENT '回调地址',
+  "secret" varchar(255) NOT NULL DEFAULT '' COMMENT '签名密钥',
+  "events" varchar(255) NOT NULL COMMENT '订阅事件（逗号分隔）',
+  "status" tinyint(4) NOT NULL DEFAULT '1' COMMENT '状态（1-启用、2-停用）',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "create_by" varchar(255) NOT NULL COMMENT '创建者',
+  "update_time" datetime NOT NULL COMMENT '修改时间',
+  PRIMARY KEY ("webhook_id"),
+  KEY "idx_app_id" ("app_id")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='Webhook表';
+
+CREATE TABLE IF NOT EXISTS "webhook_delivery" (
+  "delivery_id" bigint(20) NOT NULL AUTO_INCREMENT COMMENT '投递ID',
+  "webhook_id" bigint(20) NOT NULL COMMENT 'WebhookID',
+  "app_id" bigint(20) NOT NULL COMMENT '应用ID',
+  "event" varchar(64) NOT NULL COMMENT '事件',
+  "payload" longtext CHARACTER SET utf8mb4 NOT NULL COMMENT '投递内容',
+  "status" tinyint(4) NOT NULL DEFAULT '1' COMMENT '状态（1-投递中、2-成功、3-失败）',
+  "attempts" int(11) NOT NULL DEFAULT '0' COMMENT '尝试次数',
+  "response_code" int(11) NOT NULL DEFAULT '0' COMMENT '响应码',
+  "error" varchar(1024) NOT NULL DEFAULT '' COMMENT '错误信息',
+  "claim_time" datetime NOT NULL COMMENT '认领时间',
+  "create_time" datetime NOT NULL COMMENT '创建时间',
+  "update_time" datetime NOT NULL COMMENT '修改时间',
+  PRIMARY KEY ("delivery_id"),
+  KEY "idx_webhook_id" ("webhook_id"),
+  KEY "idx_status_claim_time" ("status", "claim_time"),
+  KEY "idx_create_time" ("create_time")
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='Webhook投递记录表';
+`, `"`, "`", -1)
+
+const sqliteWebhook = `
+CREATE TABLE IF NOT EXISTS webhook (
+  webhook_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  app_id BIGINT NOT NULL,
+  url VARCHAR(1024) NOT NULL,
+  secret VARCHAR(255) NOT NULL DEFAULT '',
+  events VARCHAR(255) NOT NULL,
+  status TINYINT NOT NULL DEFAULT 1,
+  create_time DATETIME NOT NULL,
+  create_by VARCHAR(255) NOT NULL,
+  update_time DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_app_id ON webhook (app_id);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  webhook_id BIGINT NOT NULL,
+  app_id BIGINT NOT NULL,
+  event VARCHAR(64) NOT NULL,
+  payload TEXT NOT NULL,
+  status TINYINT NOT NULL DEFAULT 1,
+  attempts INT NOT NULL DEFAULT 0,
+  response_code INT NOT NULL DEFAULT 0,
+  error VARCHAR(1024) NOT NULL DEFAULT '',
+  claim_time DATETIME NOT NULL,
+  create_time DATETIME NOT NULL,
+  update_time DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_webhook_id ON webhook_delivery (webhook_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_status_claim_time ON webhook_delivery (status, claim_time);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_create_time ON webhook_delivery (create_time);
+`
+
+const postgresWebhook = `
+CREATE TABLE IF NOT EXISTS "webhook" (
+  "webhook_id" BIGSERIAL NOT NULL,           -- WebhookID
+  "app_id" BIGINT NOT NULL,                  -- 应用ID
+  "url" VARCHAR(1024) NOT NULL,              -- 回调地址
+  "secret" VARCHAR(255) NOT NULL DEFAULT '', -- 签名密钥
+  "events" VARCHAR(255) NOT NULL,            -- 订阅事件（逗号分隔）
+  "status" SMALLINT NOT NULL DEFAULT 1,      -- 状态（1-启用、2-停用）
+  "create_time" TIMESTAMP NOT NULL,          -- 创建时间
+  "create_by" VARCHAR(255) NOT NULL,         -- 创建者
+  "update_time" TIMESTAMP NOT NULL,          -- 修改时间
+  PRIMARY KEY ("webhook_id")
+);
+CREATE INDEX IF NOT EXISTS "idx_webhook_app_id" ON "webhook" ("app_id");
+COMMENT ON TABLE "webhook" IS 'Webhook表';
+
+CREATE TABLE IF NOT EXISTS "webhook_delivery" (
+  "delivery_id" BIGSERIAL NOT NULL,          -- 投递ID
+  "webhook_id" BIGINT NOT NULL,              -- WebhookID
+  "app_id" BIGINT NOT NULL,                  -- 应用ID
+  "event" VARCHAR(64) NOT NULL,              -- 事件
+  "payload" TEXT NOT NULL,                   -- 投递内容
+  "status" SMALLINT NOT NULL DEFAULT 1,      -- 状态（1-投递中、2-成功、3-失败）
+  "attempts" INTEGER NOT NULL DEFAULT 0,     -- 尝试次数
+  "response_code" INTEGER NOT NULL DEFAULT 0,-- 响应码
+  "error" VARCHAR(1024) NOT NULL DEFAULT '', -- 错误信息
+  "claim_time" TIMESTAMP NOT NULL,           -- 认领时间
+  "create_time" TIMESTAMP NOT NULL,          -- 创建时间
+  "update_time" TIMESTAMP NOT NULL,          -- 修改时间
+  PRIMARY KEY ("delivery_id")
+);
+CREATE INDEX IF NOT EXISTS "idx_webhook_delivery_webhook_id" ON "webhook_delivery" ("webhook_id");
+CREATE INDEX IF NOT EXISTS "idx_webhook_delivery_status_claim_time" ON "webhook_delivery" ("status", "claim_time");
+CREATE INDEX IF NOT EXISTS "idx_webhook_delivery_create_time" ON "webhook_delivery" ("create_time");
+COMMENT ON TABLE "webhook_delivery" IS 'Webhook投递记录表';
+`
+
+var dropWebhook = strings.Replace(`
+DROP TABLE IF EXISTS "webhook_delivery";
+DROP TABLE IF EXISTS "webhook";
+`, `"`, "`", -1)
@@ -2,7 +2,7 @@ package dao
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"fmt"
 	"strings"
 
@@ -20,12 +20,12 @@ type ReleaseDao struct {
 	common.Dao
 }
 
-func NewReleaseDao(db *sql.DB) *ReleaseDao {
-	releaseDao := ReleaseDao{common.Dao{DB: db}}
+func NewReleaseDao(db *common.DB) *ReleaseDao {
+	releaseDao := ReleaseDao{common.NewDao(db.DB, db.Dialect)}
 	return &releaseDao
 }
 
-func (_self *ReleaseDao) QueryReleases(appIds []int64) []*ReleaseData {
+func (_self *ReleaseDao) QueryReleases(ctx context.Context, appIds []int64) ([]*ReleaseData, error) {
 	values := make([]interface{}, 0)
 	sql := "SELECT * FROM `release` WHERE `app_id` in "
 
@@ -36,51 +36,33 @@ func (_self *ReleaseDao) QueryReleases(appIds []int64) []*ReleaseData {
 	sql = sql + "(" + strings.Trim(ids.String(), ", ") + ")"
 
 	releases := make([]*ReleaseData, 0)
-	success, err := _self.StructSelect(&releases, sql, values...)
+	err := _self.StructSelect(ctx, &releases, sql, values...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	if success {
-		return releases
-	}
-	return nil
+	return releases, nil
 }
 
-func (_self *ReleaseDao) QueryRelease(appId int64) *ReleaseData {
+func (_self *ReleaseDao) QueryRelease(ctx context.Context, appId int64) (*ReleaseData, error) {
 	release := ReleaseData{}
-	success, err := _self.StructSelectByPK(&release, appId)
+	err := _self.StructSelectByPK(ctx, &release, appId)
 	if err != nil {
-		panic(err)
-	}
-	if success {
-		return &release
+		return nil, err
 	}
-	return nil
+	return &release, nil
 }
 
-func (_self *ReleaseDao) InsertRelease(data *ReleaseData) int64 {
-	rowCnt, err := _self.StructInsert(data, true)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+func (_self *ReleaseDao) InsertRelease(ctx context.Context, data *ReleaseData) (int64, error) {
+	return _self.StructInsert(ctx, data, true)
 }
 
-func (_self *ReleaseDao) UpsertRelease(data *ReleaseData) int64 {
-	rowCnt, err := _self.StructUpsert(data)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+func (_self *ReleaseDao) UpsertRelease(ctx context.Context, data *ReleaseData) (int64, error) {
+	return _self.StructUpsert(ctx, data)
 }
 
-func (_self *ReleaseDao) SelectedUpdateRelease(data ReleaseData) int64 {
+func (_self *ReleaseDao) SelectedUpdateRelease(ctx context.Context, data ReleaseData) (int64, error) {
 	sql, values := _self.prepareSelectedUpdate(data)
-	rowCnt, err := _self.Exec(sql, values...)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+	return _self.Exec(ctx, sql, values...)
 }
 
 func (_self *ReleaseDao) prepareSelectedUpdate(data ReleaseData) (string, []interface{}) {
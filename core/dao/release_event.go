@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"varconf-server/core/dao/common"
+)
+
+// 发布事件
+type ReleaseEventData struct {
+	Id           int64           `json:"id" DB_COL:"id" DB_PK:"id" DB_TABLE:"release_event"`
+	AppId        int64           `json:"appId" DB_COL:"app_id"`
+	KeyList      string          `json:"keyList" DB_COL:"key_list"`
+	ReleaseIndex int             `json:"releaseIndex" DB_COL:"release_index"`
+	Node         string          `json:"node" DB_COL:"node"`
+	CreateTime   common.JsonTime `json:"createTime" DB_COL:"create_time"`
+}
+
+type ReleaseEventDao struct {
+	common.Dao
+}
+
+func NewReleaseEventDao(db *common.DB) *ReleaseEventDao {
+	releaseEventDao := ReleaseEventDao{common.NewDao(db.DB, db.Dialect)}
+	return &releaseEventDao
+}
+
+func (_self *ReleaseEventDao) QueryReleaseEvents(ctx context.Context, afterId int64) ([]*ReleaseEventData, error) {
+	sql := "SELECT * FROM `release_event` WHERE `id` > ? ORDER BY `id`"
+
+	releaseEvents := make([]*ReleaseEventData, 0)
+	err := _self.StructSelect(ctx, &releaseEvents, sql, afterId)
+	if err != nil {
+		return nil, err
+	}
+	return releaseEvents, nil
+}
+
+func (_self *ReleaseEventDao) MaxReleaseEventId(ctx context.Context) (int64, error) {
+	sql := "SELECT COALESCE(MAX(`id`), 0) FROM `release_event`"
+	return _self.Count(ctx, sql)
+}
+
+func (_self *ReleaseEventDao) InsertReleaseEvent(ctx context.Context, data *ReleaseEventData) (int64, error) {
+	return _self.StructInsert(ctx, data, false)
+}
+
+func (_self *ReleaseEventDao) DeleteReleaseEvents(ctx context.Context, before time.Time) (int64, error) {
+	sql := "DELETE FROM `release_event` WHERE `create_time` < ?"
+	return _self.Exec(ctx, sql, before)
+}
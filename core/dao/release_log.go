@@ -1,7 +1,7 @@
 package dao
 
 import (
-	"database/sql"
+	"context"
 
 	"varconf-server/core/dao/common"
 )
@@ -27,34 +27,51 @@ type ReleaseLogDao struct {
 	common.Dao
 }
 
-func NewReleaseLogDao(db *sql.DB) *ReleaseLogDao {
-	releaseLogDao := ReleaseLogDao{common.Dao{DB: db}}
+func NewReleaseLogDao(db *common.DB) *ReleaseLogDao {
+	releaseLogDao := ReleaseLogDao{common.NewDao(db.DB, db.Dialect)}
 	return &releaseLogDao
 }
 
-func (_self *ReleaseLogDao) QueryReleaseLogs(appId int64) []*ReleaseLogData {
+func (_self *ReleaseLogDao) QueryReleaseLogs(ctx context.Context, appId int64) ([]*ReleaseLogData, error) {
 	sql := "SELECT * FROM `release_log` WHERE `app_id` = ?"
 
 	releaseLogs := make([]*ReleaseLogData, 0)
-	success, err := _self.StructSelect(&releaseLogs, sql, appId)
+	err := _self.StructSelect(ctx, &releaseLogs, sql, appId)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	if success {
-		return releaseLogs
-	}
-	return nil
+	return releaseLogs, nil
+}
+
+// QueryReleaseLog returns the release of app at releaseIndex.
+func (_self *ReleaseLogDao) QueryReleaseLog(ctx context.Context, appId int64, releaseIndex int) (*ReleaseLogData, error) {
+	sql := "SELECT * FROM `release_log` WHERE `app_id` = ? AND `release_index` = ? ORDER BY `id` DESC LIMIT 1"
+	return _self.queryReleaseLog(ctx, sql, appId, releaseIndex)
+}
+
+// QueryPrevReleaseLog returns the latest release of app before releaseIndex.
+func (_self *ReleaseLogDao) QueryPrevReleaseLog(ctx context.Context, appId int64, releaseIndex int) (*ReleaseLogData, error) {
+	sql := "SELECT * FROM `release_log` WHERE `app_id` = ? AND `release_index` < ? ORDER BY `release_index` DESC LIMIT 1"
+	return _self.queryReleaseLog(ctx, sql, appId, releaseIndex)
 }
 
-func (_self *ReleaseLogDao) CountReleaseLogs() int64 {
+func (_self *ReleaseLogDao) CountReleaseLogs(ctx context.Context) (int64, error) {
 	sql := "SELECT count(1) FROM `release_log`"
-	return _self.Count(sql)
+	return _self.Count(ctx, sql)
 }
 
-func (_self *ReleaseLogDao) InsertReleaseLog(data *ReleaseLogData) int64 {
-	rowCnt, err := _self.StructInsert(data, false)
+func (_self *ReleaseLogDao) InsertReleaseLog(ctx context.Context, data *ReleaseLogData) (int64, error) {
+	return _self.StructInsert(ctx, data, false)
+}
+
+func (_self *ReleaseLogDao) queryReleaseLog(ctx context.Context, sql string, values ...interface{}) (*ReleaseLogData, error) {
+	releaseLogs := make([]*ReleaseLogData, 0)
+	err := _self.StructSelect(ctx, &releaseLogs, sql, values...)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if len(releaseLogs) == 0 {
+		return nil, common.NotFound("release log")
 	}
-	return rowCnt
+	return releaseLogs[0], nil
 }
@@ -0,0 +1,64 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+// OpenStorage opens the database of driver, mysql, postgres (postgresql for short)
+// or sqlite3 (sqlite for short).
+func OpenStorage(driver, dataSource string) (*common.DB, error) {
+	switch driver {
+	case "sqlite":
+		driver = common.SQLITE
+	case "postgresql":
+		driver = common.POSTGRES
+	}
+	if driver == common.SQLITE {
+		dataSource = immediateTx(dataSource)
+	}
+	return common.Open(driver, dataSource)
+}
+
+// immediateTx makes the sqlite transactions take the write lock as they begin,
+// a deferred one upgrading its read lock fails with SQLITE_BUSY where the
+// other databases wait for the lock. Concurrent releases serialise then and
+// the loser sees the bumped release index.
+func immediateTx(dataSource string) string {
+	if strings.Contains(dataSource, "_txlock=") {
+		return dataSource
+	}
+	if strings.Contains(dataSource, "?") {
+		return dataSource + "&_txlock=immediate"
+	}
+	return dataSource + "?_txlock=immediate"
+}
+
+type SchemaDao struct {
+	common.Dao
+}
+
+func NewSchemaDao(db *common.DB) *SchemaDao {
+	schemaDao := SchemaDao{common.NewDao(db.DB, db.Dialect)}
+	return &schemaDao
+}
+
+func (_self *SchemaDao) Ping(ctx context.Context) error {
+	return _self.DB.PingContext(ctx)
+}
+
+// CheckVersion returns an error when the database is behind the migrations
+// of this build.
+func (_self *SchemaDao) CheckVersion(ctx context.Context) error {
+	version, err := _self.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version < LatestVersion() {
+		return fmt.Errorf("schema version %d, want %d", version, LatestVersion())
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package dao
+
+import (
+	"context"
+
+	"varconf-server/core/dao/common"
+)
+
+// 邮件订阅
+type SubscriptionData struct {
+	SubscriptionId int64           `json:"subscriptionId" DB_COL:"subscription_id" DB_PK:"subscription_id" DB_TABLE:"subscription"`
+	AppId          int64           `json:"appId" DB_COL:"app_id"`
+	Email          string          `json:"email" DB_COL:"email"`
+	Events         string          `json:"events" DB_COL:"events"`
+	CreateTime     common.JsonTime `json:"createTime" DB_COL:"create_time"`
+	CreateBy       string          `json:"createBy" DB_COL:"create_by"`
+}
+
+type SubscriptionDao struct {
+	common.Dao
+}
+
+func NewSubscriptionDao(db *common.DB) *SubscriptionDao {
+	subscriptionDao := SubscriptionDao{common.NewDao(db.DB, db.Dialect)}
+	return &subscriptionDao
+}
+
+func (_self *SubscriptionDao) QuerySubscriptions(ctx context.Context, appId int64) ([]*SubscriptionData, error) {
+	sql := "SELECT * FROM `subscription` WHERE `app_id` = ? ORDER BY `subscription_id`"
+
+	subscriptions := make([]*SubscriptionData, 0)
+	err := _self.StructSelect(ctx, &subscriptions, sql, appId)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (_self *SubscriptionDao) InsertSubscription(ctx context.Context, data *SubscriptionData) (int64, error) {
+	return _self.StructInsert(ctx, data, false)
+}
+
+func (_self *SubscriptionDao) DeleteSubscription(ctx context.Context, appId, subscriptionId int64) (int64, error) {
+	sql := "DELETE FROM `subscription` WHERE `app_id` = ? AND `subscription_id` = ?"
+	return _self.Exec(ctx, sql, appId, subscriptionId)
+}
+
+func (_self *SubscriptionDao) DeleteSubscriptions(ctx context.Context, appId int64) (int64, error) {
+	sql := "DELETE FROM `subscription` WHERE `app_id` = ?"
+	return _self.Exec(ctx, sql, appId)
+}
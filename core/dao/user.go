@@ -2,7 +2,7 @@ package dao
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"strings"
 
 	"varconf-server/core/dao/common"
@@ -34,53 +34,39 @@ type UserDao struct {
 	common.Dao
 }
 
-func NewUserDao(db *sql.DB) *UserDao {
-	userDao := UserDao{common.Dao{DB: db}}
+func NewUserDao(db *common.DB) *UserDao {
+	userDao := UserDao{common.NewDao(db.DB, db.Dialect)}
 	return &userDao
 }
 
-func (_self *UserDao) QueryUsers(queryUserData QueryUserData) []*UserData {
+func (_self *UserDao) QueryUsers(ctx context.Context, queryUserData QueryUserData) ([]*UserData, error) {
 	sql, values := _self.prepareSelectedQuery(false, queryUserData)
 	users := make([]*UserData, 0)
-	success, err := _self.StructSelect(&users, sql, values...)
+	err := _self.StructSelect(ctx, &users, sql, values...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	if success {
-		return users
-	}
-	return nil
+	return users, nil
 }
 
-func (_self *UserDao) CountUsers(queryUserData QueryUserData) int64 {
+func (_self *UserDao) CountUsers(ctx context.Context, queryUserData QueryUserData) (int64, error) {
 	sql, values := _self.prepareSelectedQuery(true, queryUserData)
-	return _self.Count(sql, values...)
+	return _self.Count(ctx, sql, values...)
 }
 
-func (_self *UserDao) InsertUser(user *UserData) int64 {
-	rowCnt, err := _self.StructInsert(user, false)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+func (_self *UserDao) InsertUser(ctx context.Context, user *UserData) (int64, error) {
+	return _self.StructInsert(ctx, user, false)
 }
 
-func (_self *UserDao) SelectedUpdateUser(user UserData) int64 {
-	sql, values := _self.prepareSelectedUpdate(user)
-	rowCnt, err := _self.Exec(sql, values...)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+// SelectedUpdateUser updates the fields of user.
+func (_self *UserDao) SelectedUpdateUser(ctx context.Context, user UserData, fields common.Fields) (int64, error) {
+	sql, values := _self.prepareSelectedUpdate(user, fields)
+	return _self.Exec(ctx, sql, values...)
 }
 
-func (_self *UserDao) DeleteUser(userId int64) int64 {
+func (_self *UserDao) DeleteUser(ctx context.Context, userId int64) (int64, error) {
 	sql := "DELETE FROM `user` WHERE `user_id` = ?"
-	rowCnt, err := _self.Exec(sql, userId)
-	if err != nil {
-		panic(err)
-	}
-	return rowCnt
+	return _self.Exec(ctx, sql, userId)
 }
 
 func (_self *UserDao) prepareSelectedQuery(count bool, queryUserData QueryUserData) (string, []interface{}) {
@@ -103,40 +89,41 @@ func (_self *UserDao) prepareSelectedQuery(count bool, queryUserData QueryUserDa
 		values = append(values, queryUserData.Name)
 	}
 	if queryUserData.LikeName != "" {
-		buffer.WriteString(" AND `name` like '")
-		buffer.WriteString(queryUserData.LikeName)
-		buffer.WriteString("%'")
+		like, likeValues := _self.LikePrefix("name", queryUserData.LikeName)
+		buffer.WriteString(" AND" + like)
+		values = append(values, likeValues...)
 	}
 	if queryUserData.Start >= 0 && queryUserData.End > 0 {
-		buffer.WriteString(" LIMIT ?, ?")
-		values = append(values, queryUserData.Start, queryUserData.End)
+		limit, limitValues := _self.Limit(queryUserData.Start, queryUserData.End)
+		buffer.WriteString(limit)
+		values = append(values, limitValues...)
 	}
 
 	return buffer.String(), values
 }
 
-func (_self *UserDao) prepareSelectedUpdate(user UserData) (string, []interface{}) {
+func (_self *UserDao) prepareSelectedUpdate(user UserData, fields common.Fields) (string, []interface{}) {
 	buffer := bytes.Buffer{}
 	buffer.WriteString("UPDATE `user` SET ")
 
 	values := make([]interface{}, 0)
-	if user.Name != "" {
+	if fields.Has("name") {
 		values = append(values, user.Name)
 		buffer.WriteString("`name` = ?,")
 	}
-	if user.Password != "" {
+	if fields.Has("password") {
 		values = append(values, user.Password)
 		buffer.WriteString("`password` = ?,")
 	}
-	if user.Permission != 0 {
+	if fields.Has("permission") {
 		values = append(values, user.Permission)
 		buffer.WriteString("`permission` = ?,")
 	}
-	if !user.CreateTime.IsZero() {
+	if fields.Has("createTime") {
 		values = append(values, user.CreateTime)
 		buffer.WriteString("`create_time` = ?,")
 	}
-	if !user.UpdateTime.IsZero() {
+	if fields.Has("UpdateTime") {
 		values = append(values, user.UpdateTime)
 		buffer.WriteString("`update_time` = ?,")
 	}
@@ -0,0 +1,112 @@
+package dao
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"varconf-server/core/dao/common"
+)
+
+const (
+	// 1-启用、2-停用
+	WEBHOOK_ENABLED  = 1
+	WEBHOOK_DISABLED = 2
+)
+
+// 应用Webhook
+type WebhookData struct {
+	WebhookId  int64           `json:"webhookId" DB_COL:"webhook_id" DB_PK:"webhook_id" DB_TABLE:"webhook"`
+	AppId      int64           `json:"appId" DB_COL:"app_id"`
+	Url        string          `json:"url" DB_COL:"url"`
+	Secret     string          `json:"secret" DB_COL:"secret"`
+	Events     string          `json:"events" DB_COL:"events"`
+	Status     int             `json:"status" DB_COL:"status"`
+	CreateTime common.JsonTime `json:"createTime" DB_COL:"create_time"`
+	CreateBy   string          `json:"createBy" DB_COL:"create_by"`
+	UpdateTime common.JsonTime `json:"updateTime" DB_COL:"update_time"`
+}
+
+type WebhookDao struct {
+	common.Dao
+}
+
+func NewWebhookDao(db *common.DB) *WebhookDao {
+	webhookDao := WebhookDao{common.NewDao(db.DB, db.Dialect)}
+	return &webhookDao
+}
+
+func (_self *WebhookDao) QueryWebhooks(ctx context.Context, appId int64) ([]*WebhookData, error) {
+	sql := "SELECT * FROM `webhook` WHERE `app_id` = ? ORDER BY `webhook_id`"
+
+	webhooks := make([]*WebhookData, 0)
+	err := _self.StructSelect(ctx, &webhooks, sql, appId)
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (_self *WebhookDao) QueryWebhook(ctx context.Context, appId, webhookId int64) (*WebhookData, error) {
+	webhook := WebhookData{}
+	err := _self.StructSelectByPK(ctx, &webhook, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.AppId != appId {
+		return nil, common.NotFound("webhook")
+	}
+	return &webhook, nil
+}
+
+func (_self *WebhookDao) InsertWebhook(ctx context.Context, data *WebhookData) (int64, error) {
+	return _self.StructInsert(ctx, data, false)
+}
+
+// SelectedUpdateWebhook updates the fields of webhook, an empty secret among them clears it.
+func (_self *WebhookDao) SelectedUpdateWebhook(ctx context.Context, data WebhookData, fields common.Fields) (int64, error) {
+	sql, values := _self.prepareSelectedUpdate(data, fields)
+	return _self.Exec(ctx, sql, values...)
+}
+
+func (_self *WebhookDao) DeleteWebhook(ctx context.Context, appId, webhookId int64) (int64, error) {
+	sql := "DELETE FROM `webhook` WHERE `app_id` = ? AND `webhook_id` = ?"
+	return _self.Exec(ctx, sql, appId, webhookId)
+}
+
+func (_self *WebhookDao) DeleteWebhooks(ctx context.Context, appId int64) (int64, error) {
+	sql := "DELETE FROM `webhook` WHERE `app_id` = ?"
+	return _self.Exec(ctx, sql, appId)
+}
+
+func (_self *WebhookDao) prepareSelectedUpdate(data WebhookData, fields common.Fields) (string, []interface{}) {
+	buffer := bytes.Buffer{}
+	buffer.WriteString("UPDATE `webhook` SET ")
+
+	values := make([]interface{}, 0)
+	if fields.Has("url") {
+		values = append(values, data.Url)
+		buffer.WriteString("`url` = ?,")
+	}
+	if fields.Has("secret") {
+		values = append(values, data.Secret)
+		buffer.WriteString("`secret` = ?,")
+	}
+	if fields.Has("events") {
+		values = append(values, data.Events)
+		buffer.WriteString("`events` = ?,")
+	}
+	if fields.Has("status") {
+		values = append(values, data.Status)
+		buffer.WriteString("`status` = ?,")
+	}
+	if fields.Has("updateTime") {
+		values = append(values, data.UpdateTime)
+		buffer.WriteString("`update_time` = ?,")
+	}
+
+	sql := strings.TrimSuffix(buffer.String(), ",") + " WHERE `app_id` = ? AND `webhook_id` = ?"
+	values = append(values, data.AppId, data.WebhookId)
+
+	return sql, values
+}
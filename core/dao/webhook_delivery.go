@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"varconf-server/core/dao/common"
+)
+
+const (
+	// 1-投递中、2-成功、3-失败
+	DELIVERY_PENDING = 1
+	DELIVERY_SUCCEED = 2
+	DELIVERY_FAILED  = 3
+)
+
+// Webhook投递记录
+type WebhookDeliveryData struct {
+	DeliveryId   int64           `json:"deliveryId" DB_COL:"delivery_id" DB_PK:"delivery_id" DB_TABLE:"webhook_delivery"`
+	WebhookId    int64           `json:"webhookId" DB_COL:"webhook_id"`
+	AppId        int64           `json:"appId" DB_COL:"app_id"`
+	Event        string          `json:"event" DB_COL:"event"`
+	Payload      string          `json:"payload" DB_COL:"payload"`
+	Status       int             `json:"status" DB_COL:"status"`
+	Attempts     int             `json:"attempts" DB_COL:"attempts"`
+	ResponseCode int             `json:"responseCode" DB_COL:"response_code"`
+	Error        string          `json:"error" DB_COL:"error"`
+	ClaimTime    common.JsonTime `json:"-" DB_COL:"claim_time"`
+	CreateTime   common.JsonTime `json:"createTime" DB_COL:"create_time"`
+	UpdateTime   common.JsonTime `json:"updateTime" DB_COL:"update_time"`
+}
+
+type QueryWebhookDeliveryData struct {
+	DeliveryId int64
+	WebhookId  int64
+	Start      int64
+	End        int64
+}
+
+type WebhookDeliveryDao struct {
+	common.Dao
+}
+
+func NewWebhookDeliveryDao(db *common.DB) *WebhookDeliveryDao {
+	webhookDeliveryDao := WebhookDeliveryDao{common.NewDao(db.DB, db.Dialect)}
+	return &webhookDeliveryDao
+}
+
+func (_self *WebhookDeliveryDao) QueryWebhookDeliveries(ctx context.Context, query QueryWebhookDeliveryData) ([]*WebhookDeliveryData, error) {
+	values := make([]interface{}, 0)
+	sql := "SELECT * FROM `webhook_delivery` WHERE `webhook_id` = ?"
+	values = append(values, query.WebhookId)
+	if query.DeliveryId != 0 {
+		sql = sql + " AND `delivery_id` = ?"
+		values = append(values, query.DeliveryId)
+	}
+	sql = sql + " ORDER BY `delivery_id` DESC"
+	if query.Start >= 0 && query.End > 0 {
+		limit, limitValues := _self.Limit(query.Start, query.End)
+		sql = sql + limit
+		values = append(values, limitValues...)
+	}
+
+	deliveries := make([]*WebhookDeliveryData, 0)
+	err := _self.StructSelect(ctx, &deliveries, sql, values...)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (_self *WebhookDeliveryDao) CountWebhookDeliveries(ctx context.Context, webhookId int64) (int64, error) {
+	sql := "SELECT COUNT(1) FROM `webhook_delivery` WHERE `webhook_id` = ?"
+	return _self.Count(ctx, sql, webhookId)
+}
+
+func (_self *WebhookDeliveryDao) InsertWebhookDelivery(ctx context.Context, data *WebhookDeliveryData) (int64, error) {
+	return _self.StructInsert(ctx, data, false)
+}
+
+func (_self *WebhookDeliveryDao) UpdateWebhookDelivery(ctx context.Context, data *WebhookDeliveryData) (int64, error) {
+	sql := "UPDATE `webhook_delivery` SET `status` = ?, `attempts` = ?, `response_code` = ?, `error` = ?, `update_time` = ? WHERE `delivery_id` = ?"
+	return _self.Exec(ctx, sql, data.Status, data.Attempts, data.ResponseCode, data.Error, data.UpdateTime, data.DeliveryId)
+}
+
+// QueryPendingDeliveries returns the pending deliveries unclaimed since before, oldest first.
+func (_self *WebhookDeliveryDao) QueryPendingDeliveries(ctx context.Context, before time.Time, limit int64) ([]*WebhookDeliveryData, error) {
+	limitSql, limitValues := _self.Limit(0, limit)
+	sql := "SELECT * FROM `webhook_delivery` WHERE `status` = ? AND `claim_time` < ? ORDER BY `delivery_id`" + limitSql
+
+	deliveries := make([]*WebhookDeliveryData, 0)
+	err := _self.StructSelect(ctx, &deliveries, sql, append([]interface{}{DELIVERY_PENDING, before}, limitValues...)...)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimWebhookDelivery claims a pending delivery unclaimed since before, no row
+// is updated when another node claimed it first.
+func (_self *WebhookDeliveryDao) ClaimWebhookDelivery(ctx context.Context, deliveryId int64, before, now time.Time) (int64, error) {
+	sql := "UPDATE `webhook_delivery` SET `claim_time` = ? WHERE `delivery_id` = ? AND `status` = ? AND `claim_time` < ?"
+	return _self.Exec(ctx, sql, now, deliveryId, DELIVERY_PENDING, before)
+}
+
+// AttemptWebhookDelivery counts an attempt of a pending delivery tried attempts
+// times and claims it again, no row is updated when the attempt was taken
+// already by a copy of the delivery queued twice.
+func (_self *WebhookDeliveryDao) AttemptWebhookDelivery(ctx context.Context, deliveryId int64, attempts int, now time.Time) (int64, error) {
+	sql := "UPDATE `webhook_delivery` SET `attempts` = `attempts` + 1, `claim_time` = ? WHERE `delivery_id` = ? AND `status` = ? AND `attempts` = ?"
+	return _self.Exec(ctx, sql, now, deliveryId, DELIVERY_PENDING, attempts)
+}
+
+func (_self *WebhookDeliveryDao) DeleteWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	sql := "DELETE FROM `webhook_delivery` WHERE `create_time` < ?"
+	return _self.Exec(ctx, sql, before)
+}
@@ -0,0 +1,69 @@
+// bus
+package bus
+
+import (
+	"sync"
+)
+
+const (
+	LOCAL = "local"
+	DB    = "db"
+)
+
+type Event struct {
+	AppId        int64    `json:"appId"`
+	Keys         []string `json:"keys"`
+	ReleaseIndex int      `json:"releaseIndex"`
+}
+
+type Handler func(event *Event)
+
+// Bus fans release events out to every server node, so each node can wake
+// its own long-poll waiters.
+type Bus interface {
+	Publish(event *Event) error
+	Subscribe(handler Handler)
+	Start() error
+	Stop()
+}
+
+// LocalBus only delivers events inside the current process.
+type LocalBus struct {
+	lock     sync.RWMutex
+	handlers []Handler
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{
+		handlers: make([]Handler, 0),
+	}
+}
+
+func (_self *LocalBus) Publish(event *Event) error {
+	// the publisher has already handled its own event
+	return nil
+}
+
+func (_self *LocalBus) Subscribe(handler Handler) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	_self.handlers = append(_self.handlers, handler)
+}
+
+func (_self *LocalBus) Start() error {
+	return nil
+}
+
+func (_self *LocalBus) Stop() {
+}
+
+func (_self *LocalBus) dispatch(event *Event) {
+	_self.lock.RLock()
+	handlers := _self.handlers
+	_self.lock.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,178 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/logger"
+)
+
+const (
+	// an id skipped by the tail is looked for that long, it's either
+	// committed late or rolled back
+	gapTimeout = time.Minute
+	maxGaps    = 1000
+)
+
+// DbBus shares events through the release_event table, which every node
+// tails on a short interval.
+type DbBus struct {
+	LocalBus
+
+	node            string
+	interval        time.Duration
+	retention       time.Duration
+	releaseEventDao *dao.ReleaseEventDao
+	logger          *logger.Logger
+	lastId          int64
+	gaps            map[int64]time.Time
+	stopOnce        sync.Once
+	stopChan        chan struct{}
+}
+
+func NewDbBus(db *common.DB, interval time.Duration) *DbBus {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &DbBus{
+		LocalBus:        LocalBus{handlers: make([]Handler, 0)},
+		node:            uuid.New().String(),
+		interval:        interval,
+		retention:       10 * time.Minute,
+		releaseEventDao: dao.NewReleaseEventDao(db),
+		logger:          logger.Default(),
+		gaps:            make(map[int64]time.Time),
+		stopChan:        make(chan struct{}),
+	}
+}
+
+func (_self *DbBus) Publish(event *Event) error {
+	keyList, err := json.Marshal(event.Keys)
+	if err != nil {
+		return err
+	}
+
+	_, err = _self.releaseEventDao.InsertReleaseEvent(context.Background(), &dao.ReleaseEventData{
+		AppId:        event.AppId,
+		KeyList:      string(keyList),
+		ReleaseIndex: event.ReleaseIndex,
+		Node:         _self.node,
+		CreateTime:   common.NowJsonTime(),
+	})
+	return err
+}
+
+// Start tails the events published after it, while the database can't be
+// reached the tail starts from the events there once it answers.
+func (_self *DbBus) Start() error {
+	lastId, err := _self.releaseEventDao.MaxReleaseEventId(context.Background())
+	if err != nil {
+		_self.logger.Warn("bus: query last release event error", "error", err)
+		lastId = -1
+	}
+	_self.lastId = lastId
+
+	go _self.loop()
+	return nil
+}
+
+func (_self *DbBus) Stop() {
+	_self.stopOnce.Do(func() {
+		close(_self.stopChan)
+	})
+}
+
+func (_self *DbBus) loop() {
+	ticker := time.NewTicker(_self.interval)
+	defer ticker.Stop()
+
+	lastClean := time.Now()
+	for {
+		select {
+		case <-_self.stopChan:
+			return
+
+		case <-ticker.C:
+			_self.tail()
+			if time.Since(lastClean) > _self.retention {
+				_self.clean()
+				lastClean = time.Now()
+			}
+		}
+	}
+}
+
+func (_self *DbBus) tail() {
+	defer func() {
+		if err := recover(); err != nil {
+			_self.logger.Error("bus: tail release events error", "error", err)
+		}
+	}()
+
+	// started without the database, the missed releases are caught up by
+	// the release cron
+	if _self.lastId < 0 {
+		lastId, err := _self.releaseEventDao.MaxReleaseEventId(context.Background())
+		if err != nil {
+			_self.logger.Error("bus: query last release event error", "error", err)
+			return
+		}
+		_self.lastId = lastId
+		return
+	}
+
+	// ids are taken before commit, a lower one may show up after a higher
+	// one so the tail restarts from the oldest gap
+	now := time.Now()
+	afterId := _self.lastId
+	for id, since := range _self.gaps {
+		if now.Sub(since) > gapTimeout {
+			delete(_self.gaps, id)
+		} else if id <= afterId {
+			afterId = id - 1
+		}
+	}
+
+	releaseEvents, err := _self.releaseEventDao.QueryReleaseEvents(context.Background(), afterId)
+	if err != nil {
+		_self.logger.Error("bus: tail release events error", "error", err)
+		return
+	}
+	for _, releaseEvent := range releaseEvents {
+		if releaseEvent.Id <= _self.lastId {
+			if _, ok := _self.gaps[releaseEvent.Id]; !ok {
+				continue
+			}
+			delete(_self.gaps, releaseEvent.Id)
+		} else {
+			// on a table empty at start nothing is known below the first id
+			for id := _self.lastId + 1; _self.lastId > 0 && id < releaseEvent.Id && len(_self.gaps) < maxGaps; id++ {
+				_self.gaps[id] = now
+			}
+			_self.lastId = releaseEvent.Id
+		}
+
+		// local waiters were woken by the publisher itself
+		if releaseEvent.Node == _self.node {
+			continue
+		}
+
+		keys := make([]string, 0)
+		if err := json.Unmarshal([]byte(releaseEvent.KeyList), &keys); err != nil {
+			_self.logger.Error("bus: parse release event error", "error", err)
+		}
+		_self.dispatch(&Event{AppId: releaseEvent.AppId, Keys: keys, ReleaseIndex: releaseEvent.ReleaseIndex})
+	}
+}
+
+func (_self *DbBus) clean() {
+	_, err := _self.releaseEventDao.DeleteReleaseEvents(context.Background(), time.Now().Add(-_self.retention))
+	if err != nil {
+		_self.logger.Error("bus: clean release events error", "error", err)
+	}
+}
@@ -0,0 +1,80 @@
+package bus
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestDbBusLateCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "varconf-bus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := dao.OpenStorage("sqlite", "file:"+filepath.Join(dir, "bus.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = dao.NewSchemaDao(db).MigrateUp(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	dbBus := NewDbBus(db, time.Second)
+	if err = dbBus.Start(); err != nil {
+		t.Fatal(err)
+	}
+	dbBus.Stop()
+
+	appIds := make([]int64, 0)
+	dbBus.Subscribe(func(event *Event) {
+		appIds = append(appIds, event.AppId)
+	})
+	insert := func(id int64) {
+		_, err := db.Exec("INSERT INTO release_event (id, app_id, key_list, release_index, node, create_time) VALUES (?, ?, '[]', 1, 'other', ?)",
+			id, id, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want ...int64) {
+		t.Helper()
+		dbBus.tail()
+		if len(appIds) != len(want) {
+			t.Fatalf("dispatched %v, want %v", appIds, want)
+		}
+		for i := range want {
+			if appIds[i] != want[i] {
+				t.Fatalf("dispatched %v, want %v", appIds, want)
+			}
+		}
+		appIds = appIds[:0]
+	}
+
+	insert(1)
+	expect(1)
+
+	// 2 commits after 3
+	insert(3)
+	expect(3)
+	insert(2)
+	expect(2)
+	expect()
+
+	// a gap never filled is given up
+	insert(5)
+	expect(5)
+	dbBus.gaps[4] = time.Now().Add(-2 * gapTimeout)
+	expect()
+	if len(dbBus.gaps) != 0 {
+		t.Fatalf("gaps %v left", dbBus.gaps)
+	}
+}
@@ -0,0 +1,183 @@
+// cache
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Size      int   `json:"size"`
+	Capacity  int   `json:"capacity"`
+}
+
+type item struct {
+	key    interface{}
+	value  interface{}
+	expire time.Time
+}
+
+type call struct {
+	done  chan struct{}
+	value interface{}
+	ok    bool
+	stale bool
+}
+
+// LruCache is a size bounded cache which evicts the least recently used item,
+// and collapses concurrent loads of the same key into one.
+type LruCache struct {
+	lock      sync.Mutex
+	capacity  int
+	ttl       time.Duration
+	itemList  *list.List
+	itemMap   map[interface{}]*list.Element
+	callMap   map[interface{}]*call
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func NewLruCache(capacity int, ttl time.Duration) *LruCache {
+	if capacity <= 0 {
+		capacity = 1000
+	}
+	return &LruCache{
+		capacity: capacity,
+		ttl:      ttl,
+		itemList: list.New(),
+		itemMap:  make(map[interface{}]*list.Element),
+		callMap:  make(map[interface{}]*call),
+	}
+}
+
+func (_self *LruCache) Get(key interface{}) (interface{}, bool) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	value, ok := _self.get(key)
+	if ok {
+		_self.hits++
+	} else {
+		_self.misses++
+	}
+	return value, ok
+}
+
+func (_self *LruCache) Put(key, value interface{}) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	_self.put(key, value)
+}
+
+// Load returns the cached value of key, or calls loader once for all the
+// concurrent callers asking for the same missing key. The loader runs on its
+// own, a caller whose ctx is done stops waiting with the error of ctx and the
+// others still get the value.
+func (_self *LruCache) Load(ctx context.Context, key interface{}, loader func() (interface{}, bool)) (interface{}, bool, error) {
+	_self.lock.Lock()
+	if value, ok := _self.get(key); ok {
+		_self.hits++
+		_self.lock.Unlock()
+		return value, true, nil
+	}
+	_self.misses++
+	c, exist := _self.callMap[key]
+	if !exist {
+		c = &call{done: make(chan struct{})}
+		_self.callMap[key] = c
+		go _self.load(key, c, loader)
+	}
+	_self.lock.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.ok, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// Remove drops key, and keeps the value of a load still in flight from being cached.
+func (_self *LruCache) Remove(key interface{}) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	if element, exist := _self.itemMap[key]; exist {
+		_self.itemList.Remove(element)
+		delete(_self.itemMap, key)
+	}
+	if c, exist := _self.callMap[key]; exist {
+		c.stale = true
+		delete(_self.callMap, key)
+	}
+}
+
+func (_self *LruCache) Stats() Stats {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	return Stats{
+		Hits:      _self.hits,
+		Misses:    _self.misses,
+		Evictions: _self.evictions,
+		Size:      _self.itemList.Len(),
+		Capacity:  _self.capacity,
+	}
+}
+
+func (_self *LruCache) load(key interface{}, c *call, loader func() (interface{}, bool)) {
+	value, ok := loader()
+
+	_self.lock.Lock()
+	c.value, c.ok = value, ok
+	if _self.callMap[key] == c {
+		delete(_self.callMap, key)
+	}
+	if ok && !c.stale {
+		_self.put(key, value)
+	}
+	_self.lock.Unlock()
+	close(c.done)
+}
+
+func (_self *LruCache) get(key interface{}) (interface{}, bool) {
+	element, exist := _self.itemMap[key]
+	if !exist {
+		return nil, false
+	}
+
+	cacheItem := element.Value.(*item)
+	if _self.ttl > 0 && time.Now().After(cacheItem.expire) {
+		_self.itemList.Remove(element)
+		delete(_self.itemMap, key)
+		return nil, false
+	}
+	_self.itemList.MoveToFront(element)
+	return cacheItem.value, true
+}
+
+func (_self *LruCache) put(key, value interface{}) {
+	expire := time.Now().Add(_self.ttl)
+	if element, exist := _self.itemMap[key]; exist {
+		cacheItem := element.Value.(*item)
+		cacheItem.value = value
+		cacheItem.expire = expire
+		_self.itemList.MoveToFront(element)
+		return
+	}
+
+	_self.itemMap[key] = _self.itemList.PushFront(&item{key: key, value: value, expire: expire})
+	for _self.itemList.Len() > _self.capacity {
+		element := _self.itemList.Back()
+		_self.itemList.Remove(element)
+		delete(_self.itemMap, element.Value.(*item).key)
+		_self.evictions++
+	}
+}
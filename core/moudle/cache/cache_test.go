@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvict(t *testing.T) {
+	lruCache := NewLruCache(2, time.Minute)
+	lruCache.Put("a", 1)
+	lruCache.Put("b", 2)
+	// a is used last, b goes first
+	lruCache.Get("a")
+	lruCache.Put("c", 3)
+
+	if _, ok := lruCache.Get("b"); ok {
+		t.Fatal("least recently used b wasn't evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := lruCache.Get(key); !ok {
+			t.Fatalf("%s was evicted", key)
+		}
+	}
+	if stats := lruCache.Stats(); stats.Evictions != 1 || stats.Size != 2 {
+		t.Fatalf("got %d evictions at size %d, want 1 at 2", stats.Evictions, stats.Size)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	lruCache := NewLruCache(2, 20*time.Millisecond)
+	lruCache.Put("a", 1)
+	if value, ok := lruCache.Get("a"); !ok || value != 1 {
+		t.Fatalf("got %v, want 1", value)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := lruCache.Get("a"); ok {
+		t.Fatal("expired a is still cached")
+	}
+	if stats := lruCache.Stats(); stats.Size != 0 {
+		t.Fatalf("expired a is still held, size %d", stats.Size)
+	}
+}
+
+func TestLoadShared(t *testing.T) {
+	lruCache := NewLruCache(2, time.Minute)
+	var loads int32
+	release := make(chan struct{})
+	loader := func() (interface{}, bool) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return "v", true
+	}
+
+	// the first caller gives up while the load is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, _, err := lruCache.Load(ctx, "a", loader)
+		canceled <- err
+	}()
+	for atomic.LoadInt32(&loads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var wait sync.WaitGroup
+	values := make(chan interface{}, 4)
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			value, ok, err := lruCache.Load(context.Background(), "a", loader)
+			if !ok || err != nil {
+				t.Errorf("load failed, %v", err)
+			}
+			values <- value
+		}()
+	}
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Fatalf("canceled caller got %v", err)
+	}
+
+	close(release)
+	wait.Wait()
+	close(values)
+	for value := range values {
+		if value != "v" {
+			t.Fatalf("got %v, want v", value)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("loaded %d times, want 1", loads)
+	}
+	if value, ok := lruCache.Get("a"); !ok || value != "v" {
+		t.Fatal("shared load wasn't cached")
+	}
+}
+
+func TestRemoveLoading(t *testing.T) {
+	lruCache := NewLruCache(2, time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan interface{})
+	go func() {
+		value, _, _ := lruCache.Load(context.Background(), "a", func() (interface{}, bool) {
+			close(started)
+			<-release
+			return "old", true
+		})
+		done <- value
+	}()
+	<-started
+
+	// the value loaded before the removal is handed out but not cached
+	lruCache.Remove("a")
+	close(release)
+	if value := <-done; value != "old" {
+		t.Fatalf("got %v, want old", value)
+	}
+	if _, ok := lruCache.Get("a"); ok {
+		t.Fatal("load removed in flight was cached")
+	}
+
+	// the next load isn't joined to the stale one
+	value, ok, err := lruCache.Load(context.Background(), "a", func() (interface{}, bool) {
+		return "new", true
+	})
+	if !ok || err != nil || value != "new" {
+		t.Fatalf("got %v, %v", value, err)
+	}
+}
@@ -0,0 +1,171 @@
+// git
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Signature is the author of a commit.
+type Signature struct {
+	Name  string
+	Email string
+}
+
+// Repo is a working copy of one remote repository, driven through the git
+// command line. It isn't safe for concurrent use.
+type Repo struct {
+	Dir     string
+	Timeout time.Duration
+}
+
+// Open returns the working copy in dir tracking url as origin, it's created
+// on first use.
+func Open(dir, url string) (*Repo, error) {
+	if url == "" || strings.HasPrefix(url, "-") {
+		return nil, fmt.Errorf("git: bad repository %q", url)
+	}
+	repo := &Repo{Dir: dir, Timeout: time.Minute}
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if _, err = repo.run("init", "-q"); err != nil {
+			return nil, err
+		}
+		if _, err = repo.run("remote", "add", "origin", url); err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+	if _, err := repo.run("remote", "set-url", "origin", url); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// Head returns the commit at the top of branch in origin, "" when the branch
+// doesn't exist yet.
+func (_self *Repo) Head(branch string) (string, error) {
+	out, err := _self.run("ls-remote", "origin", "refs/heads/"+branch)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
+}
+
+// Checkout fetches commit of branch and resets the working copy to it, an
+// empty commit leaves an unborn branch with nothing in it.
+func (_self *Repo) Checkout(branch, commit string) error {
+	if commit == "" {
+		if _, err := _self.run("symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+			return err
+		}
+		_self.run("update-ref", "-d", "refs/heads/"+branch)
+		if _, err := _self.run("read-tree", "--empty"); err != nil {
+			return err
+		}
+		_, err := _self.run("clean", "-q", "-f", "-d", "-x")
+		return err
+	}
+
+	if _, err := _self.run("fetch", "-q", "origin", "+refs/heads/"+branch+":refs/remotes/origin/"+branch); err != nil {
+		return err
+	}
+	if _, err := _self.run("checkout", "-q", "-f", "-B", branch, commit); err != nil {
+		return err
+	}
+	_, err := _self.run("clean", "-q", "-f", "-d", "-x")
+	return err
+}
+
+// Show returns file as of commit, ok is false when it doesn't exist there.
+func (_self *Repo) Show(commit, file string) (content []byte, ok bool, err error) {
+	if _, err = _self.run("cat-file", "-e", commit+":"+filepath.ToSlash(file)); err != nil {
+		return nil, false, nil
+	}
+	out, err := _self.run("show", commit+":"+filepath.ToSlash(file))
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(out), true, nil
+}
+
+// Author returns the author name of commit.
+func (_self *Repo) Author(commit string) (string, error) {
+	out, err := _self.run("log", "-1", "--format=%an", commit)
+	return strings.TrimSpace(out), err
+}
+
+// CommitFile writes file into the working copy and commits it on the checked
+// out branch, changed is false when the file was already up to date.
+func (_self *Repo) CommitFile(file string, content []byte, author Signature, message string) (commit string, changed bool, err error) {
+	path := filepath.Join(_self.Dir, file)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", false, err
+	}
+	if err = ioutil.WriteFile(path, content, 0644); err != nil {
+		return "", false, err
+	}
+	if _, err = _self.run("add", "--", file); err != nil {
+		return "", false, err
+	}
+	if _, err = _self.run("diff", "--cached", "--quiet"); err == nil {
+		return "", false, nil
+	}
+
+	_, err = _self.run("commit", "-q", "--author", fmt.Sprintf("%s <%s>", author.Name, author.Email), "-m", message)
+	if err != nil {
+		return "", false, err
+	}
+	out, err := _self.run("rev-parse", "HEAD")
+	return strings.TrimSpace(out), true, err
+}
+
+// Push pushes the checked out branch to origin, it fails when origin moved
+// since the checkout.
+func (_self *Repo) Push(branch string) error {
+	_, err := _self.run("push", "-q", "origin", "HEAD:refs/heads/"+branch)
+	return err
+}
+
+func (_self *Repo) run(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _self.Timeout)
+	defer cancel()
+
+	// the committer is the server, ext:: would let the url run commands
+	name := args[0]
+	args = append([]string{"-c", "user.name=varconf", "-c", "user.email=varconf@localhost",
+		"-c", "protocol.ext.allow=never"}, args...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = _self.Dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("git %s: %v", name, ctx.Err())
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return stdout.String(), fmt.Errorf("git %s: %s", name, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), fmt.Errorf("git %s: %v", name, err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,223 @@
+// logger
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+const (
+	JSON   = "json"
+	LOGFMT = "logfmt"
+)
+
+func (_self Level) String() string {
+	switch _self {
+	case DEBUG:
+		return "debug"
+	case INFO:
+		return "info"
+	case WARN:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+func ParseLevel(level string) Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return DEBUG
+	case "warn", "warning":
+		return WARN
+	case "error":
+		return ERROR
+	default:
+		return INFO
+	}
+}
+
+type output struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+// Logger writes leveled lines of key value fields, as json or logfmt.
+type Logger struct {
+	level  Level
+	format string
+	fields []interface{}
+	output *output
+}
+
+func New(writer io.Writer, level Level, format string) *Logger {
+	if format != LOGFMT {
+		format = JSON
+	}
+	return &Logger{level: level, format: format, output: &output{writer: writer}}
+}
+
+// With returns a logger adding the key value pairs to every line.
+func (_self *Logger) With(fields ...interface{}) *Logger {
+	logger := *_self
+	logger.fields = append(append(make([]interface{}, 0, len(_self.fields)+len(fields)), _self.fields...), fields...)
+	return &logger
+}
+
+func (_self *Logger) Enabled(level Level) bool {
+	return level >= _self.level
+}
+
+func (_self *Logger) Debug(msg string, fields ...interface{}) {
+	_self.Log(DEBUG, msg, fields...)
+}
+
+func (_self *Logger) Info(msg string, fields ...interface{}) {
+	_self.Log(INFO, msg, fields...)
+}
+
+func (_self *Logger) Warn(msg string, fields ...interface{}) {
+	_self.Log(WARN, msg, fields...)
+}
+
+func (_self *Logger) Error(msg string, fields ...interface{}) {
+	_self.Log(ERROR, msg, fields...)
+}
+
+func (_self *Logger) Log(level Level, msg string, fields ...interface{}) {
+	if !_self.Enabled(level) {
+		return
+	}
+
+	all := make([]interface{}, 0, 6+len(_self.fields)+len(fields))
+	all = append(all, "time", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), "level", level.String(), "msg", msg)
+	all = append(all, _self.fields...)
+	all = append(all, fields...)
+
+	var line []byte
+	if _self.format == LOGFMT {
+		line = encodeLogfmt(all)
+	} else {
+		line = encodeJson(all)
+	}
+
+	_self.output.lock.Lock()
+	defer _self.output.lock.Unlock()
+	_self.output.writer.Write(line)
+}
+
+func encodeJson(fields []interface{}) []byte {
+	buffer := bytes.Buffer{}
+	buffer.WriteString("{")
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buffer.Write(key)
+		buffer.WriteString(":")
+		buffer.Write(jsonValue(fieldValue(fields, i+1)))
+	}
+	buffer.WriteString("}\n")
+	return buffer.Bytes()
+}
+
+func jsonValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		content, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return content
+}
+
+func encodeLogfmt(fields []interface{}) []byte {
+	buffer := bytes.Buffer{}
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buffer.WriteString(" ")
+		}
+		buffer.WriteString(strings.Replace(fmt.Sprint(fields[i]), " ", "_", -1))
+		buffer.WriteString("=")
+		buffer.WriteString(logfmtValue(fieldValue(fields, i+1)))
+	}
+	buffer.WriteString("\n")
+	return buffer.Bytes()
+}
+
+func logfmtValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if value == nil {
+		text = ""
+	}
+	if text == "" || strings.ContainsAny(text, " =\"\t\r\n") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+func fieldValue(fields []interface{}, i int) interface{} {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return "(MISSING)"
+}
+
+var (
+	defaultLock   sync.RWMutex
+	defaultLogger = New(os.Stdout, INFO, JSON)
+)
+
+// Default is the logger used by the package level functions.
+func Default() *Logger {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+
+	return defaultLogger
+}
+
+func SetDefault(logger *Logger) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+
+	defaultLogger = logger
+}
+
+func Debug(msg string, fields ...interface{}) {
+	Default().Log(DEBUG, msg, fields...)
+}
+
+func Info(msg string, fields ...interface{}) {
+	Default().Log(INFO, msg, fields...)
+}
+
+func Warn(msg string, fields ...interface{}) {
+	Default().Log(WARN, msg, fields...)
+}
+
+func Error(msg string, fields ...interface{}) {
+	Default().Log(ERROR, msg, fields...)
+}
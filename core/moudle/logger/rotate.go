@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotateWriter appends to a file and moves it aside once it grows over
+// maxSize, keeping at most maxBackups of the moved files.
+type RotateWriter struct {
+	lock       sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func NewRotateWriter(path string, maxSize int64, maxBackups int) (*RotateWriter, error) {
+	writer := &RotateWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (_self *RotateWriter) Write(data []byte) (int, error) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	if _self.maxSize > 0 && _self.size+int64(len(data)) > _self.maxSize && _self.size > 0 {
+		if err := _self.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := _self.file.Write(data)
+	_self.size += int64(n)
+	return n, err
+}
+
+func (_self *RotateWriter) Close() error {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	return _self.file.Close()
+}
+
+func (_self *RotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(_self.path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(_self.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	_self.file = file
+	_self.size = info.Size()
+	return nil
+}
+
+func (_self *RotateWriter) rotate() error {
+	_self.file.Close()
+	backup := _self.path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(_self.path, backup); err != nil {
+		return err
+	}
+	if err := _self.open(); err != nil {
+		return err
+	}
+
+	// drop the oldest backups
+	if _self.maxBackups > 0 {
+		backups, _ := filepath.Glob(_self.path + ".*")
+		backups = _self.filterBackups(backups)
+		sort.Strings(backups)
+		for len(backups) > _self.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+func (_self *RotateWriter) filterBackups(paths []string) []string {
+	backups := make([]string, 0, len(paths))
+	for _, path := range paths {
+		suffix := strings.TrimPrefix(path, _self.path+".")
+		if _, err := time.Parse("20060102-150405.000", suffix); err == nil {
+			backups = append(backups, path)
+		}
+	}
+	return backups
+}
@@ -0,0 +1,126 @@
+// mail
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Sender delivers plain text messages through one SMTP server.
+type Sender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// TLS dials the server over TLS directly (usually port 465), otherwise
+	// STARTTLS is used when the server offers it.
+	TLS     bool
+	Timeout time.Duration
+}
+
+func (_self *Sender) Send(message *Message) error {
+	if len(message.To) == 0 {
+		return errors.New("mail: no recipient")
+	}
+
+	client, err := _self.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if !_self.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(&tls.Config{ServerName: _self.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if _self.Username != "" {
+		// never fall back to sending unauthenticated
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("mail: server doesn't support AUTH")
+		}
+		if err = client.Auth(smtp.PlainAuth("", _self.Username, _self.Password, _self.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(_self.From); err != nil {
+		return err
+	}
+	for _, to := range message.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = writer.Write(_self.encode(message)); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (_self *Sender) dial() (*smtp.Client, error) {
+	timeout := _self.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	address := net.JoinHostPort(_self.Host, strconv.Itoa(_self.Port))
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if _self.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: _self.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(2 * timeout))
+
+	client, err := smtp.NewClient(conn, _self.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (_self *Sender) encode(message *Message) []byte {
+	buffer := bytes.Buffer{}
+	fmt.Fprintf(&buffer, "From: %s\r\n", _self.From)
+	fmt.Fprintf(&buffer, "To: %s\r\n", strings.Join(message.To, ", "))
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+
+	// the data writer takes care of line endings and dot-stuffing
+	buffer.WriteString(message.Body)
+	return buffer.Bytes()
+}
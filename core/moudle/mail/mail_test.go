@@ -0,0 +1,196 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer is an SMTP server answering one session, it records the commands
+// and the message data it receives.
+type fakeServer struct {
+	listener net.Listener
+	auth     bool
+	commands []string
+	data     string
+	done     chan struct{}
+}
+
+func newFakeServer(t *testing.T, auth bool) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{listener: listener, auth: auth, done: make(chan struct{})}
+	go server.serve()
+	return server
+}
+
+func (_self *fakeServer) serve() {
+	defer close(_self.done)
+	conn, err := _self.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			conn.Write([]byte(line + "\r\n"))
+		}
+	}
+	reply("220 fake ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		_self.commands = append(_self.commands, command)
+
+		verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			if _self.auth {
+				reply("250-fake", "250 AUTH PLAIN")
+			} else {
+				reply("250 fake")
+			}
+		case "AUTH":
+			reply("235 2.7.0 accepted")
+		case "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data := strings.Builder{}
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			_self.data = data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+func (_self *fakeServer) sender() *Sender {
+	address := _self.listener.Addr().(*net.TCPAddr)
+	return &Sender{Host: "127.0.0.1", Port: address.Port, From: "varconf@example.com", Timeout: time.Second}
+}
+
+// wait returns once the session is over, the recorded fields are safe to read then.
+func (_self *fakeServer) wait(t *testing.T) {
+	t.Helper()
+	_self.listener.Close()
+	select {
+	case <-_self.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session didn't end")
+	}
+}
+
+func (_self *fakeServer) command(verb string) string {
+	for _, command := range _self.commands {
+		if strings.HasPrefix(strings.ToUpper(command), verb) {
+			return command
+		}
+	}
+	return ""
+}
+
+func TestSend(t *testing.T) {
+	server := newFakeServer(t, false)
+	message := &Message{
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "发布 #1",
+		Body:    "line one\n.starts with a dot\n",
+	}
+	if err := server.sender().Send(message); err != nil {
+		t.Fatal(err)
+	}
+	server.wait(t)
+
+	if command := server.command("MAIL FROM"); command != "MAIL FROM:<varconf@example.com>" {
+		t.Fatalf("got %q", command)
+	}
+	rcpts := 0
+	for _, command := range server.commands {
+		if strings.HasPrefix(command, "RCPT TO") {
+			rcpts++
+		}
+	}
+	if rcpts != 2 {
+		t.Fatalf("got %d recipients, want 2", rcpts)
+	}
+	if server.command("AUTH") != "" {
+		t.Fatal("authenticated without a username")
+	}
+
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: =?utf-8?q?",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nline one\r\n..starts with a dot\r\n",
+	} {
+		if !strings.Contains(server.data, want) {
+			t.Fatalf("data %q lacks %q", server.data, want)
+		}
+	}
+}
+
+func TestSendAuth(t *testing.T) {
+	server := newFakeServer(t, true)
+	sender := server.sender()
+	sender.Username, sender.Password = "user", "pass"
+	if err := sender.Send(&Message{To: []string{"a@example.com"}, Subject: "s", Body: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	server.wait(t)
+
+	fields := strings.Fields(server.command("AUTH"))
+	if len(fields) != 3 || fields[1] != "PLAIN" {
+		t.Fatalf("got auth %q", server.command("AUTH"))
+	}
+	credentials, _ := base64.StdEncoding.DecodeString(fields[2])
+	if string(credentials) != "\x00user\x00pass" {
+		t.Fatalf("got credentials %q", credentials)
+	}
+}
+
+func TestSendAuthUnsupported(t *testing.T) {
+	server := newFakeServer(t, false)
+	sender := server.sender()
+	sender.Username, sender.Password = "user", "pass"
+	if err := sender.Send(&Message{To: []string{"a@example.com"}, Subject: "s", Body: "b"}); err == nil {
+		t.Fatal("sent without the configured authentication")
+	}
+	server.wait(t)
+
+	if server.command("MAIL") != "" || server.data != "" {
+		t.Fatal("message was handed over unauthenticated")
+	}
+}
+
+func TestSendNoRecipient(t *testing.T) {
+	sender := &Sender{Host: "127.0.0.1", Port: 1, From: "varconf@example.com"}
+	if err := sender.Send(&Message{Subject: "s", Body: "b"}); err == nil {
+		t.Fatal("sent without a recipient")
+	}
+}
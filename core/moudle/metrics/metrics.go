@@ -0,0 +1,269 @@
+// metrics
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	COUNTER   = "counter"
+	GAUGE     = "gauge"
+	HISTOGRAM = "histogram"
+)
+
+// DefaultBuckets are the latency buckets in seconds used when none are given.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Sample is one labeled value reported by a GaugeFunc.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry keeps the metrics and writes them in the Prometheus text format.
+type Registry struct {
+	lock       sync.RWMutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make([]collector, 0)}
+}
+
+func (_self *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	counter := &Counter{meta: meta{metricName: name, help: help, labels: labels}, values: make(map[string]*counterValue)}
+	_self.register(counter)
+	return counter
+}
+
+func (_self *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	histogram := &Histogram{meta: meta{metricName: name, help: help, labels: labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+	_self.register(histogram)
+	return histogram
+}
+
+// NewGaugeFunc registers a gauge whose samples are read from collect at scrape time.
+func (_self *Registry) NewGaugeFunc(name, help string, collect func() []Sample, labels ...string) {
+	_self.register(&gaugeFunc{meta: meta{metricName: name, help: help, labels: labels}, collect: collect})
+}
+
+// NewCounterFunc registers a counter whose samples are read from collect at scrape time.
+func (_self *Registry) NewCounterFunc(name, help string, collect func() []Sample, labels ...string) {
+	_self.register(&gaugeFunc{meta: meta{metricName: name, help: help, labels: labels, kind: COUNTER}, collect: collect})
+}
+
+func (_self *Registry) Write(w io.Writer) {
+	_self.lock.RLock()
+	collectors := _self.collectors
+	_self.lock.RUnlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func (_self *Registry) register(c collector) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	for _, exist := range _self.collectors {
+		if exist.name() == c.name() {
+			panic("metrics: duplicate metric " + c.name())
+		}
+	}
+	_self.collectors = append(_self.collectors, c)
+}
+
+type meta struct {
+	metricName string
+	help       string
+	labels     []string
+	kind       string
+}
+
+func (_self *meta) name() string {
+	return _self.metricName
+}
+
+func (_self *meta) writeHeader(w io.Writer, kind string) {
+	if _self.kind != "" {
+		kind = _self.kind
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", _self.metricName, escapeHelp(_self.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", _self.metricName, kind)
+}
+
+func (_self *meta) labelString(values []string, extra ...string) string {
+	if len(values) != len(_self.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", _self.metricName, len(_self.labels), len(values)))
+	}
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString("{")
+	for i, label := range _self.labels {
+		fmt.Fprintf(&buffer, "%s=\"%s\",", label, escapeLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		fmt.Fprintf(&buffer, "%s=\"%s\",", extra[i], escapeLabel(extra[i+1]))
+	}
+	return strings.TrimSuffix(buffer.String(), ",") + "}"
+}
+
+type counterValue struct {
+	labels string
+	value  float64
+}
+
+type Counter struct {
+	meta
+	lock   sync.Mutex
+	values map[string]*counterValue
+}
+
+func (_self *Counter) Inc(labels ...string) {
+	_self.Add(1, labels...)
+}
+
+func (_self *Counter) Add(delta float64, labels ...string) {
+	labelString := _self.labelString(labels)
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	value, exist := _self.values[labelString]
+	if !exist {
+		value = &counterValue{labels: labelString}
+		_self.values[labelString] = value
+	}
+	value.value += delta
+}
+
+func (_self *Counter) write(w io.Writer) {
+	_self.lock.Lock()
+	values := make([]counterValue, 0, len(_self.values))
+	for _, value := range _self.values {
+		values = append(values, *value)
+	}
+	_self.lock.Unlock()
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].labels < values[j].labels
+	})
+	_self.writeHeader(w, COUNTER)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s%s %s\n", _self.metricName, value.labels, formatFloat(value.value))
+	}
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type Histogram struct {
+	meta
+	lock    sync.Mutex
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+func (_self *Histogram) Observe(v float64, labels ...string) {
+	key := _self.labelString(labels)
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	value, exist := _self.values[key]
+	if !exist {
+		value = &histogramValue{labels: labels, counts: make([]uint64, len(_self.buckets))}
+		_self.values[key] = value
+	}
+	for i, bound := range _self.buckets {
+		if v <= bound {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+func (_self *Histogram) write(w io.Writer) {
+	_self.lock.Lock()
+	keys := make([]string, 0, len(_self.values))
+	values := make(map[string]histogramValue, len(_self.values))
+	for key, value := range _self.values {
+		keys = append(keys, key)
+		copied := *value
+		copied.counts = append([]uint64(nil), value.counts...)
+		values[key] = copied
+	}
+	_self.lock.Unlock()
+
+	sort.Strings(keys)
+	_self.writeHeader(w, HISTOGRAM)
+	for _, key := range keys {
+		value := values[key]
+		for i, bound := range _self.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", _self.metricName, _self.labelString(value.labels, "le", formatFloat(bound)), value.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", _self.metricName, _self.labelString(value.labels, "le", "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", _self.metricName, key, formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", _self.metricName, key, value.count)
+	}
+}
+
+type gaugeFunc struct {
+	meta
+	collect func() []Sample
+}
+
+func (_self *gaugeFunc) write(w io.Writer) {
+	samples := _self.collect()
+	_self.writeHeader(w, GAUGE)
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", _self.metricName, _self.labelString(sample.Labels), formatFloat(sample.Value))
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
@@ -6,17 +6,33 @@ import (
 	"sync"
 )
 
+type closedSignal struct{}
+
+// CLOSED is handed to the waiters when the poll is closed.
+var CLOSED interface{} = closedSignal{}
+
+// Filter decides whether a waiter is interested in the pushed data.
+type Filter func(data interface{}) bool
+
 type Element struct {
-	key     string
-	element *list.Element
+	key      string
+	index    int
+	filter   Filter
+	pollChan chan interface{}
+	element  *list.Element
 }
 
 func (_self *Element) Chan() chan interface{} {
-	return _self.element.Value.(chan interface{})
+	return _self.pollChan
+}
+
+func (_self *Element) Index() int {
+	return _self.index
 }
 
 type MessagePoll struct {
 	lock        sync.RWMutex
+	closed      bool
 	chanListMap map[string]*list.List
 }
 
@@ -26,17 +42,29 @@ func NewMessagePoll() *MessagePoll {
 	}
 }
 
-func (_self *MessagePoll) Poll(key string) *Element {
+// Poll parks a waiter on key, remembering the last index the waiter has seen.
+func (_self *MessagePoll) Poll(key string, index int) *Element {
+	return _self.PollFilter(key, index, nil)
+}
+
+// PollFilter parks a waiter on key which is only woken by the pushed data accepted by filter.
+func (_self *MessagePoll) PollFilter(key string, index int, filter Filter) *Element {
 	_self.lock.Lock()
 	defer _self.lock.Unlock()
 
-	pollChan := make(chan interface{}, 1)
+	pollElement := &Element{key: key, index: index, filter: filter, pollChan: make(chan interface{}, 1)}
+	if _self.closed {
+		pollElement.pollChan <- CLOSED
+		return pollElement
+	}
+
 	chanList, exist := _self.chanListMap[key]
 	if !exist {
 		chanList = list.New()
 		_self.chanListMap[key] = chanList
 	}
-	return &Element{key: key, element: chanList.PushBack(pollChan)}
+	pollElement.element = chanList.PushBack(pollElement)
+	return pollElement
 }
 
 func (_self *MessagePoll) Contain(key string) bool {
@@ -61,20 +89,113 @@ func (_self *MessagePoll) Keys() []string {
 	return keys
 }
 
+// Counts returns how many waiters are parked on each key.
+func (_self *MessagePoll) Counts() map[string]int {
+	_self.lock.RLock()
+	defer _self.lock.RUnlock()
+
+	counts := make(map[string]int, len(_self.chanListMap))
+	for key, chanList := range _self.chanListMap {
+		counts[key] = chanList.Len()
+	}
+	return counts
+}
+
+// Push wakes the waiters on key which accept data.
 func (_self *MessagePoll) Push(key string, data interface{}) bool {
+	return _self.PushIndex(key, -1, data) > 0
+}
+
+// PushIndex wakes the waiters on key which accept data, and moves the others
+// to index, so they are treated as up to date. A negative index leaves them as is.
+func (_self *MessagePoll) PushIndex(key string, index int, data interface{}) int {
 	_self.lock.Lock()
 	defer _self.lock.Unlock()
 
 	chanList, exist := _self.chanListMap[key]
-	if exist {
-		for e := chanList.Front(); e != nil; e = e.Next() {
-			pollChan := e.Value.(chan interface{})
-			pollChan <- data
+	if !exist {
+		return 0
+	}
+
+	count := 0
+	for e := chanList.Front(); e != nil; {
+		next := e.Next()
+		pollElement := e.Value.(*Element)
+		if pollElement.filter == nil || pollElement.filter(data) {
+			_self.wake(chanList, e, data)
+			count++
+		} else if index >= 0 {
+			pollElement.index = index
 		}
+		e = next
+	}
+	if chanList.Len() == 0 {
 		delete(_self.chanListMap, key)
-		return true
 	}
-	return false
+	return count
+}
+
+// PushStale wakes the waiters on key whose last index differs from index
+// regardless of their filter, and returns how many were woken.
+func (_self *MessagePoll) PushStale(key string, index int, data interface{}) int {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	chanList, exist := _self.chanListMap[key]
+	if !exist {
+		return 0
+	}
+
+	count := 0
+	for e := chanList.Front(); e != nil; {
+		next := e.Next()
+		pollElement := e.Value.(*Element)
+		if pollElement.index != index {
+			_self.wake(chanList, e, data)
+			count++
+		}
+		e = next
+	}
+	if chanList.Len() == 0 {
+		delete(_self.chanListMap, key)
+	}
+	return count
+}
+
+// Advance moves the waiters on key to index without waking them, they have
+// seen everything up to index.
+func (_self *MessagePoll) Advance(key string, index int) int {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	chanList, exist := _self.chanListMap[key]
+	if !exist {
+		return 0
+	}
+
+	for e := chanList.Front(); e != nil; e = e.Next() {
+		e.Value.(*Element).index = index
+	}
+	return chanList.Len()
+}
+
+// Close wakes every waiter with CLOSED, and so are the waiters parked afterwards.
+func (_self *MessagePoll) Close() int {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	count := 0
+	_self.closed = true
+	for key, chanList := range _self.chanListMap {
+		for e := chanList.Front(); e != nil; {
+			next := e.Next()
+			_self.wake(chanList, e, CLOSED)
+			count++
+			e = next
+		}
+		delete(_self.chanListMap, key)
+	}
+	return count
 }
 
 func (_self *MessagePoll) Remove(element *Element) bool {
@@ -82,9 +203,21 @@ func (_self *MessagePoll) Remove(element *Element) bool {
 	defer _self.lock.Unlock()
 
 	chanList, exist := _self.chanListMap[element.key]
-	if exist {
-		chanList.Remove(element.element)
-		return true
+	if !exist || element.element == nil {
+		return false
 	}
-	return false
+
+	chanList.Remove(element.element)
+	element.element = nil
+	if chanList.Len() == 0 {
+		delete(_self.chanListMap, element.key)
+	}
+	return true
+}
+
+func (_self *MessagePoll) wake(chanList *list.List, e *list.Element, data interface{}) {
+	pollElement := e.Value.(*Element)
+	pollElement.pollChan <- data
+	pollElement.element = nil
+	chanList.Remove(e)
 }
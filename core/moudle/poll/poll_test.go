@@ -0,0 +1,205 @@
+package poll
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, element *Element) interface{} {
+	t.Helper()
+	select {
+	case data := <-element.Chan():
+		return data
+	case <-time.After(time.Second):
+		t.Fatal("waiter wasn't woken")
+		return nil
+	}
+}
+
+func pending(element *Element) bool {
+	select {
+	case <-element.Chan():
+		return false
+	default:
+		return true
+	}
+}
+
+func TestPush(t *testing.T) {
+	messagePoll := NewMessagePoll()
+	element := messagePoll.Poll("app_1", 1)
+
+	if messagePoll.Push("app_2", "a") {
+		t.Fatal("push to another key woke a waiter")
+	}
+	if !messagePoll.Push("app_1", "a") {
+		t.Fatal("push didn't wake the waiter")
+	}
+	if data := receive(t, element); data != "a" {
+		t.Fatalf("got %v, want a", data)
+	}
+	if messagePoll.Contain("app_1") {
+		t.Fatal("woken waiter is still parked")
+	}
+}
+
+func TestPushIndex(t *testing.T) {
+	messagePoll := NewMessagePoll()
+	accept := func(data interface{}) bool { return data == "a" }
+	woken := messagePoll.PollFilter("app_1", 1, accept)
+	skipped := messagePoll.PollFilter("app_1", 1, func(data interface{}) bool { return false })
+
+	if count := messagePoll.PushIndex("app_1", 2, "a"); count != 1 {
+		t.Fatalf("woke %d waiters, want 1", count)
+	}
+	receive(t, woken)
+	if !pending(skipped) || skipped.Index() != 2 {
+		t.Fatalf("skipped waiter at index %d, want 2 and parked", skipped.Index())
+	}
+
+	// the skipped waiter is up to date, the cron leaves it alone
+	if count := messagePoll.PushStale("app_1", 2, nil); count != 0 {
+		t.Fatalf("stale push woke %d waiters, want 0", count)
+	}
+	if count := messagePoll.PushStale("app_1", 3, 3); count != 1 {
+		t.Fatalf("stale push woke %d waiters, want 1", count)
+	}
+	if data := receive(t, skipped); data != 3 {
+		t.Fatalf("got %v, want 3", data)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	messagePoll := NewMessagePoll()
+	element := messagePoll.Poll("key_1_a", 1)
+
+	if count := messagePoll.Advance("key_1_a", 2); count != 1 {
+		t.Fatalf("advanced %d waiters, want 1", count)
+	}
+	if !pending(element) || element.Index() != 2 {
+		t.Fatalf("advanced waiter at index %d, want 2 and parked", element.Index())
+	}
+	if count := messagePoll.PushStale("key_1_a", 2, nil); count != 0 {
+		t.Fatalf("stale push woke %d waiters, want 0", count)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	messagePoll := NewMessagePoll()
+	element := messagePoll.Poll("app_1", 1)
+
+	if !messagePoll.Remove(element) {
+		t.Fatal("remove of a parked waiter failed")
+	}
+	if messagePoll.Remove(element) {
+		t.Fatal("second remove succeeded")
+	}
+	if messagePoll.Push("app_1", "a") || !pending(element) {
+		t.Fatal("removed waiter was woken")
+	}
+}
+
+func TestClose(t *testing.T) {
+	messagePoll := NewMessagePoll()
+	element := messagePoll.Poll("app_1", 1)
+
+	if count := messagePoll.Close(); count != 1 {
+		t.Fatalf("closed %d waiters, want 1", count)
+	}
+	if data := receive(t, element); data != CLOSED {
+		t.Fatalf("got %v, want CLOSED", data)
+	}
+	if data := receive(t, messagePoll.Poll("app_1", 1)); data != CLOSED {
+		t.Fatalf("waiter parked after close got %v, want CLOSED", data)
+	}
+}
+
+// TestConcurrent parks, pushes and removes from many goroutines, run it with
+// -race. Every waiter ends up either woken once or removed, never both.
+func TestConcurrent(t *testing.T) {
+	messagePoll := NewMessagePoll()
+	keys := []string{"app_1", "app_2", "key_1_a", "key_1_b"}
+
+	const waiters = 200
+	var waiting, pushing sync.WaitGroup
+	var mutex sync.Mutex
+	wokenCount, removedCount := 0, 0
+	waiting.Add(waiters)
+	for i := 0; i < waiters; i++ {
+		go func(i int) {
+			defer waiting.Done()
+			key := keys[i%len(keys)]
+			var element *Element
+			if i%2 == 0 {
+				element = messagePoll.Poll(key, i%3)
+			} else {
+				element = messagePoll.PollFilter(key, i%3, func(data interface{}) bool { return data == key })
+			}
+
+			select {
+			case <-element.Chan():
+				mutex.Lock()
+				wokenCount++
+				mutex.Unlock()
+			case <-time.After(time.Duration(rand.Intn(20)) * time.Millisecond):
+				if messagePoll.Remove(element) {
+					mutex.Lock()
+					removedCount++
+					mutex.Unlock()
+				} else {
+					// woken between the timeout and the remove
+					<-element.Chan()
+					mutex.Lock()
+					wokenCount++
+					mutex.Unlock()
+				}
+			}
+		}(i)
+	}
+
+	stop := make(chan struct{})
+	pushing.Add(4)
+	for n := 0; n < 4; n++ {
+		go func(n int) {
+			defer pushing.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := keys[(n+i)%len(keys)]
+				switch i % 5 {
+				case 0:
+					messagePoll.Push(key, key)
+				case 1:
+					messagePoll.PushIndex(key, i%3, fmt.Sprint(i))
+				case 2:
+					messagePoll.PushStale(key, i%3, i)
+				case 3:
+					messagePoll.Advance(key, i%3)
+				default:
+					messagePoll.Keys()
+					messagePoll.Counts()
+					messagePoll.Contain(key)
+				}
+			}
+		}(n)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	messagePoll.Close()
+	waiting.Wait()
+	close(stop)
+	pushing.Wait()
+
+	if wokenCount+removedCount != waiters {
+		t.Fatalf("%d woken and %d removed, want %d in all", wokenCount, removedCount, waiters)
+	}
+	if len(messagePoll.Keys()) != 0 {
+		t.Fatalf("waiters left parked on %v", messagePoll.Keys())
+	}
+}
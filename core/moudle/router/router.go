@@ -1,9 +1,10 @@
 package router
 
 import (
+	"context"
+	"fmt"
 	"github.com/google/uuid"
 	"io/ioutil"
-	"log"
 	"mime"
 	"net"
 	"net/http"
@@ -13,9 +14,12 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
+
+	"varconf-server/core/moudle/logger"
 )
 
 const (
@@ -54,7 +58,14 @@ type Context struct {
 
 type Resolver func(http.ResponseWriter, *http.Request, error)
 
+// Observer is told about every served request, route is the pattern it matched.
+type Observer func(method, route string, status int, duration time.Duration)
+
+// AccessFields returns the extra key value pairs of the access line of a request.
+type AccessFields func(r *http.Request, c *Context) []interface{}
+
 type PathPattern struct {
+	raw    string
 	path   string
 	regex  *regexp.Regexp
 	params map[int]string
@@ -82,36 +93,44 @@ type Router struct {
 	addr                string
 	tag                 string
 	listener            net.Listener
-	logger              *log.Logger
+	server              *http.Server
+	logger              *logger.Logger
+	observer            Observer
+	accessFields        AccessFields
 	handlerAdapters     []*HandlerAdapter
 	interceptorAdapters []*InterceptorAdapter
 	resolverAdapters    map[string]*ResolverAdapter
 }
 
 func NewRouter() *Router {
-	return &Router{
+	router := &Router{
 		addr:                ":8888",
 		tag:                 "varconf",
-		logger:              log.New(os.Stdout, "", log.Ldate|log.Ltime),
+		logger:              logger.Default(),
 		handlerAdapters:     make([]*HandlerAdapter, 0),
 		interceptorAdapters: make([]*InterceptorAdapter, 0),
 		resolverAdapters:    make(map[string]*ResolverAdapter),
 	}
+	router.server = &http.Server{Handler: router}
+	return router
 }
 
 func (_self *Router) Run() error {
 	listener, err := net.Listen("tcp", _self.addr)
 	if err != nil {
-		_self.logger.Fatal("Listen: ", err)
+		_self.logger.Error("router: listen error", "addr", _self.addr, "error", err)
 		return err
 	}
 
 	_self.listener = listener
-	_self.logger.Println("Listening on http://" + _self.addr)
+	_self.logger.Info("router: listening on http://" + _self.addr)
 
-	err = http.Serve(_self.listener, _self)
+	err = _self.server.Serve(_self.listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
 	if err != nil {
-		_self.logger.Fatal("ListenAndServe: ", err)
+		_self.logger.Error("router: serve error", "error", err)
 	}
 	return err
 }
@@ -119,25 +138,30 @@ func (_self *Router) Run() error {
 func (_self *Router) RunTLS(certFile, keyFile string) error {
 	listener, err := net.Listen("tcp", _self.addr)
 	if err != nil {
-		_self.logger.Fatal("Listen: ", err)
+		_self.logger.Error("router: listen error", "addr", _self.addr, "error", err)
 		return err
 	}
 
 	_self.listener = listener
-	_self.logger.Println("Listening on https://" + _self.addr)
+	_self.logger.Info("router: listening on https://" + _self.addr)
 
-	err = http.ServeTLS(_self.listener, _self, certFile, keyFile)
+	err = _self.server.ServeTLS(_self.listener, certFile, keyFile)
+	if err == http.ErrServerClosed {
+		return nil
+	}
 	if err != nil {
-		_self.logger.Fatal("ListenAndServe: ", err)
+		_self.logger.Error("router: serve error", "error", err)
 	}
 	return err
 }
 
 func (_self *Router) Stop() error {
-	if _self.listener != nil {
-		return _self.listener.Close()
-	}
-	return nil
+	return _self.server.Close()
+}
+
+// Shutdown stops accepting connections and waits for the in-flight requests until ctx is done.
+func (_self *Router) Shutdown(ctx context.Context) error {
+	return _self.server.Shutdown(ctx)
 }
 
 func (_self *Router) SetAddress(args ...interface{}) {
@@ -172,10 +196,18 @@ func (_self *Router) SetTag(tag string) {
 	_self.tag = tag
 }
 
-func (_self *Router) SetLogger(logger *log.Logger) {
+func (_self *Router) SetLogger(logger *logger.Logger) {
 	_self.logger = logger
 }
 
+func (_self *Router) SetObserver(observer Observer) {
+	_self.observer = observer
+}
+
+func (_self *Router) SetAccessFields(accessFields AccessFields) {
+	_self.accessFields = accessFields
+}
+
 func (_self *Router) Connect(path string, handlerFunc Handler) {
 	_self.AddRoute(CONNECT, path, handlerFunc)
 }
@@ -302,6 +334,21 @@ func (_self *Router) AddResolver(err error, resolver Resolver) {
 }
 
 func (_self *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	route := _self.routeOf(r)
+	c := &Context{Data: make(map[string]interface{})}
+
+	_self.serveHTTP(sw, r, c)
+
+	duration := time.Since(start)
+	if _self.observer != nil {
+		_self.observer(r.Method, route, sw.Status(), duration)
+	}
+	_self.accessLog(sw, r, c, route, duration)
+}
+
+func (_self *Router) serveHTTP(w http.ResponseWriter, r *http.Request, c *Context) {
 	requestId := r.Header.Get("Request-Id")
 	if requestId == "" {
 		requestId = uuid.New().String()
@@ -328,7 +375,6 @@ func (_self *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// pre handle the request
-	c := &Context{Data: make(map[string]interface{})}
 	for _, adapter := range interceptorAdapters {
 		if adapter.interceptor != nil {
 			if !adapter.interceptor.PreHandleFunc(w, r, c) {
@@ -364,6 +410,8 @@ func (_self *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (_self *Router) parsePattern(path string) (*PathPattern, error) {
+	raw := path
+
 	// split the url into sections
 	parts := strings.Split(path, "/")
 
@@ -391,10 +439,9 @@ func (_self *Router) parsePattern(path string) (*PathPattern, error) {
 	regex, err := regexp.Compile(path)
 	if err != nil {
 		panic(err)
-		return nil, err
 	}
 
-	return &PathPattern{path: path, regex: regex, params: params}, nil
+	return &PathPattern{raw: raw, path: path, regex: regex, params: params}, nil
 }
 
 func (_self *Router) matchPattern(r *http.Request, p *PathPattern) bool {
@@ -422,12 +469,23 @@ func (_self *Router) matchPattern(r *http.Request, p *PathPattern) bool {
 	return true
 }
 
-func (_self *Router) serveRequest(w http.ResponseWriter, r *http.Request, a *HandlerAdapter, c *Context) {
-	_self.logger.Println(r.Method, r.RequestURI)
+// routeOf finds the pattern of the route serving r without touching its query.
+func (_self *Router) routeOf(r *http.Request) string {
+	for _, adapter := range _self.handlerAdapters {
+		if adapter.method == ANY || adapter.method == r.Method {
+			if adapter.pathPattern.regex.FindString(r.URL.Path) == r.URL.Path {
+				return adapter.pathPattern.raw
+			}
+		}
+	}
+	return "NotFound"
+}
 
+func (_self *Router) serveRequest(w http.ResponseWriter, r *http.Request, a *HandlerAdapter, c *Context) {
 	defer func() {
 		if err := recover(); err != nil {
-			_self.logger.Println(err)
+			_self.logger.Error("router: handler panic", "request_id", w.Header().Get("Request-Id"),
+				"error", fmt.Sprint(err), "stack", string(debug.Stack()))
 
 			resolverAdapter := _self.resolverAdapters[reflect.TypeOf(err).String()]
 			if resolverAdapter == nil {
@@ -449,6 +507,27 @@ func (_self *Router) serveRequest(w http.ResponseWriter, r *http.Request, a *Han
 	a.handler(w, r, c)
 }
 
+func (_self *Router) accessLog(w *statusWriter, r *http.Request, c *Context, route string, duration time.Duration) {
+	if !_self.logger.Enabled(logger.INFO) {
+		return
+	}
+
+	fields := []interface{}{
+		"request_id", w.Header().Get("Request-Id"),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"route", route,
+		"status", w.Status(),
+		"latency_ms", float64(duration.Microseconds()) / 1000,
+		"bytes", w.bytes,
+		"remote", r.RemoteAddr,
+	}
+	if _self.accessFields != nil {
+		fields = append(fields, _self.accessFields(r, c)...)
+	}
+	_self.logger.Info("access", fields...)
+}
+
 func (_self *Router) serveFile(w http.ResponseWriter, r *http.Request, c *Context) {
 	// check the bind data and type
 	bind := c.Data[BIND]
@@ -547,3 +626,42 @@ func (_self *Router) isFileExists(filePath string) bool {
 
 	return !info.IsDir()
 }
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (_self *statusWriter) WriteHeader(status int) {
+	if _self.status == 0 {
+		_self.status = status
+	}
+	_self.ResponseWriter.WriteHeader(status)
+}
+
+func (_self *statusWriter) Write(data []byte) (int, error) {
+	if _self.status == 0 {
+		_self.status = http.StatusOK
+	}
+	n, err := _self.ResponseWriter.Write(data)
+	_self.bytes += n
+	return n, err
+}
+
+func (_self *statusWriter) Status() int {
+	if _self.status == 0 {
+		return http.StatusOK
+	}
+	return _self.status
+}
+
+func (_self *statusWriter) Flush() {
+	if flusher, ok := _self.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (_self *statusWriter) CloseNotify() <-chan bool {
+	return _self.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
@@ -1,73 +1,123 @@
 package service
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"github.com/google/uuid"
 	"time"
 
 	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
 )
 
 type AppService struct {
 	appDao      *dao.AppDao
+	appLinkDao  *dao.AppLinkDao
 	manageTxDao *dao.ManageTxDao
+	eventHub    *EventHub
 }
 
-func NewAppService(db *sql.DB) *AppService {
+func NewAppService(db *common.DB, eventHub *EventHub) *AppService {
 	appService := AppService{
 		appDao:      dao.NewAppDao(db),
+		appLinkDao:  dao.NewAppLinkDao(db),
 		manageTxDao: dao.NewManageTxDao(db),
+		eventHub:    eventHub,
 	}
 	return &appService
 }
 
-func (_self *AppService) PageQuery(likeName string, pageIndex, pageSize int64) ([]*dao.AppData, int64, int64) {
+func (_self *AppService) PageQuery(ctx context.Context, likeName string, public int, pageIndex, pageSize int64) ([]*dao.AppData, int64, int64, error) {
 	start := (pageIndex - 1) * pageSize
 	end := start + pageSize
 
-	pageData := _self.appDao.QueryApps(dao.QueryAppData{LikeName: likeName, Start: start, End: end})
-	totalCount := _self.appDao.CountApps(dao.QueryAppData{LikeName: likeName})
+	pageData, err := _self.appDao.QueryApps(ctx, dao.QueryAppData{LikeName: likeName, Public: public, Start: start, End: end})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	totalCount, err := _self.appDao.CountApps(ctx, dao.QueryAppData{LikeName: likeName, Public: public})
+	if err != nil {
+		return nil, 0, 0, err
+	}
 	pageCount := totalCount / pageSize
 	if totalCount%pageSize != 0 {
 		pageCount += 1
 	}
-	return pageData, pageCount, totalCount
+	return pageData, pageCount, totalCount, nil
 }
 
-func (_self *AppService) QueryApp(appId int64) *dao.AppData {
-	apps := _self.appDao.QueryApps(dao.QueryAppData{AppId: appId})
-	if len(apps) != 1 {
-		return nil
-	}
-
-	return apps[0]
+func (_self *AppService) QueryApp(ctx context.Context, appId int64) (*dao.AppData, error) {
+	return _self.appDao.QueryApp(ctx, appId)
 }
 
-func (_self *AppService) CreateApp(appData *dao.AppData) bool {
+func (_self *AppService) CreateApp(ctx context.Context, appData *dao.AppData) error {
 	if appData == nil {
-		return false
+		return errors.New("app is empty")
 	}
 
 	appData.CreateTime.Time = time.Now()
 	appData.UpdateTime.Time = time.Now()
 	appData.ApiKey = appData.Code + ":" + uuid.New().String()
-	rowCnt := _self.appDao.InsertApp(appData)
-	if rowCnt != 1 {
-		return false
+	appData.Version = 1
+	if appData.Public != dao.APP_PUBLIC {
+		appData.Public = dao.APP_PRIVATE
 	}
-	return true
+	_, err := _self.appDao.InsertApp(ctx, appData)
+	return err
 }
 
-func (_self *AppService) SelectedUpdateApp(appData dao.AppData) bool {
+// SelectedUpdateApp updates the name, desc, api key and public among fields,
+// the code names the git-sync files so it never changes. When appData.Version
+// is set and stale it fails with a StaleError carrying the current app.
+func (_self *AppService) SelectedUpdateApp(ctx context.Context, appData dao.AppData, fields common.Fields) error {
+	fields = fields.Only("name", "desc", "apiKey", "public").With("updateTime")
+	if fields.Has("name") && appData.Name == "" {
+		return errors.New("name is empty")
+	}
+	if fields.Has("apiKey") && appData.ApiKey == "" {
+		return errors.New("api key is empty")
+	}
+	if fields.Has("public") && appData.Public != dao.APP_PRIVATE && appData.Public != dao.APP_PUBLIC {
+		return errors.New("unknown public")
+	}
+
+	// a linked namespace can't turn private
+	if fields.Has("public") && appData.Public == dao.APP_PRIVATE {
+		appLinks, err := _self.appLinkDao.QueryLinkedApps(ctx, appData.AppId)
+		if err != nil {
+			return err
+		}
+		if len(appLinks) > 0 {
+			return common.Conflict("app is linked by other apps")
+		}
+	}
 	appData.UpdateTime.Time = time.Now()
 
-	rowCnt := _self.appDao.SelectedUpdateApp(appData)
+	rowCnt, err := _self.appDao.SelectedUpdateApp(ctx, appData, fields)
+	if err != nil {
+		return err
+	}
 	if rowCnt != 1 {
-		return false
+		current, err := _self.QueryApp(ctx, appData.AppId)
+		if err != nil {
+			return err
+		}
+		return common.Stale(current)
 	}
-	return true
+	return nil
 }
 
-func (_self *AppService) DeleteApp(appId int64) bool {
-	return _self.manageTxDao.DeleteApp(appId)
+func (_self *AppService) DeleteApp(ctx context.Context, appId int64, user string) error {
+	appData, err := _self.QueryApp(ctx, appId)
+	if err != nil {
+		return err
+	}
+
+	err = _self.manageTxDao.DeleteApp(ctx, appId)
+	if err != nil {
+		return err
+	}
+
+	_self.eventHub.Publish(&AppEvent{Type: EVENT_APP_DELETE, App: appData, AppId: appId, Keys: []string{}, Operator: user})
+	return nil
 }
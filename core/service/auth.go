@@ -1,9 +1,10 @@
 package service
 
 import (
+	"context"
 	"crypto/md5"
-	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -11,15 +12,19 @@ import (
 	"time"
 
 	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
 )
 
+// ErrDenied is a login or token that doesn't authenticate.
+var ErrDenied = errors.New("permission deny")
+
 type AuthService struct {
 	appDao       *dao.AppDao
 	userDao      *dao.UserDao
 	tokenTimeout int64
 }
 
-func NewAuthService(db *sql.DB) *AuthService {
+func NewAuthService(db *common.DB) *AuthService {
 	authService := AuthService{
 		appDao:       dao.NewAppDao(db),
 		userDao:      dao.NewUserDao(db),
@@ -28,33 +33,41 @@ func NewAuthService(db *sql.DB) *AuthService {
 	return &authService
 }
 
-func (_self *AuthService) Login(name, password string) (bool, string) {
-	users := _self.userDao.QueryUsers(dao.QueryUserData{Name: name})
+// Login returns the token of name, ErrDenied when the password doesn't match.
+func (_self *AuthService) Login(ctx context.Context, name, password string) (string, error) {
+	users, err := _self.userDao.QueryUsers(ctx, dao.QueryUserData{Name: name})
+	if err != nil {
+		return "", err
+	}
 	if len(users) > 0 {
 		user := users[0]
 		if user != nil && user.Name == name && user.Password == password {
 			gen := _self.Gen(user.Name, user.Password)
 			info := name + ":" + gen + ":" + strconv.FormatInt(time.Now().Unix(), 10)
-			return true, base64.StdEncoding.EncodeToString([]byte(info))
+			return base64.StdEncoding.EncodeToString([]byte(info)), nil
 		}
 	}
-	return false, ""
+	return "", ErrDenied
 }
 
-func (_self *AuthService) Auth(token string) (bool, *dao.UserData) {
+// Auth returns the user of token, ErrDenied when the token is invalid or expired.
+func (_self *AuthService) Auth(ctx context.Context, token string) (*dao.UserData, error) {
 	bytes, err := base64.StdEncoding.DecodeString(token)
 	if err != nil {
-		return false, nil
+		return nil, ErrDenied
 	}
 
 	arrays := strings.Split(string(bytes), ":")
 	if len(arrays) != 3 {
-		return false, nil
+		return nil, ErrDenied
 	}
 
-	users := _self.userDao.QueryUsers(dao.QueryUserData{Name: arrays[0]})
+	users, err := _self.userDao.QueryUsers(ctx, dao.QueryUserData{Name: arrays[0]})
+	if err != nil {
+		return nil, err
+	}
 	if len(users) != 1 {
-		return false, nil
+		return nil, ErrDenied
 	}
 
 	user := users[0]
@@ -63,12 +76,12 @@ func (_self *AuthService) Auth(token string) (bool, *dao.UserData) {
 		stamp, err := strconv.ParseInt(arrays[2], 10, 64)
 		if err == nil {
 			if time.Now().Unix()-stamp < _self.tokenTimeout {
-				return true, user
+				return user, nil
 			}
 		}
 	}
 
-	return false, nil
+	return nil, ErrDenied
 }
 
 func (_self *AuthService) Gen(name, password string) string {
@@ -78,10 +91,14 @@ func (_self *AuthService) Gen(name, password string) string {
 	return fmt.Sprintf("%x", hash.Sum(nil))
 }
 
-func (_self *AuthService) ApiAuth(token string) (bool, *dao.AppData) {
-	apps := _self.appDao.QueryApps(dao.QueryAppData{ApiKey: token})
+// ApiAuth returns the app of the api key token, ErrDenied when there is none.
+func (_self *AuthService) ApiAuth(ctx context.Context, token string) (*dao.AppData, error) {
+	apps, err := _self.appDao.QueryApps(ctx, dao.QueryAppData{ApiKey: token})
+	if err != nil {
+		return nil, err
+	}
 	if len(apps) != 1 {
-		return false, nil
+		return nil, ErrDenied
 	}
-	return true, apps[0]
+	return apps[0], nil
 }
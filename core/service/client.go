@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron"
+	"sort"
+	"sync"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/logger"
+)
+
+const (
+	clientRetention = 7 * 24 * time.Hour
+)
+
+type ClientService struct {
+	appDao    *dao.AppDao
+	clientDao *dao.ClientDao
+	lock      sync.Mutex
+	clientMap map[string]*dao.ClientData
+	flushCron *cron.Cron
+	cleanCron *cron.Cron
+}
+
+type ClientStatus struct {
+	*dao.ClientData
+	Latest bool `json:"latest"`
+}
+
+func NewClientService(db *common.DB) *ClientService {
+	clientService := ClientService{
+		appDao:    dao.NewAppDao(db),
+		clientDao: dao.NewClientDao(db),
+		clientMap: make(map[string]*dao.ClientData),
+	}
+	return &clientService
+}
+
+func (_self *ClientService) Report(data dao.ClientData) {
+	if data.InstanceId == "" {
+		data.InstanceId = data.Ip
+	}
+	data.LastSeenTime.Time = time.Now()
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	_self.clientMap[_self.clientKey(data.AppId, data.InstanceId)] = &data
+}
+
+func (_self *ClientService) QueryClients(ctx context.Context, appId int64) ([]*ClientStatus, int, error) {
+	appData, err := _self.appDao.QueryApp(ctx, appId)
+	if err != nil {
+		return nil, 0, err
+	}
+	releaseIndex := appData.ReleaseIndex
+
+	// merge flushed and pending clients
+	flushed, err := _self.clientDao.QueryClients(ctx, appId)
+	if err != nil {
+		return nil, 0, err
+	}
+	clientMap := make(map[string]*dao.ClientData)
+	for _, client := range flushed {
+		clientMap[_self.clientKey(client.AppId, client.InstanceId)] = client
+	}
+	_self.lock.Lock()
+	for key, client := range _self.clientMap {
+		if client.AppId == appId {
+			clientMap[key] = client
+		}
+	}
+	_self.lock.Unlock()
+
+	clients := make([]*ClientStatus, 0, len(clientMap))
+	for _, client := range clientMap {
+		clients = append(clients, &ClientStatus{ClientData: client, Latest: client.LastIndex == releaseIndex})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].LastSeenTime.After(clients[j].LastSeenTime.Time)
+	})
+	return clients, releaseIndex, nil
+}
+
+func (_self *ClientService) CronFlush(spec string) {
+	c := cron.New()
+	c.AddFunc(spec, func() {
+		_self.flush()
+	})
+	c.Start()
+	_self.flushCron = c
+}
+
+// CronClean drops the clients not seen for clientRetention.
+func (_self *ClientService) CronClean(spec string) {
+	c := cron.New()
+	c.AddFunc(spec, func() {
+		_, err := _self.clientDao.DeleteClients(context.Background(), time.Now().Add(-clientRetention))
+		if err != nil {
+			logger.Error("client: clean clients error", "error", err)
+		}
+	})
+	c.Start()
+	_self.cleanCron = c
+}
+
+// Stop stops the crons and flushes the clients reported since the last run.
+func (_self *ClientService) Stop() {
+	if _self.flushCron != nil {
+		_self.flushCron.Stop()
+	}
+	if _self.cleanCron != nil {
+		_self.cleanCron.Stop()
+	}
+	_self.flush()
+}
+
+func (_self *ClientService) flush() {
+	// swap pending clients
+	_self.lock.Lock()
+	clientMap := _self.clientMap
+	_self.clientMap = make(map[string]*dao.ClientData)
+	_self.lock.Unlock()
+
+	unavailable := false
+	for key, client := range clientMap {
+		if !unavailable {
+			_, err := _self.clientDao.UpsertClient(context.Background(), client)
+			if err == nil {
+				continue
+			}
+			logger.Error("client: flush client error", "app_id", client.AppId, "instance_id", client.InstanceId, "error", err)
+			unavailable = errors.Is(err, common.ErrUnavailable)
+		}
+
+		// keep it for the next run unless the client reported again meanwhile
+		_self.lock.Lock()
+		if _, ok := _self.clientMap[key]; !ok {
+			_self.clientMap[key] = client
+		}
+		_self.lock.Unlock()
+	}
+}
+
+func (_self *ClientService) clientKey(appId int64, instanceId string) string {
+	return fmt.Sprintf("%d_%s", appId, instanceId)
+}
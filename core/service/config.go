@@ -1,230 +1,896 @@
 package service
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/robfig/cron"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+	"varconf-server/core/moudle/logger"
 	"varconf-server/core/moudle/poll"
 )
 
+const (
+	// the release cron is stale once it missed this many runs
+	cronMissedRuns = 3
+	// a release loaded for the cache is shared, it doesn't stop with the caller
+	snapshotLoadTimeout = 10 * time.Second
+)
+
 type ConfigService struct {
 	appDao        *dao.AppDao
+	appLinkDao    *dao.AppLinkDao
 	configDao     *dao.ConfigDao
 	releaseDao    *dao.ReleaseDao
 	releaseLogDao *dao.ReleaseLogDao
 	manageTxDao   *dao.ManageTxDao
 	messagePoll   *poll.MessagePoll
-	lastIndexMap  map[string]int
+	releaseBus    bus.Bus
+	releaseCache  *cache.LruCache
+	eventHub      *EventHub
+	cronLock      sync.Mutex
+	releaseCron   *cron.Cron
+	cronSchedule  cron.Schedule
+	cronRunTime   time.Time
+	refreshLock   sync.Mutex
+	refreshTasks  []*refreshTask
+	refreshChan   chan struct{}
+	refreshDone   chan struct{}
+	refreshClosed bool
+}
+
+// refreshTask re-merges the namespaces into the release of app, or into the
+// releases of the apps linking app.
+type refreshTask struct {
+	appId     int64
+	consumers bool
+	user      string
+}
+
+type NamespaceConsumer struct {
+	App          *dao.AppData `json:"app"`
+	OverrideKeys []string     `json:"overrideKeys"`
+	Consumed     bool         `json:"consumed"`
+}
+
+type releaseSnapshot struct {
+	configList   []dao.ConfigData
+	rawValues    map[string]string
+	templates    map[string]bool
+	flags        map[string]*Flag
+	releaseIndex int
 }
 
-func NewConfigService(db *sql.DB) *ConfigService {
+func NewConfigService(db *common.DB, releaseBus bus.Bus, releaseCache *cache.LruCache, eventHub *EventHub) *ConfigService {
 	configService := ConfigService{
 		appDao:        dao.NewAppDao(db),
+		appLinkDao:    dao.NewAppLinkDao(db),
 		configDao:     dao.NewConfigDao(db),
 		releaseDao:    dao.NewReleaseDao(db),
 		releaseLogDao: dao.NewReleaseLogDao(db),
 		manageTxDao:   dao.NewManageTxDao(db),
 		messagePoll:   poll.NewMessagePoll(),
-		lastIndexMap:  make(map[string]int),
+		releaseBus:    releaseBus,
+		releaseCache:  releaseCache,
+		eventHub:      eventHub,
+		refreshChan:   make(chan struct{}, 1),
+		refreshDone:   make(chan struct{}),
 	}
+	go configService.refreshWork()
+	releaseBus.Subscribe(func(event *bus.Event) {
+		configService.releaseCache.Remove(event.AppId)
+		configService.pushRelease(event.AppId, event.Keys, event.ReleaseIndex)
+	})
 	return &configService
 }
 
-func (_self *ConfigService) PageQuery(appId int64, likeKey string, pageIndex, pageSize int64) ([]*dao.ConfigData, int64, int64) {
+func (_self *ConfigService) PageQuery(ctx context.Context, appId int64, likeKey string, pageIndex, pageSize int64) ([]*dao.ConfigData, int64, int64, error) {
 	start := (pageIndex - 1) * pageSize
 	end := pageSize
 
-	pageData := _self.configDao.QueryConfigs(dao.QueryConfigData{AppId: appId, LikeKey: likeKey, Start: start, End: end})
-	totalCount := _self.configDao.CountConfigs(dao.QueryConfigData{AppId: appId, LikeKey: likeKey})
+	pageData, err := _self.configDao.QueryConfigs(ctx, dao.QueryConfigData{AppId: appId, LikeKey: likeKey, Start: start, End: end})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	totalCount, err := _self.configDao.CountConfigs(ctx, dao.QueryConfigData{AppId: appId, LikeKey: likeKey})
+	if err != nil {
+		return nil, 0, 0, err
+	}
 	pageCount := totalCount / pageSize
 	if totalCount%pageSize != 0 {
 		pageCount += 1
 	}
-	return pageData, pageCount, totalCount
+	return pageData, pageCount, totalCount, nil
 }
 
-func (_self *ConfigService) QueryConfig(appId, configId int64) *dao.ConfigData {
-	configs := _self.configDao.QueryConfigs(dao.QueryConfigData{AppId: appId, ConfigId: configId})
+func (_self *ConfigService) QueryConfig(ctx context.Context, appId, configId int64) (*dao.ConfigData, error) {
+	configs, err := _self.configDao.QueryConfigs(ctx, dao.QueryConfigData{AppId: appId, ConfigId: configId})
+	if err != nil {
+		return nil, err
+	}
 	if len(configs) != 1 {
-		return nil
+		return nil, common.NotFound("config")
+	}
+	return configs[0], nil
+}
+
+// ValidateConfig checks the flag document or the references of the template in the value of a
+// new or updated config, fields are the ones an update sets and nil for a new config.
+func (_self *ConfigService) ValidateConfig(ctx context.Context, data dao.ConfigData, fields common.Fields) error {
+	appId, key, value := data.AppId, data.Key, data.Value
+	oldKey := ""
+	if data.ConfigId != 0 {
+		config, err := _self.QueryConfig(ctx, appId, data.ConfigId)
+		if err != nil {
+			return err
+		}
+		oldKey = config.Key
+		if !fields.Has("key") {
+			key = config.Key
+		}
+		if !fields.Has("value") {
+			value = config.Value
+		}
+		if !fields.Has("type") {
+			data.Type = config.Type
+		}
+	} else if data.Type == 0 {
+		data.Type = dao.TYPE_TEXT
+	}
+	if data.Type != dao.TYPE_TEXT && data.Type != dao.TYPE_FLAG && data.Type != dao.TYPE_TEMPLATE {
+		return fmt.Errorf("unknown config type %d", data.Type)
 	}
-	return configs[0]
+	if key == "" {
+		return errors.New("key is empty")
+	}
+
+	switch data.Type {
+	case dao.TYPE_FLAG:
+		_, err := ParseFlag(value)
+		return err
+	case dao.TYPE_TEMPLATE:
+		values, templates, err := _self.pendingValues(ctx, appId, oldKey)
+		if err != nil {
+			return err
+		}
+		values[key] = value
+		templates[key] = true
+		return validateReferences(values, templates, key)
+	}
+	return nil
 }
 
-func (_self *ConfigService) CreateConfig(data *dao.ConfigData) bool {
+func (_self *ConfigService) CreateConfig(ctx context.Context, data *dao.ConfigData) error {
+	if data.Type == 0 {
+		data.Type = dao.TYPE_TEXT
+	}
 	data.Operate = dao.OPERATE_NEW
 	data.Status = dao.STATUS_UN
+	data.Version = 1
 	data.CreateTime.Time = time.Now()
 	data.UpdateTime.Time = time.Now()
 
-	rowCnt := _self.configDao.InsertConfig(data)
-	if rowCnt != 1 {
-		return false
+	_, err := _self.configDao.InsertConfig(ctx, data)
+	if err != nil {
+		return err
 	}
 
-	return true
+	_self.publishEdit(data.AppId, data.ConfigId, data.Key, data.Operate, data.CreateBy)
+	return nil
 }
 
-func (_self *ConfigService) UpdateConfig(data dao.ConfigData) bool {
+// UpdateConfig updates the key, value, type and desc among fields when
+// data.Version is still current, a stale version fails with a StaleError
+// carrying the current config.
+func (_self *ConfigService) UpdateConfig(ctx context.Context, data dao.ConfigData, fields common.Fields) error {
 	data.Operate = dao.OPERATE_UPDATE
 	data.Status = dao.STATUS_UN
 	data.UpdateTime.Time = time.Now()
+	fields = fields.Only("key", "value", "type", "desc").With("operate", "status", "updateTime", "updateBy")
 
-	rowCnt := _self.configDao.SelectedUpdateConfig(data)
+	rowCnt, err := _self.configDao.SelectedUpdateConfig(ctx, data, fields)
+	if err != nil {
+		return err
+	}
 	if rowCnt != 1 {
-		return false
+		current, err := _self.QueryConfig(ctx, data.AppId, data.ConfigId)
+		if err != nil {
+			return err
+		}
+		return common.Stale(current)
 	}
 
-	return true
+	_self.publishEdit(data.AppId, data.ConfigId, data.Key, data.Operate, data.UpdateBy)
+	return nil
 }
 
-func (_self *ConfigService) DeleteConfig(data dao.ConfigData) bool {
+// DeleteConfig marks config deleted, it fails with ErrConflict while a template
+// of the app, or of an app linking it, still references the key.
+func (_self *ConfigService) DeleteConfig(ctx context.Context, data dao.ConfigData) error {
+	config, err := _self.QueryConfig(ctx, data.AppId, data.ConfigId)
+	if err != nil {
+		return err
+	}
+	referrers, err := _self.queryReferrers(ctx, data.AppId, config.Key)
+	if err != nil {
+		return err
+	}
+	if len(referrers) > 0 {
+		return common.Conflict(fmt.Sprintf("%s is referenced by %s", config.Key, strings.Join(referrers, ", ")))
+	}
+
 	data.Operate = dao.OPERATE_DELETE
 	data.Status = dao.STATUS_UN
 	data.UpdateTime.Time = time.Now()
+	fields := common.NewFields("operate", "status", "updateTime", "updateBy")
 
-	rowCnt := _self.configDao.SelectedUpdateConfig(data)
+	rowCnt, err := _self.configDao.SelectedUpdateConfig(ctx, data, fields)
+	if err != nil {
+		return err
+	}
 	if rowCnt != 1 {
-		return false
+		return common.NotFound("config")
 	}
+	data.Key = config.Key
 
-	return true
+	_self.publishEdit(data.AppId, data.ConfigId, data.Key, data.Operate, data.UpdateBy)
+	return nil
 }
 
-func (_self *ConfigService) ReleaseConfig(appId int64, user string) bool {
-	// query all config
-	configs := _self.configDao.QueryConfigs(dao.QueryConfigData{AppId: appId})
-	if len(configs) < 1 {
-		return false
+// ReleaseConfig releases the pending configs of app, it fails with ErrConflict
+// when another release of app commits first.
+func (_self *ConfigService) ReleaseConfig(ctx context.Context, appId int64, user string) error {
+	// the release index this release is based on
+	appData, err := _self.appDao.QueryApp(ctx, appId)
+	if err != nil {
+		return err
 	}
 
-	// parse allConfigs and update config status
-	success, keys := _self.manageTxDao.ReleaseConfig(appId, configs, user)
-	if !success {
-		return false
+	// read the configs, update their status and save the snapshot in one tx
+	keys, releaseIndex, err := _self.manageTxDao.ReleaseConfig(ctx, appId, appData.ReleaseIndex, user)
+	if err != nil {
+		return err
 	}
 
 	// push message
-	_self.pushRelease(appId, keys)
-	return true
+	_self.notifyRelease(appId, keys, releaseIndex)
+	_self.eventHub.Publish(&AppEvent{Type: EVENT_RELEASE, AppId: appId, Keys: keys, ReleaseIndex: releaseIndex, Operator: user})
+
+	// propagate to the apps linking this namespace
+	_self.queueRefresh(&refreshTask{appId: appId, consumers: true, user: user})
+	return nil
 }
 
-func (_self *ConfigService) QueryRelease(appId int64) ([]dao.ConfigData, int) {
-	releaseData := _self.releaseDao.QueryRelease(appId)
-	if releaseData == nil {
-		return nil, 0
+// RefreshRelease re-merges the linked namespaces into the released snapshot of app.
+func (_self *ConfigService) RefreshRelease(ctx context.Context, appId int64, user string) error {
+	keys, releaseIndex, err := _self.manageTxDao.RefreshRelease(ctx, appId, user)
+	if err != nil {
+		return err
 	}
 
-	configList := make([]dao.ConfigData, 0)
-	if err := json.Unmarshal([]byte(releaseData.ConfigList), &configList); err != nil {
-		return nil, 0
+	if len(keys) > 0 {
+		_self.notifyRelease(appId, keys, releaseIndex)
+		_self.eventHub.Publish(&AppEvent{Type: EVENT_RELEASE, AppId: appId, Keys: keys, ReleaseIndex: releaseIndex, Operator: user, Refresh: true})
+	}
+	return nil
+}
+
+// RefreshReleases refreshes every app of appIds in the background, a failed
+// one is logged and skipped.
+func (_self *ConfigService) RefreshReleases(appIds []int64, user string) {
+	for _, appId := range appIds {
+		_self.queueRefresh(&refreshTask{appId: appId, user: user})
+	}
+}
+
+func (_self *ConfigService) LinkApp(ctx context.Context, appId, linkAppId int64, user string) error {
+	if appId == linkAppId {
+		return errors.New("app can't link itself")
+	}
+
+	// check the namespace is public
+	linkApp, err := _self.appDao.QueryApp(ctx, linkAppId)
+	if err != nil {
+		return err
+	}
+	if linkApp.Public != dao.APP_PUBLIC {
+		return errors.New("app is not a public namespace")
+	}
+	_, err = _self.appDao.QueryApp(ctx, appId)
+	if err != nil {
+		return err
+	}
+	appLinks, err := _self.appLinkDao.QueryAppLinks(ctx, appId)
+	if err != nil {
+		return err
+	}
+	for _, appLink := range appLinks {
+		if appLink.LinkAppId == linkAppId {
+			return common.Conflict("namespace is linked already")
+		}
+	}
+
+	appLink := &dao.AppLinkData{AppId: appId, LinkAppId: linkAppId, CreateTime: common.NowJsonTime(), CreateBy: user}
+	_, err = _self.appLinkDao.InsertAppLink(ctx, appLink)
+	if err != nil {
+		return err
+	}
+	return _self.RefreshRelease(ctx, appId, user)
+}
+
+func (_self *ConfigService) UnlinkApp(ctx context.Context, appId, linkAppId int64, user string) error {
+	rowCnt, err := _self.appLinkDao.DeleteAppLink(ctx, appId, linkAppId)
+	if err != nil {
+		return err
+	}
+	if rowCnt != 1 {
+		return common.NotFound("app link")
 	}
-	return configList, releaseData.ReleaseIndex
+	return _self.RefreshRelease(ctx, appId, user)
 }
 
+// QueryLinks returns the namespaces linked by app.
+func (_self *ConfigService) QueryLinks(ctx context.Context, appId int64) ([]*dao.AppData, error) {
+	appLinks, err := _self.appLinkDao.QueryAppLinks(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := make([]*dao.AppData, 0)
+	for _, appLink := range appLinks {
+		linkApp, err := _self.appDao.QueryApp(ctx, appLink.LinkAppId)
+		if errors.Is(err, common.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, linkApp)
+	}
+	return apps, nil
+}
+
+func (_self *ConfigService) QueryConsumerIds(ctx context.Context, appId int64) ([]int64, error) {
+	appLinks, err := _self.appLinkDao.QueryLinkedApps(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+
+	appIds := make([]int64, 0)
+	for _, appLink := range appLinks {
+		appIds = append(appIds, appLink.AppId)
+	}
+	return appIds, nil
+}
+
+// QueryConsumers returns the apps linking the namespace, with the namespace keys they
+// override. If key is given, Consumed tells whether the app reads the shared value.
+func (_self *ConfigService) QueryConsumers(ctx context.Context, appId int64, key string) ([]*NamespaceConsumer, error) {
+	keySet := make(map[string]bool)
+	configList, _, err := _self.QueryRelease(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range configList {
+		if config.AppId == appId {
+			keySet[config.Key] = true
+		}
+	}
+
+	consumerIds, err := _self.QueryConsumerIds(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+	consumers := make([]*NamespaceConsumer, 0)
+	for _, consumerId := range consumerIds {
+		consumerApp, err := _self.appDao.QueryApp(ctx, consumerId)
+		if errors.Is(err, common.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		overrideKeys := make([]string, 0)
+		configList, _, err := _self.QueryRelease(ctx, consumerId)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configList {
+			if config.AppId == consumerId && keySet[config.Key] {
+				overrideKeys = append(overrideKeys, config.Key)
+			}
+		}
+
+		consumer := &NamespaceConsumer{App: consumerApp, OverrideKeys: overrideKeys}
+		if key != "" && keySet[key] {
+			consumer.Consumed = true
+			for _, overrideKey := range overrideKeys {
+				if overrideKey == key {
+					consumer.Consumed = false
+					break
+				}
+			}
+		}
+		consumers = append(consumers, consumer)
+	}
+	return consumers, nil
+}
+
+// QueryRelease returns the released snapshot of app with references resolved,
+// which is shared by callers and must not be modified. An app never released
+// has no configs and index 0.
+func (_self *ConfigService) QueryRelease(ctx context.Context, appId int64) ([]dao.ConfigData, int, error) {
+	snapshot, err := _self.querySnapshot(ctx, appId)
+	if snapshot == nil || err != nil {
+		return nil, 0, err
+	}
+	return snapshot.configList, snapshot.releaseIndex, nil
+}
+
+// EvaluateFlag evaluates the released flag key of app against the context attributes.
+func (_self *ConfigService) EvaluateFlag(ctx context.Context, appId int64, key string, attributes map[string]string) (*FlagResult, int, error) {
+	snapshot, err := _self.querySnapshot(ctx, appId)
+	if snapshot == nil || err != nil {
+		return nil, 0, err
+	}
+	for _, config := range snapshot.configList {
+		if config.Key != key {
+			continue
+		}
+		if config.Type != dao.TYPE_FLAG {
+			return nil, 0, fmt.Errorf("%s is not a flag", key)
+		}
+
+		// the flag is evaluated from its raw document, parsed once per snapshot
+		flag := snapshot.flags[key]
+		if flag == nil {
+			_, err := ParseFlag(snapshot.rawValues[key])
+			return nil, 0, err
+		}
+		return flag.Evaluate(key, attributes), snapshot.releaseIndex, nil
+	}
+	return nil, 0, nil
+}
+
+func (_self *ConfigService) CacheStats() cache.Stats {
+	return _self.releaseCache.Stats()
+}
+
+// PollWaiters returns how many long polls are hanging on each app.
+func (_self *ConfigService) PollWaiters() map[int64]int {
+	waiters := make(map[int64]int)
+	for key, count := range _self.messagePoll.Counts() {
+		if appId, ok := _self.parsePollKey(key); ok {
+			waiters[appId] += count
+		}
+	}
+	return waiters
+}
+
+// CronRelease checks the releases of the parked long polls by spec, see
+// CronHealth for when it's reported stale.
 func (_self *ConfigService) CronRelease(spec string) {
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		logger.Error("config: parse cron error", "spec", spec, "error", err)
+		return
+	}
+
 	c := cron.New()
-	c.AddFunc(spec, func() {
-		// query keys
-		keys := _self.messagePoll.Keys()
-		if keys == nil || len(keys) == 0 {
+	c.Schedule(schedule, cron.FuncJob(func() {
+		if err := _self.wakeStale(); err != nil {
+			logger.Error("config: query releases error", "error", err)
 			return
 		}
+		_self.cronLock.Lock()
+		_self.cronRunTime = time.Now()
+		_self.cronLock.Unlock()
+	}))
+	c.Start()
+
+	_self.cronLock.Lock()
+	_self.releaseCron = c
+	_self.cronSchedule = schedule
+	_self.cronRunTime = time.Now()
+	_self.cronLock.Unlock()
+}
+
+// wakeStale drops the snapshots released by other nodes and wakes the long
+// polls behind them.
+func (_self *ConfigService) wakeStale() error {
+	// query keys
+	keys := _self.messagePoll.Keys()
+	if keys == nil || len(keys) == 0 {
+		return nil
+	}
+
+	// parse appId
+	appIds := make([]int64, 0, len(keys))
+	appIdSet := make(map[int64]bool)
+	keyAppMap := make(map[string]int64)
+	for _, key := range keys {
+		appId, ok := _self.parsePollKey(key)
+		if !ok {
+			continue
+		}
+		if !appIdSet[appId] {
+			appIdSet[appId] = true
+			appIds = append(appIds, appId)
+		}
+		keyAppMap[key] = appId
+	}
+	if len(appIds) < 1 {
+		return nil
+	}
 
-		// parse appId and lastIndex
-		appIds := make([]int64, 0, len(keys))
-		appIndexMap := make(map[int64]int)
-		for _, key := range keys {
-			// parse lastIndex
-			lastIndex, exist := _self.lastIndexMap[key]
-			if !exist {
-				continue
+	// query release data
+	releases, err := _self.releaseDao.QueryReleases(context.Background(), appIds)
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		return nil
+	}
+	releaseIndexMap := make(map[int64]int)
+	for _, release := range releases {
+		releaseIndexMap[release.AppId] = release.ReleaseIndex
+
+		// drop the snapshot released by other nodes
+		if value, ok := _self.releaseCache.Get(release.AppId); ok {
+			if value.(*releaseSnapshot).releaseIndex != release.ReleaseIndex {
+				_self.releaseCache.Remove(release.AppId)
 			}
+		}
+	}
 
-			// parse appId
-			arrays := strings.Split(key, "_")
-			if len(arrays) != 2 && len(arrays) != 3 {
-				continue
+	// wake the waiters which are behind
+	for key, appId := range keyAppMap {
+		releaseIndex, exist := releaseIndexMap[appId]
+		if !exist {
+			continue
+		}
+		_self.messagePoll.PushStale(key, releaseIndex, appId)
+	}
+	return nil
+}
+
+// Drain answers the parked long polls, and those parked later, with poll.CLOSED.
+func (_self *ConfigService) Drain() int {
+	return _self.messagePoll.Close()
+}
+
+// Stop stops the cron and waits for the queued refreshes.
+func (_self *ConfigService) Stop() {
+	_self.cronLock.Lock()
+	if _self.releaseCron != nil {
+		_self.releaseCron.Stop()
+		_self.releaseCron = nil
+	}
+	_self.cronLock.Unlock()
+
+	_self.refreshLock.Lock()
+	if !_self.refreshClosed {
+		_self.refreshClosed = true
+		close(_self.refreshChan)
+	}
+	_self.refreshLock.Unlock()
+	<-_self.refreshDone
+}
+
+// CronHealth fails when the release cron is stopped, or hasn't succeeded
+// for cronMissedRuns runs in a row.
+func (_self *ConfigService) CronHealth() error {
+	_self.cronLock.Lock()
+	defer _self.cronLock.Unlock()
+
+	if _self.releaseCron == nil {
+		return errors.New("release cron is not running")
+	}
+	deadline := _self.cronRunTime
+	for i := 0; i < cronMissedRuns; i++ {
+		deadline = _self.cronSchedule.Next(deadline)
+	}
+	if time.Now().After(deadline) {
+		return fmt.Errorf("release cron hasn't succeeded since %s", _self.cronRunTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (_self *ConfigService) PullRelease(appId int64, key string, filter *KeyFilter, lastIndex int) (*poll.MessagePoll, *poll.Element) {
+	pollKey := fmt.Sprintf("app_%d", appId)
+	if key != "" {
+		pollKey = fmt.Sprintf("key_%d_%s", appId, key)
+	}
+
+	// long poll for config
+	if filter != nil {
+		return _self.messagePoll, _self.messagePoll.PollFilter(pollKey, lastIndex, filter.Accept)
+	}
+	return _self.messagePoll, _self.messagePoll.Poll(pollKey, lastIndex)
+}
+
+// querySnapshot returns the released snapshot of app, nil when it was never
+// released. A failed load isn't cached, its error is handed to the callers
+// sharing it. The load runs apart from ctx, a caller giving up doesn't fail
+// the others.
+func (_self *ConfigService) querySnapshot(ctx context.Context, appId int64) (*releaseSnapshot, error) {
+	value, ok, err := _self.releaseCache.Load(ctx, appId, func() (interface{}, bool) {
+		loadCtx, cancel := context.WithTimeout(context.Background(), snapshotLoadTimeout)
+		defer cancel()
+		releaseData, err := _self.releaseDao.QueryRelease(loadCtx, appId)
+		if errors.Is(err, common.ErrNotFound) {
+			return nil, false
+		}
+		if err != nil {
+			return err, false
+		}
+
+		configList := make([]dao.ConfigData, 0)
+		if err := json.Unmarshal([]byte(releaseData.ConfigList), &configList); err != nil {
+			return err, false
+		}
+
+		// resolve the references of the templates, the others are served raw
+		rawValues := make(map[string]string)
+		templates := make(map[string]bool)
+		flags := make(map[string]*Flag)
+		for _, config := range configList {
+			rawValues[config.Key] = config.Value
+			templates[config.Key] = config.Type == dao.TYPE_TEMPLATE
+			if config.Type == dao.TYPE_FLAG {
+				if flag, err := ParseFlag(config.Value); err == nil {
+					flags[config.Key] = flag
+				}
 			}
-			appId, err := strconv.ParseInt(arrays[1], 10, 64)
-			if err != nil {
-				continue
+		}
+		values, _ := interpolateValues(rawValues, templates, false)
+		for i := range configList {
+			if templates[configList[i].Key] {
+				configList[i].Value = values[configList[i].Key]
 			}
+		}
+		snapshot := &releaseSnapshot{
+			configList:   configList,
+			rawValues:    rawValues,
+			templates:    templates,
+			flags:        flags,
+			releaseIndex: releaseData.ReleaseIndex,
+		}
+		return snapshot, true
+	})
+	if err != nil {
+		// the caller stopped waiting, the load goes on for the others
+		return nil, &common.Error{Kind: common.ErrCanceled, Err: err}
+	}
+	if !ok {
+		err, _ := value.(error)
+		return nil, err
+	}
+	return value.(*releaseSnapshot), nil
+}
 
-			appIds = append(appIds, appId)
-			appIndexMap[appId] = lastIndex
+// pendingValues returns the values and the templates app would release now, its
+// own pending configs over the released ones of its linked namespaces. The own
+// config of skipKey is left out.
+func (_self *ConfigService) pendingValues(ctx context.Context, appId int64, skipKey string) (map[string]string, map[string]bool, error) {
+	values := make(map[string]string)
+	templates := make(map[string]bool)
+	snapshot, err := _self.querySnapshot(ctx, appId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if snapshot != nil {
+		for _, config := range snapshot.configList {
+			if config.AppId != appId {
+				values[config.Key] = snapshot.rawValues[config.Key]
+				templates[config.Key] = snapshot.templates[config.Key]
+			}
 		}
-		if len(appIds) < 1 {
-			return
+	}
+
+	configs, err := _self.configDao.QueryConfigs(ctx, dao.QueryConfigData{AppId: appId})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, config := range configs {
+		if config.Key == skipKey {
+			continue
 		}
+		if config.Operate == dao.OPERATE_DELETE && config.Status == dao.STATUS_UN {
+			delete(values, config.Key)
+			delete(templates, config.Key)
+			continue
+		}
+		values[config.Key] = config.Value
+		templates[config.Key] = config.Type == dao.TYPE_TEMPLATE
+	}
+	return values, templates, nil
+}
 
-		// query release data
-		releases := _self.releaseDao.QueryReleases(appIds)
-		if releases == nil || len(releases) == 0 {
+// queryReferrers returns the templates left without key if app deleted its own
+// config of key, the pending ones of app and the released ones of the apps
+// linking it, the latter as <code>:<key>.
+func (_self *ConfigService) queryReferrers(ctx context.Context, appId int64, key string) ([]string, error) {
+	values, templates, err := _self.pendingValues(ctx, appId, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, exist := values[key]; exist {
+		// a linked namespace still defines it
+		return nil, nil
+	}
+	referrers := referringKeys(values, templates, key)
+
+	consumerIds, err := _self.QueryConsumerIds(ctx, appId)
+	if err != nil {
+		return nil, err
+	}
+	for _, consumerId := range consumerIds {
+		snapshot, err := _self.querySnapshot(ctx, consumerId)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot == nil {
+			continue
+		}
+		consumed := false
+		for _, config := range snapshot.configList {
+			if config.Key == key {
+				consumed = config.AppId == appId
+				break
+			}
+		}
+		if !consumed {
+			continue
+		}
+		consumerApp, err := _self.appDao.QueryApp(ctx, consumerId)
+		if errors.Is(err, common.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, referrer := range referringKeys(snapshot.rawValues, snapshot.templates, key) {
+			referrers = append(referrers, consumerApp.Code+":"+referrer)
+		}
+	}
+	return referrers, nil
+}
+
+// publishEdit publishes an edit which is committed, the key is looked up apart
+// from the request so a client leaving doesn't drop the event.
+func (_self *ConfigService) publishEdit(appId, configId int64, key string, operate int, user string) {
+	if key == "" {
+		config, err := _self.QueryConfig(context.Background(), appId, configId)
+		if err != nil {
 			return
 		}
+		key = config.Key
+	}
+	_self.eventHub.Publish(&AppEvent{Type: EVENT_CONFIG_EDIT, AppId: appId, Keys: []string{key}, Operate: operate, Operator: user})
+}
 
-		// parse release data
-		for _, release := range releases {
-			// check app is have been released
-			lastIndex := appIndexMap[release.AppId]
-			if lastIndex == release.ReleaseIndex {
-				continue
-			}
+// queueRefresh hands task to the refresh worker, so a release isn't held up
+// by the apps linking it.
+func (_self *ConfigService) queueRefresh(task *refreshTask) {
+	_self.refreshLock.Lock()
+	defer _self.refreshLock.Unlock()
 
-			// parse config data
-			configList := make([]dao.ConfigData, 0)
-			if err := json.Unmarshal([]byte(release.ConfigList), &configList); err != nil {
-				continue
-			}
-			if configList == nil || len(configList) == 0 {
-				continue
+	if _self.refreshClosed {
+		logger.Warn("config: stopped, drop refresh", "app_id", task.appId)
+		return
+	}
+	_self.refreshTasks = append(_self.refreshTasks, task)
+	select {
+	case _self.refreshChan <- struct{}{}:
+	default:
+	}
+}
+
+func (_self *ConfigService) refreshWork() {
+	defer close(_self.refreshDone)
+
+	for range _self.refreshChan {
+		for {
+			_self.refreshLock.Lock()
+			tasks := _self.refreshTasks
+			_self.refreshTasks = nil
+			_self.refreshLock.Unlock()
+			if len(tasks) == 0 {
+				break
 			}
 
-			// parse key and push data
-			appKeys := make([]string, 0, len(configList))
-			for _, config := range configList {
-				appKeys = append(appKeys, config.Key)
+			for _, task := range tasks {
+				_self.refresh(task)
 			}
-			_self.pushRelease(release.AppId, appKeys)
 		}
-	})
-	c.Start()
+	}
 }
 
-func (_self *ConfigService) PullRelease(appId int64, key string, lastIndex int) (*poll.MessagePoll, *poll.Element) {
-	pollKey := fmt.Sprintf("app_%d", appId)
-	if key != "" {
-		pollKey = fmt.Sprintf("key_%d_%s", appId, key)
+// refresh runs task apart from the request which queued it.
+func (_self *ConfigService) refresh(task *refreshTask) {
+	ctx := context.Background()
+	appIds := []int64{task.appId}
+	if task.consumers {
+		consumerIds, err := _self.QueryConsumerIds(ctx, task.appId)
+		if err != nil {
+			logger.Error("config: query consumers error", "app_id", task.appId, "error", err)
+			return
+		}
+		appIds = consumerIds
 	}
 
-	// put key's lastIndex
-	_self.lastIndexMap[pollKey] = lastIndex
+	for _, appId := range appIds {
+		err := _self.RefreshRelease(ctx, appId, task.user)
+		if err != nil {
+			logger.Error("config: refresh release error", "app_id", appId, "error", err)
+		}
+	}
+}
 
-	// long poll for config
-	return _self.messagePoll, _self.messagePoll.Poll(pollKey)
+func (_self *ConfigService) notifyRelease(appId int64, keys []string, releaseIndex int) {
+	_self.releaseCache.Remove(appId)
+	_self.pushRelease(appId, keys, releaseIndex)
+	err := _self.releaseBus.Publish(&bus.Event{AppId: appId, Keys: keys, ReleaseIndex: releaseIndex})
+	if err != nil {
+		// the other nodes catch up with their release cron
+		logger.Error("config: publish release error", "app_id", appId, "release_index", releaseIndex, "error", err)
+	}
 }
 
-func (_self *ConfigService) pushRelease(appId int64, keys []string) {
+func (_self *ConfigService) pushRelease(appId int64, keys []string, releaseIndex int) {
+	// the keys referencing a changed key change too
+	if len(keys) > 0 {
+		if snapshot, _ := _self.querySnapshot(context.Background(), appId); snapshot != nil {
+			keys = dependentKeys(snapshot.rawValues, snapshot.templates, keys)
+		}
+	}
+
 	pollKey := fmt.Sprintf("app_%d", appId)
 	if _self.messagePoll.Contain(pollKey) {
-		_self.messagePoll.Push(pollKey, appId)
+		_self.messagePoll.PushIndex(pollKey, releaseIndex, keys)
 	}
 
 	if keys == nil {
 		return
 	}
 
+	changed := make(map[string]bool)
 	for _, key := range keys {
 		pollKey = fmt.Sprintf("key_%d_%s", appId, key)
+		changed[pollKey] = true
 		if _self.messagePoll.Contain(pollKey) {
 			_self.messagePoll.Push(pollKey, key)
 		}
 	}
+
+	// the waiters on the other keys are up to date, the cron leaves them parked
+	prefix := fmt.Sprintf("key_%d_", appId)
+	for _, pollKey := range _self.messagePoll.Keys() {
+		if strings.HasPrefix(pollKey, prefix) && !changed[pollKey] {
+			_self.messagePoll.Advance(pollKey, releaseIndex)
+		}
+	}
+}
+
+func (_self *ConfigService) parsePollKey(pollKey string) (int64, bool) {
+	arrays := strings.SplitN(pollKey, "_", 3)
+	if len(arrays) < 2 {
+		return 0, false
+	}
+	appId, err := strconv.ParseInt(arrays[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return appId, true
 }
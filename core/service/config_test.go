@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+)
+
+// TestQuerySnapshotCanceled shares the load of a release between a caller
+// giving up and one waiting for it.
+func TestQuerySnapshotCanceled(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	ctx := context.Background()
+
+	releaseCache := cache.NewLruCache(16, time.Minute)
+	configService := NewConfigService(db, bus.NewLocalBus(), releaseCache, NewEventHub())
+	defer configService.Stop()
+	app := &dao.AppData{Name: "a", Code: "a", ApiKey: "key-a", Public: dao.APP_PRIVATE, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	if _, err := dao.NewAppDao(db).InsertApp(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	config := &dao.ConfigData{AppId: app.AppId, Key: "k", Value: "v", CreateBy: "alice", UpdateBy: "alice"}
+	if err := configService.CreateConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := configService.ReleaseConfig(ctx, app.AppId, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	releaseCache.Remove(app.AppId)
+
+	// the load waits for the only connection
+	db.SetMaxOpenConns(1)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	misses := releaseCache.Stats().Misses
+	canceledCtx, cancel := context.WithCancel(ctx)
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := configService.querySnapshot(canceledCtx, app.AppId)
+		canceled <- err
+	}()
+	for releaseCache.Stats().Misses == misses {
+		time.Sleep(time.Millisecond)
+	}
+	type result struct {
+		snapshot *releaseSnapshot
+		err      error
+	}
+	waiting := make(chan result, 1)
+	go func() {
+		snapshot, err := configService.querySnapshot(ctx, app.AppId)
+		waiting <- result{snapshot, err}
+	}()
+	for releaseCache.Stats().Misses != misses+2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-canceled; !errors.Is(err, common.ErrCanceled) {
+		t.Fatalf("canceled caller got %v", err)
+	}
+	conn.Close()
+	got := <-waiting
+	if got.err != nil {
+		t.Fatalf("waiting caller failed with %v", got.err)
+	}
+	if got.snapshot.releaseIndex != 1 || got.snapshot.rawValues["k"] != "v" {
+		t.Fatalf("got release %d with %v", got.snapshot.releaseIndex, got.snapshot.rawValues)
+	}
+	if stats := releaseCache.Stats(); stats.Misses != misses+2 || stats.Size != 1 {
+		t.Fatalf("got %d misses at size %d, want one shared load", stats.Misses-misses, stats.Size)
+	}
+}
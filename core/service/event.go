@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/moudle/logger"
+)
+
+const (
+	EVENT_CONFIG_EDIT = "config.edit"
+	EVENT_RELEASE     = "release"
+	EVENT_APP_DELETE  = "app.delete"
+)
+
+// AppEvent is what happened to an app, as seen by webhooks and other notifications.
+type AppEvent struct {
+	Type         string       `json:"event"`
+	App          *dao.AppData `json:"-"`
+	AppId        int64        `json:"appId"`
+	Keys         []string     `json:"keys"`
+	Operate      int          `json:"operate,omitempty"`
+	ReleaseIndex int          `json:"releaseIndex,omitempty"`
+	Refresh      bool         `json:"refresh,omitempty"` // a release re-merging the linked namespaces
+	Operator     string       `json:"operator"`
+	Time         time.Time    `json:"time"`
+}
+
+type EventListener func(event *AppEvent)
+
+// EventHub hands app events to the listeners synchronously, so listeners
+// should queue any slow work.
+type EventHub struct {
+	lock      sync.RWMutex
+	listeners []EventListener
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		listeners: make([]EventListener, 0),
+	}
+}
+
+func (_self *EventHub) Subscribe(listener EventListener) {
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	_self.listeners = append(_self.listeners, listener)
+}
+
+func (_self *EventHub) Publish(event *AppEvent) {
+	_self.lock.RLock()
+	listeners := _self.listeners
+	_self.lock.RUnlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, listener := range listeners {
+		_self.notify(listener, event)
+	}
+}
+
+func (_self *EventHub) notify(listener EventListener, event *AppEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("event: listener error", "event", event.Type, "app_id", event.AppId, "error", fmt.Sprint(err))
+		}
+	}()
+
+	listener(event)
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/logger"
+)
+
+const (
+	fallbackQueueSize = 256
+)
+
+// FallbackService keeps the last release of each app in a local file, so the
+// client api keeps answering while the database can't be reached. A release
+// is saved when this node or another one publishes it.
+type FallbackService struct {
+	appDao        *dao.AppDao
+	configService *ConfigService
+	dir           string
+	lock          sync.RWMutex
+	entries       map[int64]*fallbackEntry
+	saveChan      chan int64
+}
+
+// fallbackEntry is the file <dir>/<appId>.json, the api key is kept hashed.
+type fallbackEntry struct {
+	AppId        int64            `json:"appId"`
+	Name         string           `json:"name"`
+	Code         string           `json:"code"`
+	KeyHash      string           `json:"keyHash"`
+	ReleaseIndex int              `json:"releaseIndex"`
+	ConfigList   []dao.ConfigData `json:"configList"`
+	SaveTime     time.Time        `json:"saveTime"`
+}
+
+// NewFallbackService keeps the files under dir, it subscribes to releaseBus
+// after configService so the releases it reads aren't cached ones.
+func NewFallbackService(db *common.DB, eventHub *EventHub, releaseBus bus.Bus, configService *ConfigService, dir string) *FallbackService {
+	fallbackService := FallbackService{
+		appDao:        dao.NewAppDao(db),
+		configService: configService,
+		dir:           dir,
+		entries:       make(map[int64]*fallbackEntry),
+		saveChan:      make(chan int64, fallbackQueueSize),
+	}
+	fallbackService.load()
+	go fallbackService.work()
+	eventHub.Subscribe(fallbackService.dispatch)
+	releaseBus.Subscribe(func(event *bus.Event) {
+		fallbackService.queue(event.AppId)
+	})
+	return &fallbackService
+}
+
+// SaveAll saves the current release of every app, for those released while
+// this node wasn't running.
+func (_self *FallbackService) SaveAll(ctx context.Context) error {
+	apps, err := _self.appDao.QueryApps(ctx, dao.QueryAppData{})
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if err = _self.saveRelease(ctx, app); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save keeps the release of app unless the saved one is the same, the client
+// api calls it too so a changed api key is taken in.
+func (_self *FallbackService) Save(app *dao.AppData, configList []dao.ConfigData, releaseIndex int) {
+	if app.ApiKey == "" {
+		return
+	}
+	keyHash := _self.hashKey(app.ApiKey)
+
+	_self.lock.RLock()
+	entry := _self.entries[app.AppId]
+	_self.lock.RUnlock()
+	if entry != nil && entry.ReleaseIndex == releaseIndex && entry.KeyHash == keyHash &&
+		entry.Code == app.Code && entry.Name == app.Name {
+		return
+	}
+
+	entry = &fallbackEntry{
+		AppId:        app.AppId,
+		Name:         app.Name,
+		Code:         app.Code,
+		KeyHash:      keyHash,
+		ReleaseIndex: releaseIndex,
+		ConfigList:   configList,
+		SaveTime:     time.Now(),
+	}
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	if err := _self.write(entry); err != nil {
+		logger.Error("fallback: save release error", "app_id", app.AppId, "error", err)
+		return
+	}
+	_self.entries[app.AppId] = entry
+}
+
+// App returns the saved app of the api key token.
+func (_self *FallbackService) App(token string) (*dao.AppData, bool) {
+	keyHash := _self.hashKey(token)
+
+	_self.lock.RLock()
+	defer _self.lock.RUnlock()
+
+	for _, entry := range _self.entries {
+		if entry.KeyHash == keyHash {
+			return &dao.AppData{AppId: entry.AppId, Name: entry.Name, Code: entry.Code, ReleaseIndex: entry.ReleaseIndex}, true
+		}
+	}
+	return nil, false
+}
+
+// Release returns the saved release of app and when it was saved, it's shared
+// by callers and must not be modified.
+func (_self *FallbackService) Release(appId int64) ([]dao.ConfigData, int, time.Time, bool) {
+	_self.lock.RLock()
+	defer _self.lock.RUnlock()
+
+	entry := _self.entries[appId]
+	if entry == nil {
+		return nil, 0, time.Time{}, false
+	}
+	return entry.ConfigList, entry.ReleaseIndex, entry.SaveTime, true
+}
+
+func (_self *FallbackService) dispatch(event *AppEvent) {
+	if event.Type == EVENT_RELEASE {
+		_self.queue(event.AppId)
+		return
+	}
+	if event.Type != EVENT_APP_DELETE {
+		return
+	}
+
+	_self.lock.Lock()
+	defer _self.lock.Unlock()
+
+	delete(_self.entries, event.AppId)
+	err := os.Remove(_self.file(event.AppId))
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("fallback: remove release error", "app_id", event.AppId, "error", err)
+	}
+}
+
+func (_self *FallbackService) queue(appId int64) {
+	select {
+	case _self.saveChan <- appId:
+	default:
+		logger.Warn("fallback: queue is full, drop release", "app_id", appId)
+	}
+}
+
+func (_self *FallbackService) work() {
+	for appId := range _self.saveChan {
+		ctx := context.Background()
+		app, err := _self.appDao.QueryApp(ctx, appId)
+		if errors.Is(err, common.ErrNotFound) {
+			continue
+		}
+		if err == nil {
+			err = _self.saveRelease(ctx, app)
+		}
+		if err != nil {
+			logger.Error("fallback: query release error", "app_id", appId, "error", err)
+		}
+	}
+}
+
+// saveRelease saves the current release of app, an app never released has
+// nothing to save.
+func (_self *FallbackService) saveRelease(ctx context.Context, app *dao.AppData) error {
+	configList, releaseIndex, err := _self.configService.QueryRelease(ctx, app.AppId)
+	if err != nil || releaseIndex == 0 {
+		return err
+	}
+	_self.Save(app, configList, releaseIndex)
+	return nil
+}
+
+// HasFallback reports whether dir holds a release saved by a previous run, the
+// start checks it before serving without a database.
+func HasFallback(dir string) bool {
+	return len(readFallback(dir)) > 0
+}
+
+// load reads the releases saved by the previous runs.
+func (_self *FallbackService) load() {
+	_self.entries = readFallback(_self.dir)
+}
+
+// readFallback reads the releases saved in dir, a broken file is skipped.
+func readFallback(dir string) map[int64]*fallbackEntry {
+	entries := make(map[int64]*fallbackEntry)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("fallback: read dir error", "dir", dir, "error", err)
+		}
+		return entries
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		appId, err := strconv.ParseInt(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			logger.Error("fallback: read release error", "file", name, "error", err)
+			continue
+		}
+		entry := fallbackEntry{}
+		if err := json.Unmarshal(data, &entry); err != nil || entry.AppId != appId {
+			logger.Error("fallback: parse release error", "file", name, "error", err)
+			continue
+		}
+		entries[appId] = &entry
+	}
+	return entries
+}
+
+// write replaces the file of entry through a rename, a crash never leaves it half written.
+func (_self *FallbackService) write(entry *fallbackEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(_self.dir, 0700); err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(_self.dir, ".release-")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), _self.file(entry.AppId))
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+	return err
+}
+
+func (_self *FallbackService) file(appId int64) string {
+	return filepath.Join(_self.dir, strconv.FormatInt(appId, 10)+".json")
+}
+
+func (_self *FallbackService) hashKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+)
+
+// waitFallback waits for the saved release of app to reach releaseIndex.
+func waitFallback(t *testing.T, fallbackService *FallbackService, appId int64, releaseIndex int) []dao.ConfigData {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		configList, index, _, ok := fallbackService.Release(appId)
+		if ok && index == releaseIndex {
+			return configList
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("release %d of app %d wasn't saved", releaseIndex, appId)
+	return nil
+}
+
+func TestFallbackSave(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	dir, err := ioutil.TempDir("", "varconf-fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	eventHub := NewEventHub()
+	releaseBus := bus.NewLocalBus()
+	configService := NewConfigService(db, releaseBus, cache.NewLruCache(16, time.Minute), eventHub)
+	defer configService.Stop()
+	fallbackService := NewFallbackService(db, eventHub, releaseBus, configService, dir)
+
+	appDao := dao.NewAppDao(db)
+	released := &dao.AppData{Name: "a", Code: "a", ApiKey: "key-a", Public: dao.APP_PRIVATE, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	idle := &dao.AppData{Name: "b", Code: "b", ApiKey: "key-b", Public: dao.APP_PRIVATE, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	for _, app := range []*dao.AppData{released, idle} {
+		if _, err = appDao.InsertApp(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// saved on release, no client asked for it
+	config := &dao.ConfigData{AppId: released.AppId, Key: "k", Value: "v", CreateBy: "alice", UpdateBy: "alice"}
+	if err = configService.CreateConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	if err = configService.ReleaseConfig(ctx, released.AppId, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	configList := waitFallback(t, fallbackService, released.AppId, 1)
+	if len(configList) != 1 || configList[0].Value != "v" {
+		t.Fatalf("saved %v, want k=v", configList)
+	}
+	if app, ok := fallbackService.App("key-a"); !ok || app.AppId != released.AppId {
+		t.Fatal("api key of the released app isn't saved")
+	}
+
+	// the next run loads the files, an app never released has none
+	reloaded := NewFallbackService(db, NewEventHub(), bus.NewLocalBus(), configService, dir)
+	if err = reloaded.SaveAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitFallback(t, reloaded, released.AppId, 1)
+	if _, _, _, ok := reloaded.Release(idle.AppId); ok {
+		t.Fatal("saved a release of an app never released")
+	}
+}
+
+func TestHasFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "varconf-fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if HasFallback(dir + "/missing") {
+		t.Fatal("a missing dir has fallback releases")
+	}
+	for _, name := range []string{"notes.json", "12.json.tmp", "README", "7.json"} {
+		if err = ioutil.WriteFile(dir+"/"+name, []byte("{}"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if HasFallback(dir) {
+		t.Fatal("files of no app are fallback releases")
+	}
+	if err = ioutil.WriteFile(dir+"/12.json", []byte(`{"appId": 12, "releaseIndex": 3}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !HasFallback(dir) {
+		t.Fatal("the release of app 12 isn't found")
+	}
+}
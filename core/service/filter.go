@@ -0,0 +1,53 @@
+package service
+
+import (
+	"sort"
+	"strings"
+)
+
+// KeyFilter selects a subset of an app's config, by explicit keys or by key prefix.
+type KeyFilter struct {
+	Keys   []string
+	Prefix string
+}
+
+func NewKeyFilter(keys, prefix string) *KeyFilter {
+	filter := KeyFilter{Keys: make([]string, 0), Prefix: prefix}
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			filter.Keys = append(filter.Keys, key)
+		}
+	}
+	if len(filter.Keys) == 0 && filter.Prefix == "" {
+		return nil
+	}
+	sort.Strings(filter.Keys)
+	return &filter
+}
+
+func (_self *KeyFilter) Match(key string) bool {
+	if _self.Prefix != "" && strings.HasPrefix(key, _self.Prefix) {
+		return true
+	}
+	index := sort.SearchStrings(_self.Keys, key)
+	return index < len(_self.Keys) && _self.Keys[index] == key
+}
+
+// Accept reports whether any of the released keys is matched.
+func (_self *KeyFilter) Accept(data interface{}) bool {
+	keys, ok := data.([]string)
+	if !ok {
+		return true
+	}
+	for _, key := range keys {
+		if _self.Match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (_self *KeyFilter) String() string {
+	return strings.Join(_self.Keys, ",") + "|" + _self.Prefix
+}
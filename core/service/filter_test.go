@@ -0,0 +1,52 @@
+package service
+
+import "testing"
+
+func TestKeyFilter(t *testing.T) {
+	if NewKeyFilter(" , ", "") != nil {
+		t.Fatal("filter of no key and no prefix isn't nil")
+	}
+
+	tests := []struct {
+		name   string
+		keys   string
+		prefix string
+		match  map[string]bool
+	}{
+		{"keys", "db.port, db.host,,", "", map[string]bool{"db.host": true, "db.port": true, "db.user": false, "db": false}},
+		{"prefix", "", "kafka.", map[string]bool{"kafka.brokers": true, "kafka.": true, "kafka": false, "db.kafka.host": false}},
+		{"keys and prefix", "timeout", "db.", map[string]bool{"timeout": true, "db.host": true, "timeouts": false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := NewKeyFilter(test.keys, test.prefix)
+			for key, want := range test.match {
+				if filter.Match(key) != want {
+					t.Errorf("matched %s %v, want %v", key, !want, want)
+				}
+			}
+		})
+	}
+
+	// the parked polls of a filter only wake on a release of its keys
+	filter := NewKeyFilter("db.host", "kafka.")
+	for _, test := range []struct {
+		data   interface{}
+		accept bool
+	}{
+		{[]string{"timeout", "db.host"}, true},
+		{[]string{"kafka.acks"}, true},
+		{[]string{"timeout", "db.port"}, false},
+		{[]string{}, false},
+		{nil, true},
+	} {
+		if filter.Accept(test.data) != test.accept {
+			t.Errorf("accepted %v %v, want %v", test.data, !test.accept, test.accept)
+		}
+	}
+
+	// the same subset names the same etag scope whatever the order of the keys
+	if NewKeyFilter("b,a", "p").String() != NewKeyFilter("a, b", "p").String() {
+		t.Fatal("the order of the keys changes the scope")
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+const (
+	FLAG_OP_IN     = "in"
+	FLAG_OP_NOT_IN = "notIn"
+	FLAG_OP_PREFIX = "prefix"
+	FLAG_OP_REGEX  = "regex"
+)
+
+const (
+	defaultRolloutAttribute = "userId"
+)
+
+// Flag is the rule document stored as the value of a flag config.
+type Flag struct {
+	Enabled          bool                   `json:"enabled"`
+	Variations       map[string]interface{} `json:"variations"`
+	OffVariation     string                 `json:"offVariation"`
+	DefaultVariation string                 `json:"defaultVariation"`
+	Rules            []*FlagRule            `json:"rules"`
+	Rollout          *FlagRollout           `json:"rollout"`
+}
+
+// FlagRule serves Variation when the context attribute matches Values.
+type FlagRule struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values"`
+	Variation string   `json:"variation"`
+	patterns  []*regexp.Regexp
+}
+
+// FlagRollout splits the contexts matching no rule into weighted buckets by hashing Attribute.
+type FlagRollout struct {
+	Attribute string        `json:"attribute"`
+	Buckets   []*FlagBucket `json:"buckets"`
+}
+
+type FlagBucket struct {
+	Variation string `json:"variation"`
+	Weight    int    `json:"weight"`
+}
+
+type FlagResult struct {
+	Key       string      `json:"key"`
+	Variation string      `json:"variation"`
+	Value     interface{} `json:"value"`
+	Reason    string      `json:"reason"`
+}
+
+// ParseFlag decodes and validates a flag rule document, the regex rules are compiled once here.
+func ParseFlag(value string) (*Flag, error) {
+	flag := Flag{}
+	if err := json.Unmarshal([]byte(value), &flag); err != nil {
+		return nil, fmt.Errorf("invalid flag document: %s", err.Error())
+	}
+
+	if len(flag.Variations) == 0 {
+		return nil, errors.New("flag needs at least one variation")
+	}
+	if err := flag.checkVariation(flag.OffVariation, "offVariation"); err != nil {
+		return nil, err
+	}
+	if err := flag.checkVariation(flag.DefaultVariation, "defaultVariation"); err != nil {
+		return nil, err
+	}
+	for i, rule := range flag.Rules {
+		if err := flag.checkVariation(rule.Variation, fmt.Sprintf("rules[%d]", i)); err != nil {
+			return nil, err
+		}
+		if rule.Attribute == "" {
+			return nil, fmt.Errorf("rules[%d] needs an attribute", i)
+		}
+		switch rule.Operator {
+		case FLAG_OP_IN, FLAG_OP_NOT_IN, FLAG_OP_PREFIX:
+		case FLAG_OP_REGEX:
+			for _, value := range rule.Values {
+				pattern, err := regexp.Compile(value)
+				if err != nil {
+					return nil, fmt.Errorf("rules[%d] has invalid regex: %s", i, err.Error())
+				}
+				rule.patterns = append(rule.patterns, pattern)
+			}
+		default:
+			return nil, fmt.Errorf("rules[%d] has unknown operator %q", i, rule.Operator)
+		}
+	}
+	if flag.Rollout != nil {
+		total := 0
+		for i, bucket := range flag.Rollout.Buckets {
+			if err := flag.checkVariation(bucket.Variation, fmt.Sprintf("rollout.buckets[%d]", i)); err != nil {
+				return nil, err
+			}
+			if bucket.Weight < 0 {
+				return nil, fmt.Errorf("rollout.buckets[%d] has negative weight", i)
+			}
+			total += bucket.Weight
+		}
+		if total != 100 {
+			return nil, fmt.Errorf("rollout weights sum to %d, not 100", total)
+		}
+	}
+
+	return &flag, nil
+}
+
+// Evaluate picks the variation for the context attributes: the off variation when disabled,
+// then the first matching rule, then the rollout, then the default variation.
+func (_self *Flag) Evaluate(key string, attributes map[string]string) *FlagResult {
+	if !_self.Enabled {
+		return _self.result(key, _self.OffVariation, "off")
+	}
+
+	for i, rule := range _self.Rules {
+		if rule.match(attributes[rule.Attribute]) {
+			return _self.result(key, rule.Variation, fmt.Sprintf("rule:%d", i))
+		}
+	}
+
+	if _self.Rollout != nil {
+		attribute := _self.Rollout.Attribute
+		if attribute == "" {
+			attribute = defaultRolloutAttribute
+		}
+		if value, exist := attributes[attribute]; exist && value != "" {
+			bucket := _self.bucket(key, value)
+			for _, b := range _self.Rollout.Buckets {
+				if bucket < b.Weight {
+					return _self.result(key, b.Variation, "rollout")
+				}
+				bucket -= b.Weight
+			}
+		}
+	}
+
+	return _self.result(key, _self.DefaultVariation, "default")
+}
+
+func (_self *Flag) checkVariation(variation, field string) error {
+	if _, exist := _self.Variations[variation]; !exist {
+		return fmt.Errorf("%s refers to unknown variation %q", field, variation)
+	}
+	return nil
+}
+
+// bucket hashes the flag key and attribute value into [0, 100).
+func (_self *Flag) bucket(key, value string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key + ":" + value))
+	return int(hash.Sum32() % 100)
+}
+
+func (_self *Flag) result(key, variation, reason string) *FlagResult {
+	return &FlagResult{Key: key, Variation: variation, Value: _self.Variations[variation], Reason: reason}
+}
+
+func (_self *FlagRule) match(value string) bool {
+	switch _self.Operator {
+	case FLAG_OP_IN:
+		return _self.contains(value)
+	case FLAG_OP_NOT_IN:
+		return !_self.contains(value)
+	case FLAG_OP_PREFIX:
+		for _, v := range _self.Values {
+			if strings.HasPrefix(value, v) {
+				return true
+			}
+		}
+	case FLAG_OP_REGEX:
+		for _, pattern := range _self.patterns {
+			if pattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (_self *FlagRule) contains(value string) bool {
+	for _, v := range _self.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
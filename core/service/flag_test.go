@@ -0,0 +1,159 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testFlag = `{
+	"enabled": true,
+	"variations": {"on": true, "off": false, "beta": "beta"},
+	"offVariation": "off",
+	"defaultVariation": "off",
+	"rules": [
+		{"attribute": "userId", "operator": "in", "values": ["alice", "bob"], "variation": "on"},
+		{"attribute": "email", "operator": "regex", "values": ["@example\\.com$"], "variation": "beta"},
+		{"attribute": "region", "operator": "prefix", "values": ["eu-"], "variation": "off"},
+		{"attribute": "plan", "operator": "notIn", "values": ["free", ""], "variation": "on"}
+	],
+	"rollout": {"buckets": [{"variation": "on", "weight": 30}, {"variation": "off", "weight": 70}]}
+}`
+
+func TestParseFlag(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		err   string
+	}{
+		{"not json", `on`, "invalid flag document"},
+		{"no variation", `{"enabled": true}`, "at least one variation"},
+		{"unknown off variation", `{"variations": {"on": true}, "offVariation": "off", "defaultVariation": "on"}`, "offVariation refers to unknown variation"},
+		{"rule without attribute", `{"variations": {"on": true}, "offVariation": "on", "defaultVariation": "on",
+			"rules": [{"operator": "in", "variation": "on"}]}`, "rules[0] needs an attribute"},
+		{"unknown operator", `{"variations": {"on": true}, "offVariation": "on", "defaultVariation": "on",
+			"rules": [{"attribute": "a", "operator": "gt", "variation": "on"}]}`, "unknown operator"},
+		{"bad regex", `{"variations": {"on": true}, "offVariation": "on", "defaultVariation": "on",
+			"rules": [{"attribute": "a", "operator": "regex", "values": ["("], "variation": "on"}]}`, "invalid regex"},
+		{"weights under 100", `{"variations": {"on": true}, "offVariation": "on", "defaultVariation": "on",
+			"rollout": {"buckets": [{"variation": "on", "weight": 50}]}}`, "sum to 50"},
+		{"negative weight", `{"variations": {"on": true}, "offVariation": "on", "defaultVariation": "on",
+			"rollout": {"buckets": [{"variation": "on", "weight": 150}, {"variation": "on", "weight": -50}]}}`, "negative weight"},
+		{"valid", testFlag, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseFlag(test.value)
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("got %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	flag, err := ParseFlag(testFlag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		variation  string
+		reason     string
+	}{
+		{"allow list", map[string]string{"userId": "bob", "email": "bob@example.com"}, "on", "rule:0"},
+		{"regex", map[string]string{"userId": "carol", "email": "carol@example.com"}, "beta", "rule:1"},
+		{"prefix", map[string]string{"region": "eu-west"}, "off", "rule:2"},
+		{"not in", map[string]string{"plan": "pro"}, "on", "rule:3"},
+		{"no attribute to roll out", map[string]string{"plan": "free"}, "off", "default"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := flag.Evaluate("checkout", test.attributes)
+			if result.Variation != test.variation || result.Reason != test.reason {
+				t.Fatalf("got %s by %s, want %s by %s", result.Variation, result.Reason, test.variation, test.reason)
+			}
+			if result.Value != flag.Variations[test.variation] {
+				t.Fatalf("got value %v for %s", result.Value, result.Variation)
+			}
+		})
+	}
+
+	flag.Enabled = false
+	if result := flag.Evaluate("checkout", map[string]string{"userId": "alice"}); result.Variation != "off" || result.Reason != "off" {
+		t.Fatalf("disabled flag served %s by %s", result.Variation, result.Reason)
+	}
+}
+
+func TestEvaluateRollout(t *testing.T) {
+	flag, err := ParseFlag(`{"enabled": true, "variations": {"on": true, "off": false}, "offVariation": "off", "defaultVariation": "off",
+		"rollout": {"attribute": "tenant", "buckets": [{"variation": "on", "weight": 30}, {"variation": "off", "weight": 70}]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	on := 0
+	for i := 0; i < 10000; i++ {
+		tenant := fmt.Sprint("tenant-", i)
+		attributes := map[string]string{"tenant": tenant}
+		result := flag.Evaluate("checkout", attributes)
+		if result.Reason != "rollout" {
+			t.Fatalf("%s evaluated by %s", tenant, result.Reason)
+		}
+		// the same context always lands in the same bucket
+		for j := 0; j < 3; j++ {
+			if again := flag.Evaluate("checkout", attributes); again.Variation != result.Variation {
+				t.Fatalf("%s moved from %s to %s", tenant, result.Variation, again.Variation)
+			}
+		}
+		if result.Variation == "on" {
+			on++
+		}
+	}
+	if on < 2700 || on > 3300 {
+		t.Fatalf("%d of 10000 rolled out, want about 3000", on)
+	}
+
+	// each flag buckets the contexts apart from the others
+	moved := 0
+	for i := 0; i < 100; i++ {
+		tenant := fmt.Sprint("tenant-", i)
+		if flag.bucket("checkout", tenant) != flag.bucket("search", tenant) {
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Fatal("two flags bucket the contexts the same")
+	}
+
+	// the default attribute is userId
+	flag.Rollout.Attribute = ""
+	if result := flag.Evaluate("checkout", map[string]string{"tenant": "tenant-1"}); result.Reason != "default" {
+		t.Fatalf("rolled out without a userId, by %s", result.Reason)
+	}
+	if result := flag.Evaluate("checkout", map[string]string{"userId": "tenant-1"}); result.Reason != "rollout" {
+		t.Fatalf("userId isn't rolled out, by %s", result.Reason)
+	}
+}
+
+// TestBucketStable pins the bucket of a few contexts, a change of the hash
+// would move the users already rolled out.
+func TestBucketStable(t *testing.T) {
+	flag := &Flag{}
+	for _, test := range []struct {
+		key, value string
+		bucket     int
+	}{
+		{"checkout", "alice", 99},
+		{"checkout", "bob", 72},
+		{"search", "alice", 79},
+	} {
+		if bucket := flag.bucket(test.key, test.value); bucket != test.bucket {
+			t.Fatalf("%s:%s in bucket %d, want %d", test.key, test.value, bucket, test.bucket)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robfig/cron"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/git"
+	"varconf-server/core/moudle/logger"
+)
+
+const (
+	gitSyncQueueSize = 256
+)
+
+var gitBranchPattern = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._/-]*$`)
+
+type gitSyncTask struct {
+	appId        int64
+	releaseIndex int
+}
+
+// GitSyncService mirrors the releases of apps to git repositories and, in
+// GitOps mode, turns the commits made there into pending changes.
+type GitSyncService struct {
+	appDao        *dao.AppDao
+	configDao     *dao.ConfigDao
+	gitSyncDao    *dao.GitSyncDao
+	releaseDao    *dao.ReleaseDao
+	releaseLogDao *dao.ReleaseLogDao
+	configService *ConfigService
+	dir           string
+	gitLock       sync.Mutex
+	taskChan      chan *gitSyncTask
+	cronLock      sync.Mutex
+	pullCron      *cron.Cron
+}
+
+// NewGitSyncService keeps the working copies of the repositories under dir.
+func NewGitSyncService(db *common.DB, eventHub *EventHub, configService *ConfigService, dir string) *GitSyncService {
+	gitSyncService := GitSyncService{
+		appDao:        dao.NewAppDao(db),
+		configDao:     dao.NewConfigDao(db),
+		gitSyncDao:    dao.NewGitSyncDao(db),
+		releaseDao:    dao.NewReleaseDao(db),
+		releaseLogDao: dao.NewReleaseLogDao(db),
+		configService: configService,
+		dir:           dir,
+		taskChan:      make(chan *gitSyncTask, gitSyncQueueSize),
+	}
+	go gitSyncService.work()
+	eventHub.Subscribe(gitSyncService.dispatch)
+	return &gitSyncService
+}
+
+func (_self *GitSyncService) QueryGitSync(ctx context.Context, appId int64) (*dao.GitSyncData, error) {
+	return _self.gitSyncDao.QueryGitSync(ctx, appId)
+}
+
+// SaveGitSync mirrors app to the repository of data, the sync state is kept
+// while the repository, branch, path and format stay the same.
+func (_self *GitSyncService) SaveGitSync(ctx context.Context, data *dao.GitSyncData) error {
+	if err := _self.validate(data); err != nil {
+		return err
+	}
+	if _, err := _self.appDao.QueryApp(ctx, data.AppId); err != nil {
+		return err
+	}
+
+	data.LastCommit, data.LastError, data.SyncTime = "", "", nil
+	data.CreateTime = common.NowJsonTime()
+	data.UpdateTime = data.CreateTime
+	gitSync, err := _self.gitSyncDao.QueryGitSync(ctx, data.AppId)
+	if err != nil && !errors.Is(err, common.ErrNotFound) {
+		return err
+	}
+	if gitSync != nil {
+		data.CreateTime, data.CreateBy = gitSync.CreateTime, gitSync.CreateBy
+		if gitSync.Repo == data.Repo && gitSync.Branch == data.Branch && gitSync.Path == data.Path && gitSync.Format == data.Format {
+			data.LastCommit, data.LastError, data.SyncTime = gitSync.LastCommit, gitSync.LastError, gitSync.SyncTime
+		}
+	}
+
+	_, err = _self.gitSyncDao.UpsertGitSync(ctx, data)
+	return err
+}
+
+func (_self *GitSyncService) DeleteGitSync(ctx context.Context, appId int64) error {
+	rowCnt, err := _self.gitSyncDao.DeleteGitSync(ctx, appId)
+	if err != nil {
+		return err
+	}
+	if rowCnt != 1 {
+		return common.NotFound("git sync")
+	}
+	return nil
+}
+
+// Push mirrors the current release of app now, for the first sync or after
+// a failed one.
+func (_self *GitSyncService) Push(ctx context.Context, appId int64, user string) error {
+	gitSync, err := _self.gitSyncDao.QueryGitSync(ctx, appId)
+	if err != nil {
+		return err
+	}
+	appData, err := _self.appDao.QueryApp(ctx, appId)
+	if err != nil {
+		return err
+	}
+	releaseData, err := _self.releaseDao.QueryRelease(ctx, appId)
+	if errors.Is(err, common.ErrNotFound) {
+		return errors.New("app isn't released")
+	}
+	if err != nil {
+		return err
+	}
+
+	_self.gitLock.Lock()
+	defer _self.gitLock.Unlock()
+
+	commit, err := _self.push(ctx, gitSync, appData, releaseData.ReleaseIndex, releaseData.ConfigList, user)
+	_self.record(ctx, appId, commit, err)
+	return err
+}
+
+// CronPull looks for new commits in the repositories of the apps in GitOps
+// mode on spec.
+func (_self *GitSyncService) CronPull(spec string) {
+	c := cron.New()
+	c.AddFunc(spec, func() {
+		ctx := context.Background()
+		gitSyncs, err := _self.gitSyncDao.QueryGitOpsSyncs(ctx)
+		if err != nil {
+			logger.Error("git: query git syncs error", "error", err)
+			return
+		}
+
+		for _, gitSync := range gitSyncs {
+			_self.gitLock.Lock()
+			commit, err := _self.pull(ctx, gitSync)
+			_self.gitLock.Unlock()
+			if commit != "" || err != nil {
+				_self.record(ctx, gitSync.AppId, commit, err)
+			}
+		}
+	})
+	c.Start()
+
+	_self.cronLock.Lock()
+	_self.pullCron = c
+	_self.cronLock.Unlock()
+}
+
+func (_self *GitSyncService) Stop() {
+	_self.cronLock.Lock()
+	defer _self.cronLock.Unlock()
+
+	if _self.pullCron != nil {
+		_self.pullCron.Stop()
+		_self.pullCron = nil
+	}
+}
+
+func (_self *GitSyncService) dispatch(event *AppEvent) {
+	switch event.Type {
+	case EVENT_RELEASE:
+		select {
+		case _self.taskChan <- &gitSyncTask{appId: event.AppId, releaseIndex: event.ReleaseIndex}:
+		default:
+			logger.Warn("git: queue is full, drop release", "app_id", event.AppId, "release_index", event.ReleaseIndex)
+		}
+	case EVENT_APP_DELETE:
+		_, err := _self.gitSyncDao.DeleteGitSync(context.Background(), event.AppId)
+		if err != nil {
+			logger.Error("git: delete git sync error", "app_id", event.AppId, "error", err)
+		}
+
+		_self.gitLock.Lock()
+		os.RemoveAll(_self.repoDir(event.AppId))
+		_self.gitLock.Unlock()
+	}
+}
+
+func (_self *GitSyncService) work() {
+	for task := range _self.taskChan {
+		ctx := context.Background()
+		gitSync, err := _self.gitSyncDao.QueryGitSync(ctx, task.appId)
+		if errors.Is(err, common.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			logger.Error("git: query git sync error", "app_id", task.appId, "error", err)
+			continue
+		}
+		appData, err := _self.appDao.QueryApp(ctx, task.appId)
+		if err != nil {
+			logger.Error("git: query app error", "app_id", task.appId, "error", err)
+			continue
+		}
+		releaseLog, err := _self.releaseLogDao.QueryReleaseLog(ctx, task.appId, task.releaseIndex)
+		if err != nil {
+			logger.Error("git: query release log error", "app_id", task.appId, "release_index", task.releaseIndex, "error", err)
+			continue
+		}
+
+		_self.gitLock.Lock()
+		commit, err := _self.push(ctx, gitSync, appData, releaseLog.ReleaseIndex, releaseLog.ConfigList, releaseLog.ReleaseBy)
+		_self.gitLock.Unlock()
+		_self.record(ctx, task.appId, commit, err)
+	}
+}
+
+// push commits the own keys of a release as the snapshot file of app and
+// returns the commit the branch is at. In GitOps mode the commits not seen
+// yet are taken in first, and while the branch holds edits still pending in
+// app nothing is committed, the release would revert them there.
+func (_self *GitSyncService) push(ctx context.Context, gitSync *dao.GitSyncData, appData *dao.AppData, releaseIndex int, configList, operator string) (string, error) {
+	values, err := releasedValues(appData.AppId, configList)
+	if err != nil {
+		return "", err
+	}
+	content, err := renderSnapshot(gitSync.Format, values)
+	if err != nil {
+		return "", err
+	}
+	repo, err := git.Open(_self.repoDir(appData.AppId), gitSync.Repo)
+	if err != nil {
+		return "", err
+	}
+
+	file := snapshotFile(gitSync.Path, appData.Code, gitSync.Format)
+	author := git.Signature{Name: operator, Email: operator + "@varconf"}
+	message := fmt.Sprintf("Release %s #%d", appData.Code, releaseIndex)
+
+	// a commit pushed meanwhile makes the push fail, once more on top of it
+	for attempt := 0; ; attempt++ {
+		head, err := repo.Head(gitSync.Branch)
+		if err != nil {
+			return "", err
+		}
+		if gitSync.GitOps == dao.GITOPS_ENABLED && head != "" && head != gitSync.LastCommit {
+			if err := _self.importCommit(ctx, gitSync, repo, appData, head); err != nil {
+				logger.Error("git: import commit error", "app_id", appData.AppId, "commit", head, "error", err)
+			}
+		}
+		if gitSync.GitOps == dao.GITOPS_ENABLED && head != "" {
+			pending, err := _self.branchPending(ctx, repo, appData.AppId, head, file, gitSync.Format, values)
+			if err != nil {
+				return "", err
+			}
+			if pending {
+				logger.Info("git: branch holds pending changes, skip release", "app_id", appData.AppId, "release_index", releaseIndex)
+				return head, nil
+			}
+		}
+		if err = repo.Checkout(gitSync.Branch, head); err != nil {
+			return "", err
+		}
+
+		commit, changed, err := repo.CommitFile(file, content, author, message)
+		if err != nil {
+			return "", err
+		}
+		if !changed {
+			return head, nil
+		}
+		err = repo.Push(gitSync.Branch)
+		if err == nil {
+			return commit, nil
+		}
+		if attempt > 0 {
+			return "", err
+		}
+	}
+}
+
+// branchPending tells whether the snapshot file at commit holds a change of a
+// pending config of app, one the release values don't have yet.
+func (_self *GitSyncService) branchPending(ctx context.Context, repo *git.Repo, appId int64, commit, file, format string, values map[string]string) (bool, error) {
+	content, ok, err := repo.Show(commit, file)
+	if err != nil || !ok {
+		return false, err
+	}
+	branchValues, err := parseSnapshot(format, content)
+	if err != nil {
+		// a broken file holds nothing to keep, the release replaces it
+		return false, nil
+	}
+	configs, err := _self.configDao.QueryConfigs(ctx, dao.QueryConfigData{AppId: appId, Status: dao.STATUS_UN})
+	if err != nil {
+		return false, err
+	}
+	for _, config := range configs {
+		branchValue, inBranch := branchValues[config.Key]
+		value, released := values[config.Key]
+		if config.Operate == dao.OPERATE_DELETE {
+			if released && !inBranch {
+				return true, nil
+			}
+			continue
+		}
+		if inBranch && branchValue == config.Value && (!released || value != config.Value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pull takes in the head of the branch when it's a commit not seen yet and
+// returns it.
+func (_self *GitSyncService) pull(ctx context.Context, gitSync *dao.GitSyncData) (string, error) {
+	repo, err := git.Open(_self.repoDir(gitSync.AppId), gitSync.Repo)
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.Head(gitSync.Branch)
+	if err != nil {
+		return "", err
+	}
+	if head == "" || head == gitSync.LastCommit {
+		return "", nil
+	}
+
+	appData, err := _self.appDao.QueryApp(ctx, gitSync.AppId)
+	if err != nil {
+		return "", err
+	}
+	return head, _self.importCommit(ctx, gitSync, repo, appData, head)
+}
+
+// importCommit turns the changes of the snapshot file of app since the last
+// commit seen into pending changes by the author of commit, the keys it left
+// alone keep what was released meanwhile. The commit is claimed first so only
+// one node takes it in.
+func (_self *GitSyncService) importCommit(ctx context.Context, gitSync *dao.GitSyncData, repo *git.Repo, appData *dao.AppData, commit string) error {
+	lastCommit := gitSync.LastCommit
+	rowCnt, err := _self.gitSyncDao.ClaimCommit(ctx, gitSync.AppId, lastCommit, commit)
+	if err != nil {
+		return err
+	}
+	if rowCnt != 1 {
+		return nil
+	}
+	gitSync.LastCommit = commit
+
+	if err = repo.Checkout(gitSync.Branch, commit); err != nil {
+		return err
+	}
+	file := snapshotFile(gitSync.Path, appData.Code, gitSync.Format)
+	content, ok, err := repo.Show(commit, file)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s isn't in commit %s", file, commit)
+	}
+	values, err := parseSnapshot(gitSync.Format, content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	author, err := repo.Author(commit)
+	if err != nil {
+		return err
+	}
+
+	// the file as of the last commit seen, nil for the first import
+	var baseValues map[string]string
+	if lastCommit != "" {
+		content, ok, err := repo.Show(lastCommit, file)
+		if err != nil {
+			return err
+		}
+		if ok {
+			baseValues, _ = parseSnapshot(gitSync.Format, content)
+		}
+	}
+	return _self.applyValues(ctx, appData.AppId, values, baseValues, author)
+}
+
+// applyValues edits the configs of app to hold the values which differ from
+// baseValues and deletes the keys removed since, without baseValues the
+// configs end up holding values exactly. A key referencing a key created
+// later is tried once more after the others.
+func (_self *GitSyncService) applyValues(ctx context.Context, appId int64, values, baseValues map[string]string, user string) error {
+	configs, err := _self.configDao.QueryConfigs(ctx, dao.QueryConfigData{AppId: appId})
+	if err != nil {
+		return err
+	}
+	configMap := make(map[string]*dao.ConfigData)
+	for _, config := range configs {
+		configMap[config.Key] = config
+	}
+
+	failures := make([]string, 0)
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if baseValue, ok := baseValues[key]; !ok || baseValue != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for attempt := 0; attempt < 2 && len(keys) > 0; attempt++ {
+		retries := make([]string, 0)
+		errs := make(map[string]error)
+		for _, key := range keys {
+			if err := _self.applyValue(ctx, appId, configMap[key], key, values[key], user); err != nil {
+				retries = append(retries, key)
+				errs[key] = err
+			}
+		}
+		keys = retries
+		if attempt == 1 {
+			for _, key := range keys {
+				failures = append(failures, key+": "+errs[key].Error())
+			}
+		}
+	}
+
+	// deleted last, the templates referencing them are edited by now
+	for _, config := range configs {
+		deleted := config.Operate == dao.OPERATE_DELETE && config.Status == dao.STATUS_UN
+		if _, ok := values[config.Key]; ok || deleted {
+			continue
+		}
+		if _, ok := baseValues[config.Key]; !ok && baseValues != nil {
+			continue
+		}
+		err := _self.configService.DeleteConfig(ctx, dao.ConfigData{AppId: appId, ConfigId: config.ConfigId, UpdateBy: user})
+		if err != nil {
+			failures = append(failures, config.Key+": "+err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (_self *GitSyncService) applyValue(ctx context.Context, appId int64, config *dao.ConfigData, key, value, user string) error {
+	if config == nil {
+		data := dao.ConfigData{AppId: appId, Key: key, Value: value, CreateBy: user, UpdateBy: user}
+		if err := _self.configService.ValidateConfig(ctx, data, nil); err != nil {
+			return err
+		}
+		return _self.configService.CreateConfig(ctx, &data)
+	}
+
+	deleted := config.Operate == dao.OPERATE_DELETE && config.Status == dao.STATUS_UN
+	if !deleted && config.Value == value {
+		return nil
+	}
+	data := dao.ConfigData{AppId: appId, ConfigId: config.ConfigId, Value: value, UpdateBy: user, Version: config.Version}
+	fields := common.NewFields("value")
+	if err := _self.configService.ValidateConfig(ctx, data, fields); err != nil {
+		return err
+	}
+	return _self.configService.UpdateConfig(ctx, data, fields)
+}
+
+func (_self *GitSyncService) record(ctx context.Context, appId int64, commit string, err error) {
+	syncError := ""
+	if err != nil {
+		logger.Error("git: sync error", "app_id", appId, "error", err)
+		syncError = err.Error()
+		if len(syncError) > 1024 {
+			syncError = syncError[:1024]
+		}
+	}
+	if _, err := _self.gitSyncDao.UpdateSyncResult(ctx, appId, commit, syncError); err != nil {
+		logger.Error("git: record sync error", "app_id", appId, "error", err)
+	}
+}
+
+func (_self *GitSyncService) repoDir(appId int64) string {
+	return filepath.Join(_self.dir, strconv.FormatInt(appId, 10))
+}
+
+func (_self *GitSyncService) validate(data *dao.GitSyncData) error {
+	if data.Repo == "" || strings.HasPrefix(data.Repo, "-") {
+		return errors.New("invalid repository")
+	}
+	if data.Branch == "" {
+		data.Branch = "master"
+	}
+	if !gitBranchPattern.MatchString(data.Branch) || strings.Contains(data.Branch, "..") || strings.HasSuffix(data.Branch, "/") {
+		return errors.New("invalid branch")
+	}
+	data.Path = strings.Trim(path.Clean("/"+data.Path), "/")
+	if data.Format == "" {
+		data.Format = dao.GIT_FORMAT_JSON
+	}
+	if data.Format != dao.GIT_FORMAT_JSON && data.Format != dao.GIT_FORMAT_PROPERTIES {
+		return errors.New("unknown format " + data.Format)
+	}
+	if data.GitOps != dao.GITOPS_ENABLED {
+		data.GitOps = dao.GITOPS_DISABLED
+	}
+	return nil
+}
+
+// releasedValues returns the keys of app itself in a released config list,
+// the keys of linked namespaces are mirrored by their own apps.
+func releasedValues(appId int64, configList string) (map[string]string, error) {
+	configs := make([]dao.ConfigData, 0)
+	if err := json.Unmarshal([]byte(configList), &configs); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, config := range configs {
+		if config.AppId == appId {
+			values[config.Key] = config.Value
+		}
+	}
+	return values, nil
+}
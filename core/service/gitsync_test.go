@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+)
+
+// gitFixture is an app mirrored to a bare repository in a temp dir.
+type gitFixture struct {
+	t              *testing.T
+	db             *common.DB
+	dir            string
+	bare           string
+	appId          int64
+	configService  *ConfigService
+	gitSyncService *GitSyncService
+}
+
+func newGitFixture(t *testing.T, gitOps int) (*gitFixture, func()) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	db, closeDb := openTestDb(t)
+	dir, err := ioutil.TempDir("", "varconf-git")
+	if err != nil {
+		closeDb()
+		t.Fatal(err)
+	}
+	fixture := &gitFixture{t: t, db: db, dir: dir, bare: filepath.Join(dir, "conf.git")}
+	cleanup := func() {
+		fixture.configService.Stop()
+		closeDb()
+		os.RemoveAll(dir)
+	}
+	fixture.git("", "init", "-q", "--bare", fixture.bare)
+
+	// the releases aren't pushed on their own, the tests push them
+	fixture.configService = NewConfigService(db, bus.NewLocalBus(), cache.NewLruCache(16, time.Minute), NewEventHub())
+	fixture.gitSyncService = NewGitSyncService(db, NewEventHub(), fixture.configService, filepath.Join(dir, "work"))
+
+	ctx := context.Background()
+	app := &dao.AppData{Name: "demo", Code: "demo", ApiKey: "demo", Public: dao.APP_PRIVATE, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	if _, err = dao.NewAppDao(db).InsertApp(ctx, app); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	fixture.appId = app.AppId
+	gitSync := &dao.GitSyncData{AppId: fixture.appId, Repo: fixture.bare, GitOps: gitOps, CreateBy: "admin"}
+	if err = fixture.gitSyncService.SaveGitSync(ctx, gitSync); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return fixture, cleanup
+}
+
+func (_self *gitFixture) git(dir string, args ...string) string {
+	_self.t.Helper()
+	args = append([]string{"-c", "user.name=bob", "-c", "user.email=bob@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		_self.t.Fatalf("git %s: %v %s", args[4], err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// head returns the commit master of the bare repository is at.
+func (_self *gitFixture) head() string {
+	_self.t.Helper()
+	return _self.git("", "--git-dir", _self.bare, "rev-parse", "refs/heads/master")
+}
+
+// branchValues returns the snapshot at the head of master.
+func (_self *gitFixture) branchValues() map[string]string {
+	_self.t.Helper()
+	content := _self.git("", "--git-dir", _self.bare, "show", "master:demo.json")
+	values, err := parseSnapshot(dao.GIT_FORMAT_JSON, []byte(content))
+	if err != nil {
+		_self.t.Fatal(err)
+	}
+	return values
+}
+
+// commit commits values as bob on top of master, like an edit in the repository.
+func (_self *gitFixture) commit(values map[string]string) string {
+	_self.t.Helper()
+	clone := filepath.Join(_self.dir, "clone")
+	os.RemoveAll(clone)
+	_self.git("", "clone", "-q", "-b", "master", _self.bare, clone)
+	content, err := renderSnapshot(dao.GIT_FORMAT_JSON, values)
+	if err != nil {
+		_self.t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(clone, "demo.json"), content, 0644); err != nil {
+		_self.t.Fatal(err)
+	}
+	_self.git(clone, "commit", "-q", "-a", "-m", "edit")
+	_self.git(clone, "push", "-q", "origin", "master")
+	return _self.head()
+}
+
+// set creates or updates key as the pending value.
+func (_self *gitFixture) set(key, value string) {
+	_self.t.Helper()
+	ctx := context.Background()
+	configs, err := dao.NewConfigDao(_self.db).QueryConfigs(ctx, dao.QueryConfigData{AppId: _self.appId, Key: key})
+	if err != nil {
+		_self.t.Fatal(err)
+	}
+	if len(configs) == 0 {
+		err = _self.configService.CreateConfig(ctx, &dao.ConfigData{AppId: _self.appId, Key: key, Value: value, CreateBy: "alice", UpdateBy: "alice"})
+	} else {
+		data := dao.ConfigData{AppId: _self.appId, ConfigId: configs[0].ConfigId, Value: value, UpdateBy: "alice", Version: configs[0].Version}
+		err = _self.configService.UpdateConfig(ctx, data, common.NewFields("value"))
+	}
+	if err != nil {
+		_self.t.Fatal(err)
+	}
+}
+
+// release releases the pending configs as alice and pushes the release.
+func (_self *gitFixture) release() {
+	_self.t.Helper()
+	ctx := context.Background()
+	if err := _self.configService.ReleaseConfig(ctx, _self.appId, "alice"); err != nil {
+		_self.t.Fatal(err)
+	}
+	if err := _self.gitSyncService.Push(ctx, _self.appId, "alice"); err != nil {
+		_self.t.Fatal(err)
+	}
+}
+
+func (_self *gitFixture) config(key string) *dao.ConfigData {
+	_self.t.Helper()
+	configs, err := dao.NewConfigDao(_self.db).QueryConfigs(context.Background(), dao.QueryConfigData{AppId: _self.appId, Key: key})
+	if err != nil {
+		_self.t.Fatal(err)
+	}
+	if len(configs) != 1 {
+		_self.t.Fatalf("config %s doesn't exist", key)
+	}
+	return configs[0]
+}
+
+func TestGitSyncPush(t *testing.T) {
+	fixture, cleanup := newGitFixture(t, dao.GITOPS_DISABLED)
+	defer cleanup()
+
+	fixture.set("a", "1")
+	fixture.release()
+	if values := fixture.branchValues(); len(values) != 1 || values["a"] != "1" {
+		t.Fatalf("branch holds %v, want a=1", values)
+	}
+	head := fixture.head()
+	if author := fixture.git("", "--git-dir", fixture.bare, "log", "-1", "--format=%an %s", head); author != "alice Release demo #1" {
+		t.Fatalf("got commit %q", author)
+	}
+
+	// the same release again commits nothing
+	if err := fixture.gitSyncService.Push(context.Background(), fixture.appId, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if fixture.head() != head {
+		t.Fatal("unchanged release was committed")
+	}
+
+	// without GitOps an edit in the repository is overwritten
+	fixture.commit(map[string]string{"a": "2"})
+	fixture.set("b", "1")
+	fixture.release()
+	if values := fixture.branchValues(); values["a"] != "1" || values["b"] != "1" {
+		t.Fatalf("branch holds %v, want a=1 b=1", values)
+	}
+	if config := fixture.config("a"); config.Value != "1" || config.Status != dao.STATUS_IN {
+		t.Fatalf("edit in the repository was imported as %q", config.Value)
+	}
+}
+
+func TestGitSyncGitOps(t *testing.T) {
+	fixture, cleanup := newGitFixture(t, dao.GITOPS_ENABLED)
+	defer cleanup()
+
+	fixture.set("a", "1")
+	fixture.set("b", "1")
+	fixture.release()
+
+	// bob edits a in the repository while alice releases b
+	edit := fixture.commit(map[string]string{"a": "2", "b": "1"})
+	fixture.set("b", "2")
+	fixture.release()
+
+	config := fixture.config("a")
+	if config.Value != "2" || config.Status != dao.STATUS_UN || config.UpdateBy != "bob" {
+		t.Fatalf("a is %q by %s in status %d, want the pending edit of bob", config.Value, config.UpdateBy, config.Status)
+	}
+	if fixture.head() != edit {
+		t.Fatalf("release was committed over the pending edit, branch holds %v", fixture.branchValues())
+	}
+	if config := fixture.config("b"); config.Value != "2" || config.Status != dao.STATUS_IN {
+		t.Fatalf("b left alone by bob was imported as %q", config.Value)
+	}
+
+	// once released the edit and the release of b are committed together
+	fixture.release()
+	if values := fixture.branchValues(); values["a"] != "2" || values["b"] != "2" {
+		t.Fatalf("branch holds %v, want a=2 b=2", values)
+	}
+}
+
+func TestGitSyncPull(t *testing.T) {
+	fixture, cleanup := newGitFixture(t, dao.GITOPS_ENABLED)
+	defer cleanup()
+
+	fixture.set("a", "1")
+	fixture.set("b", "1")
+	fixture.release()
+
+	edit := fixture.commit(map[string]string{"a": "2", "c": "3"})
+	gitSync, err := fixture.gitSyncService.QueryGitSync(context.Background(), fixture.appId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := fixture.gitSyncService.pull(context.Background(), gitSync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != edit {
+		t.Fatalf("pulled %q, want %q", commit, edit)
+	}
+	if config := fixture.config("a"); config.Value != "2" || config.Operate != dao.OPERATE_UPDATE {
+		t.Fatalf("a is %q with operate %d, want an update to 2", config.Value, config.Operate)
+	}
+	if config := fixture.config("b"); config.Operate != dao.OPERATE_DELETE || config.Status != dao.STATUS_UN {
+		t.Fatal("b removed in the repository isn't a pending delete")
+	}
+	if config := fixture.config("c"); config.Value != "3" || config.CreateBy != "bob" {
+		t.Fatalf("c is %q by %s, want created by bob", config.Value, config.CreateBy)
+	}
+
+	// the commit is taken in once
+	commit, err = fixture.gitSyncService.pull(context.Background(), gitSync)
+	if err != nil || commit != "" {
+		t.Fatalf("pulled %q again, %v", commit, err)
+	}
+}
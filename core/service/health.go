@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+)
+
+const (
+	healthTimeout = 2 * time.Second
+)
+
+type HealthCheck struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type HealthService struct {
+	schemaDao     *dao.SchemaDao
+	configService *ConfigService
+}
+
+func NewHealthService(db *common.DB, configService *ConfigService) *HealthService {
+	healthService := HealthService{
+		schemaDao:     dao.NewSchemaDao(db),
+		configService: configService,
+	}
+	return &healthService
+}
+
+// Ready checks whether the server can take traffic: the database answers,
+// the release cron keeps succeeding and the schema is migrated.
+func (_self *HealthService) Ready() (bool, []*HealthCheck) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+
+	checks := make([]*HealthCheck, 0, 3)
+	checks = append(checks, _self.check("database", _self.schemaDao.Ping(ctx)))
+	checks = append(checks, _self.check("cron", _self.configService.CronHealth()))
+	if checks[0].Ok {
+		checks = append(checks, _self.check("schema", _self.schemaDao.CheckVersion(ctx)))
+	} else {
+		checks = append(checks, &HealthCheck{Name: "schema", Error: "database is unreachable"})
+	}
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.Ok
+	}
+	return ready, checks
+}
+
+func (_self *HealthService) check(name string, err error) *HealthCheck {
+	if err != nil {
+		return &HealthCheck{Name: name, Error: err.Error()}
+	}
+	return &HealthCheck{Name: name, Ok: true}
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+)
+
+func TestCronHealth(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+
+	configService := NewConfigService(db, bus.NewLocalBus(), cache.NewLruCache(16, time.Minute), NewEventHub())
+	defer configService.Stop()
+	if configService.CronHealth() == nil {
+		t.Fatal("cron is healthy before it started")
+	}
+
+	configService.CronRelease("@every 1s")
+	if err := configService.CronHealth(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a poll is parked, so the runs query the releases and fail
+	configService.PullRelease(1, "", nil, 0)
+	configService.cronLock.Lock()
+	configService.cronRunTime = time.Now().Add(-cronMissedRuns * time.Second)
+	configService.cronLock.Unlock()
+	db.Close()
+	time.Sleep(1500 * time.Millisecond)
+	if configService.CronHealth() == nil {
+		t.Fatal("cron failing for all its runs is healthy")
+	}
+}
@@ -1,8 +1,9 @@
 package service
 
 import (
-	"database/sql"
+	"context"
 	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
 )
 
 type HomeService struct {
@@ -12,7 +13,7 @@ type HomeService struct {
 	releaseLogDao *dao.ReleaseLogDao
 }
 
-func NewHomeService(db *sql.DB) *HomeService {
+func NewHomeService(db *common.DB) *HomeService {
 	homeService := HomeService{
 		appDao:        dao.NewAppDao(db),
 		userDao:       dao.NewUserDao(db),
@@ -22,20 +23,32 @@ func NewHomeService(db *sql.DB) *HomeService {
 	return &homeService
 }
 
-func (_self *HomeService) Overall() map[string]interface{} {
+func (_self *HomeService) Overall(ctx context.Context) (map[string]interface{}, error) {
 	dataMap := make(map[string]interface{})
 
-	count := _self.appDao.CountApps(dao.QueryAppData{})
+	count, err := _self.appDao.CountApps(ctx, dao.QueryAppData{})
+	if err != nil {
+		return nil, err
+	}
 	dataMap["app"] = count
 
-	count = _self.userDao.CountUsers(dao.QueryUserData{})
+	count, err = _self.userDao.CountUsers(ctx, dao.QueryUserData{})
+	if err != nil {
+		return nil, err
+	}
 	dataMap["user"] = count
 
-	count = _self.configDao.CountConfigs(dao.QueryConfigData{})
+	count, err = _self.configDao.CountConfigs(ctx, dao.QueryConfigData{})
+	if err != nil {
+		return nil, err
+	}
 	dataMap["config"] = count
 
-	count = _self.releaseLogDao.CountReleaseLogs()
+	count, err = _self.releaseLogDao.CountReleaseLogs(ctx)
+	if err != nil {
+		return nil, err
+	}
 	dataMap["releaseLog"] = count
 
-	return dataMap
+	return dataMap, nil
 }
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// ${key} in a template references another key of the app or its linked namespaces,
+// $${ escapes a literal ${. The values of the other types are taken as is.
+var referencePattern = regexp.MustCompile(`\$\$\{|\$\{([^{}]+)\}`)
+
+type interpolator struct {
+	values    map[string]string
+	templates map[string]bool
+	resolved  map[string]string
+	visiting  map[string]bool
+	strict    bool
+}
+
+// parseReferences returns the keys referenced by value.
+func parseReferences(value string) []string {
+	keys := make([]string, 0)
+	for _, match := range referencePattern.FindAllStringSubmatch(value, -1) {
+		if match[1] != "" {
+			keys = append(keys, match[1])
+		}
+	}
+	return keys
+}
+
+// interpolateValues resolves the references of every template. In strict mode a missing key or
+// a cycle fails, otherwise the offending reference is left as is.
+func interpolateValues(values map[string]string, templates map[string]bool, strict bool) (map[string]string, error) {
+	i := &interpolator{
+		values:    values,
+		templates: templates,
+		resolved:  make(map[string]string),
+		visiting:  make(map[string]bool),
+		strict:    strict,
+	}
+	if !strict {
+		// the keys on a cycle keep their raw value
+		for key, value := range values {
+			if onCycle(values, templates, key, key, make(map[string]bool)) {
+				i.resolved[key] = value
+			}
+		}
+	}
+	for key := range values {
+		if _, err := i.resolve(key); err != nil {
+			return nil, err
+		}
+	}
+	return i.resolved, nil
+}
+
+// validateReferences checks every reference reachable from key is defined and acyclic.
+func validateReferences(values map[string]string, templates map[string]bool, key string) error {
+	i := &interpolator{
+		values:    values,
+		templates: templates,
+		resolved:  make(map[string]string),
+		visiting:  make(map[string]bool),
+		strict:    true,
+	}
+	_, err := i.resolve(key)
+	return err
+}
+
+// onCycle reports whether target is reachable from the references of key.
+func onCycle(values map[string]string, templates map[string]bool, key, target string, visited map[string]bool) bool {
+	if !templates[key] {
+		return false
+	}
+	for _, reference := range parseReferences(values[key]) {
+		if reference == target {
+			return true
+		}
+		if _, exist := values[reference]; !exist || visited[reference] {
+			continue
+		}
+		visited[reference] = true
+		if onCycle(values, templates, reference, target, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// dependentKeys returns the keys whose resolved value depends on any of keys.
+func dependentKeys(values map[string]string, templates map[string]bool, keys []string) []string {
+	referrers := referrerKeys(values, templates)
+
+	keySet := make(map[string]bool)
+	queue := append([]string{}, keys...)
+	for _, key := range keys {
+		keySet[key] = true
+	}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		for _, referrer := range referrers[key] {
+			if !keySet[referrer] {
+				keySet[referrer] = true
+				queue = append(queue, referrer)
+			}
+		}
+	}
+
+	dependents := make([]string, 0, len(keySet))
+	for key := range keySet {
+		dependents = append(dependents, key)
+	}
+	return dependents
+}
+
+// referringKeys returns the templates other than key referencing key.
+func referringKeys(values map[string]string, templates map[string]bool, key string) []string {
+	keys := make([]string, 0)
+	for _, referrer := range referrerKeys(values, templates)[key] {
+		if referrer != key {
+			keys = append(keys, referrer)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// referrerKeys maps each key to the templates referencing it.
+func referrerKeys(values map[string]string, templates map[string]bool) map[string][]string {
+	referrers := make(map[string][]string)
+	for key, value := range values {
+		if !templates[key] {
+			continue
+		}
+		for _, reference := range parseReferences(value) {
+			referrers[reference] = append(referrers[reference], key)
+		}
+	}
+	return referrers
+}
+
+func (_self *interpolator) resolve(key string) (string, error) {
+	if value, exist := _self.resolved[key]; exist {
+		return value, nil
+	}
+	if !_self.templates[key] {
+		_self.resolved[key] = _self.values[key]
+		return _self.values[key], nil
+	}
+	if _self.visiting[key] {
+		return "", fmt.Errorf("cyclic reference of ${%s}", key)
+	}
+	_self.visiting[key] = true
+	defer delete(_self.visiting, key)
+
+	var err error
+	value := referencePattern.ReplaceAllStringFunc(_self.values[key], func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+
+		reference := match[2 : len(match)-1]
+		if _, exist := _self.values[reference]; !exist {
+			if _self.strict && err == nil {
+				err = fmt.Errorf("undefined reference ${%s} in %s", reference, key)
+			}
+			return match
+		}
+		resolved, e := _self.resolve(reference)
+		if e != nil {
+			if _self.strict && err == nil {
+				err = e
+			}
+			return match
+		}
+		return resolved
+	})
+	if err != nil {
+		return "", err
+	}
+
+	_self.resolved[key] = value
+	return value, nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+)
+
+func TestInterpolateValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    map[string]string
+		templates []string
+		want      map[string]string
+		strictErr string
+	}{
+		{
+			name:      "chain",
+			values:    map[string]string{"host": "db", "addr": "${host}:3306", "dsn": "tcp(${addr})"},
+			templates: []string{"addr", "dsn"},
+			want:      map[string]string{"host": "db", "addr": "db:3306", "dsn": "tcp(db:3306)"},
+		},
+		{
+			name:      "escape",
+			values:    map[string]string{"host": "db", "raw": "$${host} is ${host}"},
+			templates: []string{"raw"},
+			want:      map[string]string{"host": "db", "raw": "${host} is db"},
+		},
+		{
+			name:   "text isn't resolved",
+			values: map[string]string{"host": "db", "text": "${host}"},
+			want:   map[string]string{"host": "db", "text": "${host}"},
+		},
+		{
+			name:      "missing reference",
+			values:    map[string]string{"url": "${scheme}://db"},
+			templates: []string{"url"},
+			want:      map[string]string{"url": "${scheme}://db"},
+			strictErr: "undefined reference ${scheme} in url",
+		},
+		{
+			name:      "cycle",
+			values:    map[string]string{"a": "${b}", "b": "x${a}", "c": "<${a}>"},
+			templates: []string{"a", "b", "c"},
+			want:      map[string]string{"a": "${b}", "b": "x${a}", "c": "<${b}>"},
+			strictErr: "cyclic reference",
+		},
+		{
+			name:      "self reference",
+			values:    map[string]string{"a": "${a}"},
+			templates: []string{"a"},
+			want:      map[string]string{"a": "${a}"},
+			strictErr: "cyclic reference of ${a}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			templates := make(map[string]bool)
+			for _, key := range test.templates {
+				templates[key] = true
+			}
+
+			values, err := interpolateValues(test.values, templates, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range test.want {
+				if values[key] != want {
+					t.Errorf("%s is %q, want %q", key, values[key], want)
+				}
+			}
+
+			_, err = interpolateValues(test.values, templates, true)
+			if test.strictErr == "" && err != nil {
+				t.Fatalf("strict mode failed with %v", err)
+			}
+			if test.strictErr != "" && (err == nil || !strings.Contains(err.Error(), test.strictErr)) {
+				t.Fatalf("strict mode failed with %v, want %q", err, test.strictErr)
+			}
+		})
+	}
+}
+
+func TestDependentKeys(t *testing.T) {
+	values := map[string]string{"host": "db", "addr": "${host}:3306", "dsn": "tcp(${addr})", "other": "${port}"}
+	templates := map[string]bool{"addr": true, "dsn": true, "other": true}
+
+	keys := dependentKeys(values, templates, []string{"host"})
+	got := make(map[string]bool)
+	for _, key := range keys {
+		got[key] = true
+	}
+	if len(keys) != 3 || !got["host"] || !got["addr"] || !got["dsn"] {
+		t.Fatalf("got %v, want host addr dsn", keys)
+	}
+	if referrers := referringKeys(values, templates, "addr"); len(referrers) != 1 || referrers[0] != "dsn" {
+		t.Fatalf("got referrers %v, want dsn", referrers)
+	}
+}
+
+// TestInterpolateNamespace resolves a template against a key of a linked namespace.
+func TestInterpolateNamespace(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	ctx := context.Background()
+
+	configService := NewConfigService(db, bus.NewLocalBus(), cache.NewLruCache(16, time.Minute), NewEventHub())
+	defer configService.Stop()
+	shared := &dao.AppData{Name: "shared", Code: "shared", ApiKey: "key-shared", Public: dao.APP_PUBLIC, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	service := &dao.AppData{Name: "service", Code: "service", ApiKey: "key-service", Public: dao.APP_PRIVATE, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	for _, app := range []*dao.AppData{shared, service} {
+		if _, err := dao.NewAppDao(db).InsertApp(ctx, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host := &dao.ConfigData{AppId: shared.AppId, Key: "kafka.host", Value: "k1", CreateBy: "alice", UpdateBy: "alice"}
+	if err := configService.CreateConfig(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+	if err := configService.ReleaseConfig(ctx, shared.AppId, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configService.LinkApp(ctx, service.AppId, shared.AppId, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := dao.ConfigData{AppId: service.AppId, Key: "url", Value: "${kafka.port}", Type: dao.TYPE_TEMPLATE}
+	if err := configService.ValidateConfig(ctx, missing, nil); err == nil {
+		t.Fatal("template referencing a key defined nowhere was accepted")
+	}
+	url := &dao.ConfigData{AppId: service.AppId, Key: "url", Value: "${kafka.host}:9092", Type: dao.TYPE_TEMPLATE, CreateBy: "bob", UpdateBy: "bob"}
+	if err := configService.ValidateConfig(ctx, *url, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := configService.CreateConfig(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	if err := configService.ReleaseConfig(ctx, service.AppId, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	configList, _, err := configService.QueryRelease(ctx, service.AppId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	for _, config := range configList {
+		values[config.Key] = config.Value
+	}
+	if values["url"] != "k1:9092" || values["kafka.host"] != "k1" {
+		t.Fatalf("released %v, want url k1:9092", values)
+	}
+
+	// the shared key can't go while the consumer references it
+	err = configService.DeleteConfig(ctx, dao.ConfigData{AppId: shared.AppId, ConfigId: host.ConfigId, UpdateBy: "alice"})
+	if !errors.Is(err, common.ErrConflict) || !strings.Contains(err.Error(), "service:url") {
+		t.Fatalf("deleted a key referenced by a consumer, %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"io"
+	"runtime"
+	"strconv"
+	"time"
+
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/metrics"
+)
+
+type MetricsService struct {
+	registry        *metrics.Registry
+	requestCounter  *metrics.Counter
+	requestDuration *metrics.Histogram
+	queryDuration   *metrics.Histogram
+	releaseCounter  *metrics.Counter
+}
+
+func NewMetricsService(eventHub *EventHub, configService *ConfigService) *MetricsService {
+	registry := metrics.NewRegistry()
+	metricsService := MetricsService{
+		registry: registry,
+		requestCounter: registry.NewCounter("varconf_http_requests_total",
+			"Number of http requests by route and status.", "method", "route", "status"),
+		requestDuration: registry.NewHistogram("varconf_http_request_duration_seconds",
+			"Latency of http requests by route and status, long polls included.",
+			[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}, "method", "route", "status"),
+		queryDuration: registry.NewHistogram("varconf_db_query_duration_seconds",
+			"Latency of database statements by operation and table.", nil, "operation", "table"),
+		releaseCounter: registry.NewCounter("varconf_releases_total",
+			"Number of releases by app and kind, refresh for the ones re-merging the linked namespaces.",
+			"app_id", "kind"),
+	}
+
+	registry.NewGaugeFunc("varconf_poll_waiters", "Number of long polls waiting by app.", func() []metrics.Sample {
+		samples := make([]metrics.Sample, 0)
+		for appId, count := range configService.PollWaiters() {
+			samples = append(samples, metrics.Sample{Labels: []string{strconv.FormatInt(appId, 10)}, Value: float64(count)})
+		}
+		return samples
+	}, "app_id")
+	metricsService.registerCache(configService)
+	metricsService.registerRuntime()
+
+	eventHub.Subscribe(metricsService.countRelease)
+	common.SetQueryObserver(metricsService.ObserveQuery)
+	return &metricsService
+}
+
+// ObserveRequest is the router observer.
+func (_self *MetricsService) ObserveRequest(method, route string, status int, duration time.Duration) {
+	statusText := strconv.Itoa(status)
+	_self.requestCounter.Inc(method, route, statusText)
+	_self.requestDuration.Observe(duration.Seconds(), method, route, statusText)
+}
+
+// ObserveQuery is the dao query observer, it's hooked up by NewMetricsService.
+func (_self *MetricsService) ObserveQuery(operation, table string, duration time.Duration) {
+	_self.queryDuration.Observe(duration.Seconds(), operation, table)
+}
+
+func (_self *MetricsService) Write(w io.Writer) {
+	_self.registry.Write(w)
+}
+
+func (_self *MetricsService) countRelease(event *AppEvent) {
+	if event.Type != EVENT_RELEASE {
+		return
+	}
+	kind := "release"
+	if event.Refresh {
+		kind = "refresh"
+	}
+	_self.releaseCounter.Inc(strconv.FormatInt(event.AppId, 10), kind)
+}
+
+func (_self *MetricsService) registerCache(configService *ConfigService) {
+	cacheStat := func(value func(hits, misses, evictions, size, capacity float64) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			stats := configService.CacheStats()
+			v := value(float64(stats.Hits), float64(stats.Misses), float64(stats.Evictions), float64(stats.Size), float64(stats.Capacity))
+			return []metrics.Sample{{Value: v}}
+		}
+	}
+
+	_self.registry.NewCounterFunc("varconf_release_cache_hits_total", "Number of release cache hits.",
+		cacheStat(func(hits, misses, evictions, size, capacity float64) float64 { return hits }))
+	_self.registry.NewCounterFunc("varconf_release_cache_misses_total", "Number of release cache misses.",
+		cacheStat(func(hits, misses, evictions, size, capacity float64) float64 { return misses }))
+	_self.registry.NewCounterFunc("varconf_release_cache_evictions_total", "Number of release cache evictions.",
+		cacheStat(func(hits, misses, evictions, size, capacity float64) float64 { return evictions }))
+	_self.registry.NewGaugeFunc("varconf_release_cache_size", "Number of apps in the release cache.",
+		cacheStat(func(hits, misses, evictions, size, capacity float64) float64 { return size }))
+	_self.registry.NewGaugeFunc("varconf_release_cache_capacity", "Capacity of the release cache.",
+		cacheStat(func(hits, misses, evictions, size, capacity float64) float64 { return capacity }))
+}
+
+func (_self *MetricsService) registerRuntime() {
+	gauge := func(value func() float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			return []metrics.Sample{{Value: value()}}
+		}
+	}
+	memStat := func(value func(stats *runtime.MemStats) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			stats := runtime.MemStats{}
+			runtime.ReadMemStats(&stats)
+			return []metrics.Sample{{Value: value(&stats)}}
+		}
+	}
+	startTime := float64(time.Now().Unix())
+
+	_self.registry.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.",
+		gauge(func() float64 { return float64(runtime.NumGoroutine()) }))
+	_self.registry.NewGaugeFunc("go_threads", "Number of OS threads created.",
+		gauge(func() float64 { n, _ := runtime.ThreadCreateProfile(nil); return float64(n) }))
+	_self.registry.NewGaugeFunc("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.",
+		memStat(func(stats *runtime.MemStats) float64 { return float64(stats.Alloc) }))
+	_self.registry.NewGaugeFunc("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.",
+		memStat(func(stats *runtime.MemStats) float64 { return float64(stats.HeapInuse) }))
+	_self.registry.NewGaugeFunc("go_memstats_sys_bytes", "Number of bytes obtained from system.",
+		memStat(func(stats *runtime.MemStats) float64 { return float64(stats.Sys) }))
+	_self.registry.NewCounterFunc("go_gc_cycles_total", "Number of completed GC cycles.",
+		memStat(func(stats *runtime.MemStats) float64 { return float64(stats.NumGC) }))
+	_self.registry.NewCounterFunc("go_gc_pause_seconds_total", "Total GC pause time.",
+		memStat(func(stats *runtime.MemStats) float64 { return float64(stats.PauseTotalNs) / 1e9 }))
+	_self.registry.NewGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.",
+		gauge(func() float64 { return startTime }))
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/logger"
+	mailer "varconf-server/core/moudle/mail"
+)
+
+const (
+	notifyMaxAttempts = 3
+	notifyQueueSize   = 256
+)
+
+// ConfigDiff is one key changed by a release.
+type ConfigDiff struct {
+	Key      string
+	Operate  string
+	OldValue string
+	NewValue string
+}
+
+type notifyContent struct {
+	Event *AppEvent
+	App   *dao.AppData
+	Diffs []*ConfigDiff
+}
+
+type notifyTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+var notifyTemplates = map[string]*notifyTemplate{
+	EVENT_RELEASE: newNotifyTemplate(
+		"[varconf] {{.App.Name}} released #{{.Event.ReleaseIndex}}",
+		`{{.Event.Operator}} released app {{.App.Name}} ({{.App.Code}}) at {{.Event.Time.Format "2006-01-02 15:04:05"}}.
+
+Release index: {{.Event.ReleaseIndex}}
+{{template "diff" .}}`),
+	EVENT_CONFIG_EDIT: newNotifyTemplate(
+		"[varconf] {{.App.Name}} has a change waiting for release",
+		`{{.Event.Operator}} changed app {{.App.Name}} ({{.App.Code}}) at {{.Event.Time.Format "2006-01-02 15:04:05"}}, the change is waiting to be released.
+{{template "diff" .}}`),
+}
+
+func newNotifyTemplate(subject, body string) *notifyTemplate {
+	bodyTemplate := template.Must(template.New("body").Parse(body))
+	template.Must(bodyTemplate.New("diff").Parse(`
+{{- if .Diffs}}
+Changes:
+{{range .Diffs}}  {{.Operate}} {{.Key}}
+{{- if ne .Operate "added"}}
+    - {{.OldValue}}{{end}}
+{{- if ne .Operate "removed"}}
+    + {{.NewValue}}{{end}}
+{{end}}{{else}}
+No value changed.
+{{end}}`))
+	return &notifyTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    bodyTemplate,
+	}
+}
+
+type NotifyService struct {
+	appDao          *dao.AppDao
+	configDao       *dao.ConfigDao
+	releaseLogDao   *dao.ReleaseLogDao
+	subscriptionDao *dao.SubscriptionDao
+	sender          *mailer.Sender
+	eventChan       chan *AppEvent
+}
+
+// NewNotifyService mails the subscribers of an app on its events, sender
+// may be nil when no SMTP server is configured.
+func NewNotifyService(db *common.DB, eventHub *EventHub, sender *mailer.Sender) *NotifyService {
+	notifyService := NotifyService{
+		appDao:          dao.NewAppDao(db),
+		configDao:       dao.NewConfigDao(db),
+		releaseLogDao:   dao.NewReleaseLogDao(db),
+		subscriptionDao: dao.NewSubscriptionDao(db),
+		sender:          sender,
+		eventChan:       make(chan *AppEvent, notifyQueueSize),
+	}
+	go notifyService.work()
+	eventHub.Subscribe(notifyService.dispatch)
+	return &notifyService
+}
+
+func (_self *NotifyService) QuerySubscriptions(ctx context.Context, appId int64) ([]*dao.SubscriptionData, error) {
+	return _self.subscriptionDao.QuerySubscriptions(ctx, appId)
+}
+
+func (_self *NotifyService) CreateSubscription(ctx context.Context, data *dao.SubscriptionData) error {
+	address, err := mail.ParseAddress(data.Email)
+	if err != nil {
+		return errors.New("invalid email address")
+	}
+	data.Email = address.Address
+	for _, e := range strings.Split(data.Events, ",") {
+		if notifyTemplates[strings.TrimSpace(e)] == nil {
+			return fmt.Errorf("unknown notification event %q", e)
+		}
+	}
+	data.CreateTime = common.NowJsonTime()
+
+	_, err = _self.subscriptionDao.InsertSubscription(ctx, data)
+	return err
+}
+
+func (_self *NotifyService) DeleteSubscription(ctx context.Context, appId, subscriptionId int64) error {
+	rowCnt, err := _self.subscriptionDao.DeleteSubscription(ctx, appId, subscriptionId)
+	if err != nil {
+		return err
+	}
+	if rowCnt != 1 {
+		return common.NotFound("subscription")
+	}
+	return nil
+}
+
+// dispatch queues event, the subscriptions are looked up by the worker apart
+// from the release or the edit.
+func (_self *NotifyService) dispatch(event *AppEvent) {
+	select {
+	case _self.eventChan <- event:
+	default:
+		logger.Warn("notify: queue is full, drop event", "event", event.Type, "app_id", event.AppId)
+	}
+}
+
+func (_self *NotifyService) notify(event *AppEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("notify: notify error", "event", event.Type, "app_id", event.AppId, "error", fmt.Sprint(err))
+		}
+	}()
+
+	ctx := context.Background()
+	if event.Type == EVENT_APP_DELETE {
+		_, err := _self.subscriptionDao.DeleteSubscriptions(ctx, event.AppId)
+		if err != nil {
+			logger.Error("notify: delete subscriptions error", "app_id", event.AppId, "error", err)
+		}
+		return
+	}
+	if _self.sender == nil {
+		return
+	}
+	message, err := _self.message(ctx, event)
+	if err != nil {
+		logger.Error("notify: prepare message error", "event", event.Type, "app_id", event.AppId, "error", err)
+		return
+	}
+	if message != nil {
+		_self.send(message)
+	}
+}
+
+// message renders the mail of event to the subscribers, nil when nobody
+// subscribed to it.
+func (_self *NotifyService) message(ctx context.Context, event *AppEvent) (*mailer.Message, error) {
+	notifyTemplate := notifyTemplates[event.Type]
+	if notifyTemplate == nil {
+		return nil, nil
+	}
+
+	// collect recipients
+	subscriptions, err := _self.subscriptionDao.QuerySubscriptions(ctx, event.AppId)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]string, 0)
+	for _, subscription := range subscriptions {
+		if _self.subscribed(subscription, event.Type) {
+			recipients = append(recipients, subscription.Email)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+
+	// render message
+	content := &notifyContent{Event: event, App: event.App}
+	if content.App == nil {
+		content.App, err = _self.appDao.QueryApp(ctx, event.AppId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if event.Type == EVENT_CONFIG_EDIT {
+		content.Diffs = _self.pendingDiffs(ctx, content.App, event.Keys, event.Operate)
+	} else if event.ReleaseIndex > 0 {
+		content.Diffs = _self.releaseDiffs(ctx, event.AppId, event.ReleaseIndex)
+	}
+	message, err := _self.render(notifyTemplate, content)
+	if err != nil {
+		return nil, err
+	}
+	message.To = recipients
+	return message, nil
+}
+
+func (_self *NotifyService) render(notifyTemplate *notifyTemplate, content *notifyContent) (*mailer.Message, error) {
+	subject := bytes.Buffer{}
+	if err := notifyTemplate.subject.Execute(&subject, content); err != nil {
+		return nil, err
+	}
+	body := bytes.Buffer{}
+	if err := notifyTemplate.body.Execute(&body, content); err != nil {
+		return nil, err
+	}
+	return &mailer.Message{Subject: subject.String(), Body: body.String()}, nil
+}
+
+// releaseDiffs compares the release at releaseIndex with the one before it.
+func (_self *NotifyService) releaseDiffs(ctx context.Context, appId int64, releaseIndex int) []*ConfigDiff {
+	newValues := _self.releaseValues(_self.releaseLogDao.QueryReleaseLog(ctx, appId, releaseIndex))
+	oldValues := _self.releaseValues(_self.releaseLogDao.QueryPrevReleaseLog(ctx, appId, releaseIndex))
+
+	diffs := make([]*ConfigDiff, 0)
+	for key, newValue := range newValues {
+		oldValue, exist := oldValues[key]
+		if !exist {
+			diffs = append(diffs, &ConfigDiff{Key: key, Operate: "added", NewValue: newValue})
+		} else if oldValue != newValue {
+			diffs = append(diffs, &ConfigDiff{Key: key, Operate: "changed", OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	for key, oldValue := range oldValues {
+		if _, exist := newValues[key]; !exist {
+			diffs = append(diffs, &ConfigDiff{Key: key, Operate: "removed", OldValue: oldValue})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs
+}
+
+// pendingDiffs compares the pending values of keys with the last release of app.
+func (_self *NotifyService) pendingDiffs(ctx context.Context, app *dao.AppData, keys []string, operate int) []*ConfigDiff {
+	oldValues := make(map[string]string)
+	if app.ReleaseIndex > 0 {
+		oldValues = _self.releaseValues(_self.releaseLogDao.QueryReleaseLog(ctx, app.AppId, app.ReleaseIndex))
+	}
+
+	diffs := make([]*ConfigDiff, 0)
+	for _, key := range keys {
+		diff := &ConfigDiff{Key: key, OldValue: oldValues[key]}
+		switch operate {
+		case dao.OPERATE_NEW:
+			diff.Operate = "added"
+		case dao.OPERATE_DELETE:
+			diff.Operate = "removed"
+		default:
+			diff.Operate = "changed"
+		}
+		if operate != dao.OPERATE_DELETE {
+			configs, err := _self.configDao.QueryConfigs(ctx, dao.QueryConfigData{AppId: app.AppId, Key: key})
+			if err != nil {
+				logger.Error("notify: query config error", "app_id", app.AppId, "key", key, "error", err)
+				continue
+			}
+			if len(configs) != 1 {
+				continue
+			}
+			diff.NewValue = configs[0].Value
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+// releaseValues decodes the values of releaseLog, none when it's missing.
+func (_self *NotifyService) releaseValues(releaseLog *dao.ReleaseLogData, err error) map[string]string {
+	values := make(map[string]string)
+	if err != nil {
+		if !errors.Is(err, common.ErrNotFound) {
+			logger.Error("notify: query release error", "error", err)
+		}
+		return values
+	}
+
+	configs := make([]*dao.ConfigData, 0)
+	if err := json.Unmarshal([]byte(releaseLog.ConfigList), &configs); err != nil {
+		logger.Error("notify: decode release error", "app_id", releaseLog.AppId, "release_index", releaseLog.ReleaseIndex, "error", err)
+		return values
+	}
+	for _, config := range configs {
+		values[config.Key] = config.Value
+	}
+	return values
+}
+
+func (_self *NotifyService) work() {
+	for event := range _self.eventChan {
+		_self.notify(event)
+	}
+}
+
+func (_self *NotifyService) send(message *mailer.Message) {
+	for attempt := 1; ; attempt++ {
+		err := _self.sender.Send(message)
+		if err == nil {
+			return
+		}
+		if attempt >= notifyMaxAttempts {
+			logger.Error("notify: send mail error", "subject", message.Subject, "attempts", attempt, "error", err)
+			return
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func (_self *NotifyService) subscribed(subscription *dao.SubscriptionData, event string) bool {
+	for _, e := range strings.Split(subscription.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
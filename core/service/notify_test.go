@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+)
+
+func TestNotifyPendingChange(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	ctx := context.Background()
+
+	notifyService := NewNotifyService(db, NewEventHub(), nil)
+	configService := NewConfigService(db, bus.NewLocalBus(), cache.NewLruCache(16, time.Minute), NewEventHub())
+	defer configService.Stop()
+	app := &dao.AppData{Name: "demo", Code: "demo", ApiKey: "key", Public: dao.APP_PRIVATE, CreateTime: common.NowJsonTime(), UpdateTime: common.NowJsonTime()}
+	if _, err := dao.NewAppDao(db).InsertApp(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	subscription := &dao.SubscriptionData{AppId: app.AppId, Email: "alice@example.com", Events: EVENT_CONFIG_EDIT, CreateBy: "alice"}
+	if err := notifyService.CreateSubscription(ctx, subscription); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifyService.CreateSubscription(ctx, &dao.SubscriptionData{AppId: app.AppId, Email: "bob@example.com", Events: "rollback"}); err == nil {
+		t.Fatal("subscribed to an event which is never emitted")
+	}
+
+	config := &dao.ConfigData{AppId: app.AppId, Key: "timeout", Value: "1s", CreateBy: "alice", UpdateBy: "alice"}
+	if err := configService.CreateConfig(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := configService.ReleaseConfig(ctx, app.AppId, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	configs, err := dao.NewConfigDao(db).QueryConfigs(ctx, dao.QueryConfigData{AppId: app.AppId, Key: "timeout"})
+	if err != nil || len(configs) != 1 {
+		t.Fatal(err)
+	}
+	config = configs[0]
+	update := dao.ConfigData{AppId: app.AppId, ConfigId: config.ConfigId, Value: "5s", UpdateBy: "bob", Version: config.Version}
+	if err := configService.UpdateConfig(ctx, update, common.NewFields("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &AppEvent{Type: EVENT_CONFIG_EDIT, AppId: app.AppId, Keys: []string{"timeout"}, Operate: dao.OPERATE_UPDATE, Operator: "bob", Time: time.Now()}
+	message, err := notifyService.message(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.To) != 1 || message.To[0] != "alice@example.com" {
+		t.Fatalf("mailed %v, want alice", message.To)
+	}
+	if !strings.Contains(message.Subject, "waiting for release") {
+		t.Fatalf("got subject %q", message.Subject)
+	}
+	for _, want := range []string{"bob changed app demo", "changed timeout", "- 1s", "+ 5s"} {
+		if !strings.Contains(message.Body, want) {
+			t.Fatalf("body misses %q:\n%s", want, message.Body)
+		}
+	}
+
+	// nobody subscribed to the releases
+	event = &AppEvent{Type: EVENT_RELEASE, AppId: app.AppId, ReleaseIndex: 1, Operator: "alice", Time: time.Now()}
+	if message, err = notifyService.message(ctx, event); err != nil || message != nil {
+		t.Fatalf("got %v, %v for a release nobody subscribed to", message, err)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"varconf-server/core/dao"
+)
+
+// snapshotFile is the file of app in the repository, <path>/<code>.<format>.
+func snapshotFile(dir, code, format string) string {
+	return path.Join(dir, code+"."+format)
+}
+
+// renderSnapshot writes the values in format, sorted by key so an unchanged
+// release renders the same bytes.
+func renderSnapshot(format string, values map[string]string) ([]byte, error) {
+	switch format {
+	case dao.GIT_FORMAT_JSON:
+		content, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(content, '\n'), nil
+	case dao.GIT_FORMAT_PROPERTIES:
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buffer := bytes.Buffer{}
+		for _, key := range keys {
+			buffer.WriteString(escapeProperty(key, true))
+			buffer.WriteString("=")
+			buffer.WriteString(escapeProperty(values[key], false))
+			buffer.WriteString("\n")
+		}
+		return buffer.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+// parseSnapshot reads the values of a file in format.
+func parseSnapshot(format string, content []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	switch format {
+	case dao.GIT_FORMAT_JSON:
+		if err := json.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("bad json: %v", err)
+		}
+		return values, nil
+	case dao.GIT_FORMAT_PROPERTIES:
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimLeft(scanner.Text(), " \t\f")
+			if line == "" || line[0] == '#' || line[0] == '!' {
+				continue
+			}
+			key, value, ok := splitProperty(line)
+			if !ok {
+				return nil, fmt.Errorf("bad properties line %d", n)
+			}
+			values[key] = value
+		}
+		return values, scanner.Err()
+	}
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+// escapeProperty escapes s for a properties file, one entry per line.
+func escapeProperty(s string, key bool) string {
+	buffer := bytes.Buffer{}
+	for i, ch := range s {
+		switch ch {
+		case '\\':
+			buffer.WriteString(`\\`)
+		case '\n':
+			buffer.WriteString(`\n`)
+		case '\r':
+			buffer.WriteString(`\r`)
+		case '\t':
+			buffer.WriteString(`\t`)
+		case '\f':
+			buffer.WriteString(`\f`)
+		case '=', ':':
+			if key {
+				buffer.WriteRune('\\')
+			}
+			buffer.WriteRune(ch)
+		case '#', '!':
+			if key && i == 0 {
+				buffer.WriteRune('\\')
+			}
+			buffer.WriteRune(ch)
+		case ' ':
+			if key || i == 0 {
+				buffer.WriteRune('\\')
+			}
+			buffer.WriteRune(ch)
+		default:
+			buffer.WriteRune(ch)
+		}
+	}
+	return buffer.String()
+}
+
+// splitProperty splits a line at the first unescaped =, : or space and
+// unescapes both sides.
+func splitProperty(line string) (string, string, bool) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '=', ':', ' ', '\t', '\f':
+			value := strings.TrimLeft(line[i+1:], " \t\f")
+			if line[i] == ' ' || line[i] == '\t' || line[i] == '\f' {
+				if len(value) > 0 && (value[0] == '=' || value[0] == ':') {
+					value = strings.TrimLeft(value[1:], " \t\f")
+				}
+			}
+			return unescapeProperty(line[:i]), unescapeProperty(value), true
+		}
+	}
+	return unescapeProperty(line), "", line != ""
+}
+
+func unescapeProperty(s string) string {
+	buffer := bytes.Buffer{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			buffer.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			buffer.WriteByte('\n')
+		case 'r':
+			buffer.WriteByte('\r')
+		case 't':
+			buffer.WriteByte('\t')
+		case 'f':
+			buffer.WriteByte('\f')
+		default:
+			buffer.WriteByte(s[i])
+		}
+	}
+	return buffer.String()
+}
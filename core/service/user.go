@@ -1,74 +1,136 @@
 package service
 
 import (
-	"database/sql"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"time"
 
 	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
 )
 
 type UserService struct {
 	userDao *dao.UserDao
 }
 
-func NewUserService(db *sql.DB) *UserService {
+func NewUserService(db *common.DB) *UserService {
 	userService := UserService{
 		userDao: dao.NewUserDao(db),
 	}
 	return &userService
 }
 
-func (_self *UserService) PageQuery(likeName string, pageIndex, pageSize int64) ([]*dao.UserData, int64, int64) {
+func (_self *UserService) PageQuery(ctx context.Context, likeName string, pageIndex, pageSize int64) ([]*dao.UserData, int64, int64, error) {
 	start := (pageIndex - 1) * pageSize
 	end := start + pageSize
 
-	pageData := _self.userDao.QueryUsers(dao.QueryUserData{LikeName: likeName, Start: start, End: end})
-	totalCount := _self.userDao.CountUsers(dao.QueryUserData{LikeName: likeName})
+	pageData, err := _self.userDao.QueryUsers(ctx, dao.QueryUserData{LikeName: likeName, Start: start, End: end})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	totalCount, err := _self.userDao.CountUsers(ctx, dao.QueryUserData{LikeName: likeName})
+	if err != nil {
+		return nil, 0, 0, err
+	}
 	pageCount := totalCount / pageSize
 	if totalCount%pageSize != 0 {
 		pageCount += 1
 	}
-	return pageData, pageCount, totalCount
+	return pageData, pageCount, totalCount, nil
 }
 
-func (_self *UserService) QueryUser(userId int64) *dao.UserData {
-	users := _self.userDao.QueryUsers(dao.QueryUserData{UserId: userId})
-	if len(users) > 0 {
-		return users[0]
+func (_self *UserService) QueryUser(ctx context.Context, userId int64) (*dao.UserData, error) {
+	users, err := _self.userDao.QueryUsers(ctx, dao.QueryUserData{UserId: userId})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if len(users) == 0 {
+		return nil, common.NotFound("user")
+	}
+	return users[0], nil
 }
 
-func (_self *UserService) CreateUser(userData *dao.UserData) bool {
-	users := _self.userDao.QueryUsers(dao.QueryUserData{Name: userData.Name})
+func (_self *UserService) CreateUser(ctx context.Context, userData *dao.UserData) error {
+	users, err := _self.userDao.QueryUsers(ctx, dao.QueryUserData{Name: userData.Name})
+	if err != nil {
+		return err
+	}
 	if len(users) != 0 {
-		return false
+		return common.Conflict("user " + userData.Name + " exists")
 	}
 
 	userData.CreateTime.Time = time.Now()
 	userData.UpdateTime.Time = time.Now()
 
-	rowCnt := _self.userDao.InsertUser(userData)
-	if rowCnt != 1 {
-		return false
-	}
-	return true
+	_, err = _self.userDao.InsertUser(ctx, userData)
+	return err
 }
 
-func (_self *UserService) SelectedUpdateUser(userData dao.UserData) bool {
+// SelectedUpdateUser updates the name, password and permission among fields.
+func (_self *UserService) SelectedUpdateUser(ctx context.Context, userData dao.UserData, fields common.Fields) error {
+	fields = fields.Only("name", "password", "permission").With("UpdateTime")
+	if fields.Has("name") && userData.Name == "" {
+		return errors.New("name is empty")
+	}
+	if fields.Has("password") && userData.Password == "" {
+		return errors.New("password is empty")
+	}
+	if fields.Has("permission") && userData.Permission != dao.USER_ORDINARY && userData.Permission != dao.USER_ADMIN {
+		return errors.New("unknown permission")
+	}
 	userData.UpdateTime.Time = time.Now()
 
-	rowCnt := _self.userDao.SelectedUpdateUser(userData)
+	rowCnt, err := _self.userDao.SelectedUpdateUser(ctx, userData, fields)
+	if err != nil {
+		return err
+	}
 	if rowCnt != 1 {
-		return false
+		return common.NotFound("user")
 	}
-	return true
+	return nil
 }
 
-func (_self *UserService) DeleteUser(userId int64) bool {
-	rowCnt := _self.userDao.DeleteUser(userId)
+func (_self *UserService) DeleteUser(ctx context.Context, userId int64) error {
+	rowCnt, err := _self.userDao.DeleteUser(ctx, userId)
+	if err != nil {
+		return err
+	}
 	if rowCnt != 1 {
-		return false
+		return common.NotFound("user")
+	}
+	return nil
+}
+
+// Bootstrap creates the admin of an empty user table with password, or with a
+// generated one when password is empty, and returns the password it set.
+func (_self *UserService) Bootstrap(ctx context.Context, password string) (created bool, adminPassword string, err error) {
+	count, err := _self.userDao.CountUsers(ctx, dao.QueryUserData{})
+	if err != nil || count > 0 {
+		return false, "", err
+	}
+	if password == "" {
+		password, err = randomPassword()
+		if err != nil {
+			return false, "", err
+		}
+	}
+
+	admin := &dao.UserData{Name: "admin", Password: password, Permission: dao.USER_ADMIN}
+	err = _self.CreateUser(ctx, admin)
+	if err != nil {
+		return false, "", fmt.Errorf("bootstrap admin: %v", err)
+	}
+	return true, password, nil
+}
+
+func randomPassword() (string, error) {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
 	}
-	return true
+	return hex.EncodeToString(buf), nil
 }
@@ -0,0 +1,456 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+	"varconf-server/core/moudle/logger"
+)
+
+const (
+	webhookMaxAttempts = 5
+	webhookQueueSize   = 1024
+	webhookWorkers     = 4
+	webhookRetention   = 7 * 24 * time.Hour
+	// a pending delivery unclaimed this long lost its queue, the retry cron takes it over
+	webhookStaleAfter = 5 * time.Minute
+	webhookRetryBatch = webhookQueueSize / 4
+)
+
+// webhookTask is an event to hand to the webhooks of its app, or a delivery to send.
+type webhookTask struct {
+	event    *AppEvent
+	webhook  *dao.WebhookData
+	delivery *dao.WebhookDeliveryData
+}
+
+type WebhookService struct {
+	appDao             *dao.AppDao
+	webhookDao         *dao.WebhookDao
+	webhookDeliveryDao *dao.WebhookDeliveryDao
+	client             *http.Client
+	taskChan           chan *webhookTask
+	cleanCron          *cron.Cron
+	retryCron          *cron.Cron
+}
+
+func NewWebhookService(db *common.DB, eventHub *EventHub) *WebhookService {
+	webhookService := WebhookService{
+		appDao:             dao.NewAppDao(db),
+		webhookDao:         dao.NewWebhookDao(db),
+		webhookDeliveryDao: dao.NewWebhookDeliveryDao(db),
+		client:             &http.Client{Timeout: 10 * time.Second},
+		taskChan:           make(chan *webhookTask, webhookQueueSize),
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		go webhookService.work()
+	}
+	eventHub.Subscribe(webhookService.dispatch)
+	return &webhookService
+}
+
+func (_self *WebhookService) QueryWebhooks(ctx context.Context, appId int64) ([]*dao.WebhookData, error) {
+	return _self.webhookDao.QueryWebhooks(ctx, appId)
+}
+
+func (_self *WebhookService) CreateWebhook(ctx context.Context, data *dao.WebhookData) error {
+	if err := _self.validate(data.Url, data.Events); err != nil {
+		return err
+	}
+	if data.Status != dao.WEBHOOK_DISABLED {
+		data.Status = dao.WEBHOOK_ENABLED
+	}
+	data.CreateTime = common.NowJsonTime()
+	data.UpdateTime = data.CreateTime
+
+	_, err := _self.webhookDao.InsertWebhook(ctx, data)
+	return err
+}
+
+// UpdateWebhook updates the url, secret, events and status among fields, an
+// empty secret clears it.
+func (_self *WebhookService) UpdateWebhook(ctx context.Context, data dao.WebhookData, fields common.Fields) error {
+	webhook, err := _self.webhookDao.QueryWebhook(ctx, data.AppId, data.WebhookId)
+	if err != nil {
+		return err
+	}
+	if !fields.Has("url") {
+		data.Url = webhook.Url
+	}
+	if !fields.Has("events") {
+		data.Events = webhook.Events
+	}
+	if err := _self.validate(data.Url, data.Events); err != nil {
+		return err
+	}
+	if fields.Has("status") && data.Status != dao.WEBHOOK_ENABLED && data.Status != dao.WEBHOOK_DISABLED {
+		return fmt.Errorf("unknown webhook status %d", data.Status)
+	}
+	data.UpdateTime = common.NowJsonTime()
+	fields = fields.Only("url", "secret", "events", "status").With("updateTime")
+
+	rowCnt, err := _self.webhookDao.SelectedUpdateWebhook(ctx, data, fields)
+	if err != nil {
+		return err
+	}
+	if rowCnt != 1 {
+		return common.NotFound("webhook")
+	}
+	return nil
+}
+
+func (_self *WebhookService) DeleteWebhook(ctx context.Context, appId, webhookId int64) error {
+	rowCnt, err := _self.webhookDao.DeleteWebhook(ctx, appId, webhookId)
+	if err != nil {
+		return err
+	}
+	if rowCnt != 1 {
+		return common.NotFound("webhook")
+	}
+	return nil
+}
+
+func (_self *WebhookService) PageQueryDeliveries(ctx context.Context, appId, webhookId int64, pageIndex, pageSize int64) ([]*dao.WebhookDeliveryData, int64, int64, error) {
+	_, err := _self.webhookDao.QueryWebhook(ctx, appId, webhookId)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	start := (pageIndex - 1) * pageSize
+	end := pageSize
+
+	pageData, err := _self.webhookDeliveryDao.QueryWebhookDeliveries(ctx, dao.QueryWebhookDeliveryData{WebhookId: webhookId, Start: start, End: end})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	totalCount, err := _self.webhookDeliveryDao.CountWebhookDeliveries(ctx, webhookId)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	pageCount := totalCount / pageSize
+	if totalCount%pageSize != 0 {
+		pageCount += 1
+	}
+	return pageData, pageCount, totalCount, nil
+}
+
+// Redeliver sends the payload of a past delivery again as a new delivery.
+func (_self *WebhookService) Redeliver(ctx context.Context, appId, webhookId, deliveryId int64) (*dao.WebhookDeliveryData, error) {
+	webhook, err := _self.webhookDao.QueryWebhook(ctx, appId, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	deliveries, err := _self.webhookDeliveryDao.QueryWebhookDeliveries(ctx, dao.QueryWebhookDeliveryData{WebhookId: webhookId, DeliveryId: deliveryId})
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) != 1 {
+		return nil, common.NotFound("delivery")
+	}
+
+	delivery, err := _self.newDelivery(ctx, webhook, deliveries[0].Event, deliveries[0].Payload)
+	if err != nil {
+		return nil, err
+	}
+	if !_self.enqueue(&webhookTask{webhook: webhook, delivery: delivery}) {
+		return delivery, errors.New("webhook queue is full")
+	}
+	return delivery, nil
+}
+
+func (_self *WebhookService) CronClean(spec string) {
+	c := cron.New()
+	c.AddFunc(spec, func() {
+		_, err := _self.webhookDeliveryDao.DeleteWebhookDeliveries(context.Background(), time.Now().Add(-webhookRetention))
+		if err != nil {
+			logger.Error("webhook: clean deliveries error", "error", err)
+		}
+	})
+	c.Start()
+	_self.cleanCron = c
+}
+
+// CronRetry re-enqueues the pending deliveries which lost their queue, to a
+// full queue or a restart.
+func (_self *WebhookService) CronRetry(spec string) {
+	c := cron.New()
+	c.AddFunc(spec, _self.retryPending)
+	c.Start()
+	_self.retryCron = c
+}
+
+// Stop stops the crons, the deliveries left pending are retried after a restart.
+func (_self *WebhookService) Stop() {
+	if _self.cleanCron != nil {
+		_self.cleanCron.Stop()
+	}
+	if _self.retryCron != nil {
+		_self.retryCron.Stop()
+	}
+}
+
+func (_self *WebhookService) retryPending() {
+	ctx := context.Background()
+	before := time.Now().Add(-webhookStaleAfter)
+	deliveries, err := _self.webhookDeliveryDao.QueryPendingDeliveries(ctx, before, webhookRetryBatch)
+	if err != nil {
+		logger.Error("webhook: query pending deliveries error", "error", err)
+		return
+	}
+
+	webhooks := make(map[int64]*dao.WebhookData)
+	for _, delivery := range deliveries {
+		webhook, exist := webhooks[delivery.WebhookId]
+		if !exist {
+			webhook, err = _self.webhookDao.QueryWebhook(ctx, delivery.AppId, delivery.WebhookId)
+			if err != nil && !errors.Is(err, common.ErrNotFound) {
+				logger.Error("webhook: query webhook error", "webhook_id", delivery.WebhookId, "error", err)
+				return
+			}
+			webhooks[delivery.WebhookId] = webhook
+		}
+		if webhook == nil || webhook.Status != dao.WEBHOOK_ENABLED {
+			delivery.Status = dao.DELIVERY_FAILED
+			delivery.Error = "webhook is deleted or disabled"
+			delivery.UpdateTime = common.NowJsonTime()
+			_self.updateDelivery(delivery)
+			continue
+		}
+
+		// another node may take it over at the same time
+		now := common.NowJsonTime()
+		rowCnt, err := _self.webhookDeliveryDao.ClaimWebhookDelivery(ctx, delivery.DeliveryId, before, now.Time)
+		if err != nil {
+			logger.Error("webhook: claim delivery error", "delivery_id", delivery.DeliveryId, "error", err)
+			return
+		}
+		if rowCnt != 1 {
+			continue
+		}
+		delivery.ClaimTime = now
+		if !_self.enqueue(&webhookTask{webhook: webhook, delivery: delivery}) {
+			// still pending, tried again once stale
+			return
+		}
+	}
+}
+
+// dispatch queues event, the webhooks are looked up and the deliveries created
+// by a worker apart from the release or the edit.
+func (_self *WebhookService) dispatch(event *AppEvent) {
+	if !_self.enqueue(&webhookTask{event: event}) {
+		logger.Warn("webhook: queue is full, drop event", "event", event.Type, "app_id", event.AppId)
+	}
+}
+
+func (_self *WebhookService) createDeliveries(event *AppEvent) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("webhook: create deliveries error", "event", event.Type, "app_id", event.AppId, "error", fmt.Sprint(err))
+		}
+	}()
+
+	ctx := context.Background()
+	webhooks, err := _self.webhookDao.QueryWebhooks(ctx, event.AppId)
+	if err != nil {
+		logger.Error("webhook: query webhooks error", "app_id", event.AppId, "error", err)
+		return
+	}
+	if event.Type == EVENT_APP_DELETE {
+		// the webhooks go with the app once told
+		defer func() {
+			_, err := _self.webhookDao.DeleteWebhooks(ctx, event.AppId)
+			if err != nil {
+				logger.Error("webhook: delete webhooks error", "app_id", event.AppId, "error", err)
+			}
+		}()
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	// encode payload
+	app := event.App
+	if app == nil {
+		app, _ = _self.appDao.QueryApp(ctx, event.AppId)
+	}
+	payload := map[string]interface{}{"event": event}
+	if app != nil {
+		payload["app"] = map[string]interface{}{"appId": app.AppId, "name": app.Name, "code": app.Code}
+	}
+	content, err := json.Marshal(payload)
+	if err != nil {
+		logger.Error("webhook: encode payload error", "event", event.Type, "app_id", event.AppId, "error", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if webhook.Status != dao.WEBHOOK_ENABLED || !_self.subscribed(webhook, event.Type) {
+			continue
+		}
+		delivery, err := _self.newDelivery(ctx, webhook, event.Type, string(content))
+		if err != nil {
+			logger.Error("webhook: create delivery error", "webhook_id", webhook.WebhookId, "error", err)
+			continue
+		}
+		if !_self.enqueue(&webhookTask{webhook: webhook, delivery: delivery}) {
+			logger.Warn("webhook: queue is full, delivery is left pending", "delivery_id", delivery.DeliveryId)
+		}
+	}
+}
+
+func (_self *WebhookService) newDelivery(ctx context.Context, webhook *dao.WebhookData, event, payload string) (*dao.WebhookDeliveryData, error) {
+	delivery := &dao.WebhookDeliveryData{
+		WebhookId:  webhook.WebhookId,
+		AppId:      webhook.AppId,
+		Event:      event,
+		Payload:    payload,
+		Status:     dao.DELIVERY_PENDING,
+		ClaimTime:  common.NowJsonTime(),
+		CreateTime: common.NowJsonTime(),
+		UpdateTime: common.NowJsonTime(),
+	}
+	_, err := _self.webhookDeliveryDao.InsertWebhookDelivery(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (_self *WebhookService) enqueue(task *webhookTask) bool {
+	select {
+	case _self.taskChan <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+func (_self *WebhookService) work() {
+	for task := range _self.taskChan {
+		if task.event != nil {
+			_self.createDeliveries(task.event)
+		} else {
+			_self.deliver(task)
+		}
+	}
+}
+
+func (_self *WebhookService) deliver(task *webhookTask) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("webhook: deliver error", "delivery_id", task.delivery.DeliveryId, "error", fmt.Sprint(err))
+		}
+	}()
+
+	// a copy queued again by the retry cron is sent once, the worker taking the
+	// attempt first claims the delivery for the post and the backoff
+	delivery := task.delivery
+	rowCnt, err := _self.webhookDeliveryDao.AttemptWebhookDelivery(context.Background(), delivery.DeliveryId, delivery.Attempts, time.Now())
+	if err != nil {
+		logger.Error("webhook: claim delivery error", "delivery_id", delivery.DeliveryId, "error", err)
+		return
+	}
+	if rowCnt != 1 {
+		return
+	}
+	delivery.Attempts++
+	delivery.ResponseCode, delivery.Error = _self.post(task.webhook, delivery)
+	delivery.UpdateTime = common.NowJsonTime()
+	if delivery.Error == "" {
+		delivery.Status = dao.DELIVERY_SUCCEED
+	} else if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = dao.DELIVERY_FAILED
+	}
+	_self.updateDelivery(delivery)
+	if delivery.Status != dao.DELIVERY_PENDING {
+		return
+	}
+
+	// retry with exponential backoff
+	backoff := time.Duration(1<<uint(delivery.Attempts-1)) * time.Second
+	time.AfterFunc(backoff, func() {
+		if !_self.enqueue(task) {
+			logger.Warn("webhook: queue is full, delivery is left pending", "delivery_id", delivery.DeliveryId)
+		}
+	})
+}
+
+func (_self *WebhookService) updateDelivery(delivery *dao.WebhookDeliveryData) {
+	_, err := _self.webhookDeliveryDao.UpdateWebhookDelivery(context.Background(), delivery)
+	if err != nil {
+		logger.Error("webhook: update delivery error", "delivery_id", delivery.DeliveryId, "error", err)
+	}
+}
+
+func (_self *WebhookService) post(webhook *dao.WebhookData, delivery *dao.WebhookDeliveryData) (int, string) {
+	request, err := http.NewRequest(http.MethodPost, webhook.Url, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "varconf-webhook")
+	request.Header.Set("X-Varconf-Event", delivery.Event)
+	request.Header.Set("X-Varconf-Delivery", fmt.Sprintf("%d", delivery.DeliveryId))
+	if webhook.Secret != "" {
+		request.Header.Set("X-Varconf-Signature", "sha256="+_self.sign(webhook.Secret, delivery.Payload))
+	}
+
+	response, err := _self.client.Do(request)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Sprintf("unexpected status %d: %s", response.StatusCode, bytes.TrimSpace(body))
+	}
+	return response.StatusCode, ""
+}
+
+// sign is the hex HMAC-SHA256 of payload, which receivers recompute with the shared secret.
+func (_self *WebhookService) sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (_self *WebhookService) subscribed(webhook *dao.WebhookData, event string) bool {
+	for _, e := range strings.Split(webhook.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (_self *WebhookService) validate(rawUrl, events string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook url must be an absolute http(s) url")
+	}
+	for _, e := range strings.Split(events, ",") {
+		switch strings.TrimSpace(e) {
+		case EVENT_CONFIG_EDIT, EVENT_RELEASE, EVENT_APP_DELETE:
+		default:
+			return fmt.Errorf("unknown webhook event %q", e)
+		}
+	}
+	return nil
+}
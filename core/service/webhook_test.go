@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/dao/common"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDb opens a migrated SQLite database in a temp dir, the returned func
+// closes and removes it.
+func openTestDb(t *testing.T) (*common.DB, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "varconf-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dao.OpenStorage("sqlite", "file:"+filepath.Join(dir, "varconf.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	if _, err = dao.NewSchemaDao(db).MigrateUp(context.Background(), 0); err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+type webhookRequest struct {
+	header http.Header
+	body   string
+}
+
+// webhookServer answers the first failures posts with 500, then 200.
+func webhookServer(failures int) (*httptest.Server, chan *webhookRequest) {
+	requests := make(chan *webhookRequest, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- &webhookRequest{header: r.Header, body: string(body)}
+		if failures > 0 {
+			failures--
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	return server, requests
+}
+
+func receiveWebhook(t *testing.T, requests chan *webhookRequest, timeout time.Duration) *webhookRequest {
+	t.Helper()
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(timeout):
+		t.Fatal("webhook wasn't posted")
+		return nil
+	}
+}
+
+// waitDelivery waits for the only delivery of webhook to leave pending.
+func waitDelivery(t *testing.T, db *common.DB, webhookId int64) *dao.WebhookDeliveryData {
+	t.Helper()
+	deliveryDao := dao.NewWebhookDeliveryDao(db)
+	for i := 0; i < 50; i++ {
+		deliveries, err := deliveryDao.QueryWebhookDeliveries(context.Background(), dao.QueryWebhookDeliveryData{WebhookId: webhookId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status != dao.DELIVERY_PENDING {
+			return deliveries[0]
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("delivery is still pending")
+	return nil
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	server, requests := webhookServer(1)
+	defer server.Close()
+
+	eventHub := NewEventHub()
+	webhookService := NewWebhookService(db, eventHub)
+	webhook := &dao.WebhookData{AppId: 1, Url: server.URL, Secret: "s3cret", Events: EVENT_RELEASE}
+	if err := webhookService.CreateWebhook(context.Background(), webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	eventHub.Publish(&AppEvent{Type: EVENT_CONFIG_EDIT, AppId: 1, Keys: []string{"a"}})
+	eventHub.Publish(&AppEvent{Type: EVENT_RELEASE, AppId: 1, Keys: []string{"a"}, ReleaseIndex: 1})
+
+	request := receiveWebhook(t, requests, time.Second)
+	if event := request.header.Get("X-Varconf-Event"); event != EVENT_RELEASE {
+		t.Fatalf("got event %q, want %q", event, EVENT_RELEASE)
+	}
+	signature := "sha256=" + webhookService.sign("s3cret", request.body)
+	if request.header.Get("X-Varconf-Signature") != signature {
+		t.Fatalf("got signature %q, want %q", request.header.Get("X-Varconf-Signature"), signature)
+	}
+
+	// the 500 is retried after a second
+	retried := receiveWebhook(t, requests, 3*time.Second)
+	if retried.body != request.body {
+		t.Fatalf("retried %q, want %q", retried.body, request.body)
+	}
+	delivery := waitDelivery(t, db, webhook.WebhookId)
+	if delivery.Status != dao.DELIVERY_SUCCEED || delivery.Attempts != 2 || delivery.ResponseCode != http.StatusOK {
+		t.Fatalf("delivery ended %d after %d attempts with %d", delivery.Status, delivery.Attempts, delivery.ResponseCode)
+	}
+}
+
+func TestWebhookRetryPending(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	server, requests := webhookServer(0)
+	defer server.Close()
+
+	webhookService := NewWebhookService(db, NewEventHub())
+	webhook := &dao.WebhookData{AppId: 1, Url: server.URL, Events: EVENT_RELEASE}
+	if err := webhookService.CreateWebhook(context.Background(), webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	// left pending by a full queue or a restart
+	deliveryDao := dao.NewWebhookDeliveryDao(db)
+	stale := common.JsonTime{Time: time.Now().Add(-2 * webhookStaleAfter)}
+	fresh := &dao.WebhookDeliveryData{WebhookId: webhook.WebhookId, AppId: 1, Event: EVENT_RELEASE, Payload: `{"fresh":true}`,
+		Status: dao.DELIVERY_PENDING, ClaimTime: common.NowJsonTime(), CreateTime: stale, UpdateTime: stale}
+	lost := &dao.WebhookDeliveryData{WebhookId: webhook.WebhookId, AppId: 1, Event: EVENT_RELEASE, Payload: `{"lost":true}`,
+		Status: dao.DELIVERY_PENDING, Attempts: 1, ClaimTime: stale, CreateTime: stale, UpdateTime: stale}
+	for _, delivery := range []*dao.WebhookDeliveryData{fresh, lost} {
+		if _, err := deliveryDao.InsertWebhookDelivery(context.Background(), delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	webhookService.retryPending()
+	if request := receiveWebhook(t, requests, time.Second); request.body != lost.Payload {
+		t.Fatalf("posted %q, want %q", request.body, lost.Payload)
+	}
+	select {
+	case request := <-requests:
+		t.Fatalf("posted %q, the fresh delivery isn't stale yet", request.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a claimed delivery isn't taken twice
+	webhookService.retryPending()
+	select {
+	case request := <-requests:
+		t.Fatalf("posted %q again", request.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestWebhookQueuedTwice sends a delivery once while a copy taken over by the
+// retry cron waits in the queue next to the first one.
+func TestWebhookQueuedTwice(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	server, requests := webhookServer(0)
+	defer server.Close()
+
+	webhookService := NewWebhookService(db, NewEventHub())
+	webhook := &dao.WebhookData{AppId: 1, Url: server.URL, Events: EVENT_RELEASE}
+	if err := webhookService.CreateWebhook(context.Background(), webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first copy stayed in the queue past the stale time
+	stale := common.JsonTime{Time: time.Now().Add(-2 * webhookStaleAfter)}
+	delivery := &dao.WebhookDeliveryData{WebhookId: webhook.WebhookId, AppId: 1, Event: EVENT_RELEASE, Payload: `{"once":true}`,
+		Status: dao.DELIVERY_PENDING, ClaimTime: stale, CreateTime: stale, UpdateTime: stale}
+	if _, err := dao.NewWebhookDeliveryDao(db).InsertWebhookDelivery(context.Background(), delivery); err != nil {
+		t.Fatal(err)
+	}
+	queued := *delivery
+	webhookService.retryPending()
+	webhookService.enqueue(&webhookTask{webhook: webhook, delivery: &queued})
+
+	receiveWebhook(t, requests, time.Second)
+	select {
+	case request := <-requests:
+		t.Fatalf("posted %q twice", request.body)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if delivery := waitDelivery(t, db, webhook.WebhookId); delivery.Status != dao.DELIVERY_SUCCEED || delivery.Attempts != 1 {
+		t.Fatalf("delivery ended %d after %d attempts", delivery.Status, delivery.Attempts)
+	}
+}
+
+func TestWebhookUpdate(t *testing.T) {
+	db, closeDb := openTestDb(t)
+	defer closeDb()
+	ctx := context.Background()
+
+	webhookService := NewWebhookService(db, NewEventHub())
+	webhook := &dao.WebhookData{AppId: 1, Url: "http://127.0.0.1/hook", Secret: "s3cret", Events: EVENT_RELEASE}
+	if err := webhookService.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+	query := func() *dao.WebhookData {
+		t.Helper()
+		data, err := dao.NewWebhookDao(db).QueryWebhook(ctx, 1, webhook.WebhookId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	update := dao.WebhookData{AppId: 1, WebhookId: webhook.WebhookId, Events: "rollback"}
+	if err := webhookService.UpdateWebhook(ctx, update, common.NewFields("events")); err == nil {
+		t.Fatal("subscribed to an event which is never emitted")
+	}
+
+	update = dao.WebhookData{AppId: 1, WebhookId: webhook.WebhookId, Events: EVENT_RELEASE + "," + EVENT_APP_DELETE}
+	if err := webhookService.UpdateWebhook(ctx, update, common.NewFields("events")); err != nil {
+		t.Fatal(err)
+	}
+	if data := query(); data.Secret != "s3cret" || data.Url != webhook.Url {
+		t.Fatalf("update of events changed secret %q and url %q", data.Secret, data.Url)
+	}
+
+	update = dao.WebhookData{AppId: 1, WebhookId: webhook.WebhookId}
+	if err := webhookService.UpdateWebhook(ctx, update, common.NewFields("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if data := query(); data.Secret != "" {
+		t.Fatalf("secret %q wasn't cleared", data.Secret)
+	}
+}
@@ -2,9 +2,13 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+
+	daocommon "varconf-server/core/dao/common"
+	"varconf-server/core/moudle/logger"
 )
 
 const (
@@ -31,10 +35,34 @@ func ReadJson(r *http.Request, v interface{}) error {
 	return json.Unmarshal(body, v)
 }
 
+// ReadJsonFields reads the json body into v and returns the fields it holds,
+// the ones a PATCH sets.
+func ReadJsonFields(r *http.Request, v interface{}) (daocommon.Fields, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	fields := daocommon.NewFields()
+	for name := range raw {
+		fields[name] = true
+	}
+	return fields, nil
+}
+
 func WriteJson(w http.ResponseWriter, v interface{}, code int) {
 	content, err := json.Marshal(v)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.Error("web: encode response error", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
@@ -53,6 +81,52 @@ func WriteErrorResponseWithCode(w http.ResponseWriter, data interface{}, httpCod
 	WriteJson(w, responseData, httpCode)
 }
 
+// StatusClientClosed answers a request whose client went away before it was
+// served, nobody reads it but it keeps the logs and metrics apart from 5xx.
+const StatusClientClosed = 499
+
+// WriteError answers err with the status of its kind, 404 for a missing row,
+// 409 for a conflict, 503 for an unreachable database, 499 for a cancelled
+// request, 500 for any other database error and 400 for the rest. The 5xx are
+// answered with their status text only. A stale edit is answered with the
+// current row so the client can merge.
+func WriteError(w http.ResponseWriter, err error) {
+	var stale *daocommon.StaleError
+	if errors.As(err, &stale) {
+		WriteErrorResponseWithCode(w, stale.Current, http.StatusConflict)
+		return
+	}
+
+	// the database errors carry the driver text, it's logged and not answered
+	status := ErrorStatus(err)
+	switch status {
+	case http.StatusInternalServerError:
+		logger.Error("web: request error", "error", err)
+		WriteErrorResponseWithCode(w, http.StatusText(status), status)
+	case http.StatusServiceUnavailable:
+		WriteErrorResponseWithCode(w, http.StatusText(status), status)
+	default:
+		WriteErrorResponseWithCode(w, err.Error(), status)
+	}
+}
+
+func ErrorStatus(err error) int {
+	var daoErr *daocommon.Error
+	switch {
+	case errors.Is(err, daocommon.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, daocommon.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, daocommon.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, daocommon.ErrCanceled):
+		return StatusClientClosed
+	case errors.As(err, &daoErr):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
 func WriteSucceedResponse(w http.ResponseWriter, data interface{}) {
 	responseData := ResponseData{Success: true, Code: CODE_SUCCEED, Data: data}
 	WriteJson(w, responseData, http.StatusOK)
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	daocommon "varconf-server/core/dao/common"
+)
+
+func TestWriteError(t *testing.T) {
+	driverErr := errors.New("Error 1146: Table 'varconf.app' doesn't exist")
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{"bad request", errors.New("name is empty"), http.StatusBadRequest, "name is empty"},
+		{"not found", daocommon.ErrNotFound, http.StatusNotFound, daocommon.ErrNotFound.Error()},
+		{"conflict", daocommon.Conflict("app is linked by other apps"), http.StatusConflict, "conflict: app is linked by other apps"},
+		{"unavailable", &daocommon.Error{Kind: daocommon.ErrUnavailable, Err: driverErr}, http.StatusServiceUnavailable, "Service Unavailable"},
+		{"driver error", &daocommon.Error{Err: driverErr}, http.StatusInternalServerError, "Internal Server Error"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			WriteError(recorder, test.err)
+			if recorder.Code != test.status {
+				t.Fatalf("answered %d, want %d", recorder.Code, test.status)
+			}
+			responseData := ResponseData{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &responseData); err != nil {
+				t.Fatal(err)
+			}
+			if responseData.Success || responseData.Data != test.message {
+				t.Fatalf("answered %v, want %q", responseData.Data, test.message)
+			}
+		})
+	}
+}
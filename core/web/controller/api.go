@@ -1,11 +1,20 @@
 package controller
 
 import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"varconf-server/core/dao"
+	daocommon "varconf-server/core/dao/common"
+	"varconf-server/core/moudle/poll"
 	"varconf-server/core/moudle/router"
 	"varconf-server/core/service"
 	"varconf-server/core/web/common"
@@ -14,21 +23,29 @@ import (
 type ApiController struct {
 	common.Controller
 
-	authService   *service.AuthService
-	configService *service.ConfigService
+	authService     *service.AuthService
+	configService   *service.ConfigService
+	clientService   *service.ClientService
+	fallbackService *service.FallbackService
+	trustedProxies  []*net.IPNet
 }
 
 type ConfigValue struct {
 	Key       string `json:"key"`
 	Value     string `json:"value"`
+	Type      int    `json:"type"`
 	Timestamp int64  `json:"timestamp"`
 }
 
-func InitApiController(s *router.Router, authService *service.AuthService, configService *service.ConfigService) *ApiController {
-	apiController := ApiController{authService: authService, configService: configService}
+func InitApiController(s *router.Router, authService *service.AuthService, configService *service.ConfigService,
+	clientService *service.ClientService, fallbackService *service.FallbackService, trustedProxies []*net.IPNet) *ApiController {
+	apiController := ApiController{authService: authService, configService: configService, clientService: clientService,
+		fallbackService: fallbackService, trustedProxies: trustedProxies}
 
 	s.Get("/api/config", apiController.watchApp)
 	s.Get("/api/config/:key", apiController.watchKey)
+	s.Get("/api/flags/:key/evaluate", apiController.evaluateFlag)
+	s.Post("/api/flags/:key/evaluate", apiController.evaluateFlag)
 
 	return &apiController
 }
@@ -42,19 +59,27 @@ func (_self *ApiController) watchApp(w http.ResponseWriter, r *http.Request, c *
 		return
 	}
 
-	// parse releaseIndex form url
+	// parse releaseIndex and key filter form url
 	params := r.URL.Query()
 	lastIndex, _ := strconv.ParseInt(params.Get("lastIndex"), 10, 32)
 	longPull, _ := strconv.ParseBool(params.Get("longPull"))
+	filter := service.NewKeyFilter(params.Get("keys"), params.Get("prefix"))
 
-	// http long poll for config
+	// http long poll for config, the client holds lastIndex while it waits
 	if longPull == true {
-		_self.pullAndResponse(w, appData.AppId, "", int(lastIndex))
+		_self.reportClient(r, appData.AppId, int(lastIndex))
+		if recentIndex := _self.pullAndResponse(w, r, appData, "", filter, int(lastIndex)); recentIndex != 0 {
+			_self.reportClient(r, appData.AppId, recentIndex)
+		}
 		return
 	}
 
-	// query config
-	_self.queryAndResponse(w, appData.AppId, "", 0, true)
+	// query config, the client holds the release it's answered with
+	recentIndex, _ := _self.queryAndResponse(r.Context(), w, appData, "", filter, 0, r.Header.Get("If-None-Match"), true)
+	if recentIndex == 0 {
+		recentIndex = int(lastIndex)
+	}
+	_self.reportClient(r, appData.AppId, recentIndex)
 }
 
 // GET /api/config/:key
@@ -72,46 +97,128 @@ func (_self *ApiController) watchKey(w http.ResponseWriter, r *http.Request, c *
 	lastIndex, _ := strconv.ParseInt(params.Get("lastIndex"), 10, 32)
 	longPull, _ := strconv.ParseBool(params.Get("longPull"))
 
-	// http long poll for config
+	// http long poll for config, the client holds lastIndex while it waits
 	if longPull == true {
-		_self.pullAndResponse(w, appData.AppId, key, int(lastIndex))
+		_self.reportClient(r, appData.AppId, int(lastIndex))
+		if recentIndex := _self.pullAndResponse(w, r, appData, key, nil, int(lastIndex)); recentIndex != 0 {
+			_self.reportClient(r, appData.AppId, recentIndex)
+		}
 		return
 	}
 
-	// query config
-	_self.queryAndResponse(w, appData.AppId, key, 0, true)
+	// query config, the client holds the release it's answered with
+	recentIndex, _ := _self.queryAndResponse(r.Context(), w, appData, key, nil, 0, r.Header.Get("If-None-Match"), true)
+	if recentIndex == 0 {
+		recentIndex = int(lastIndex)
+	}
+	_self.reportClient(r, appData.AppId, recentIndex)
 }
 
-func (_self *ApiController) pullAndResponse(w http.ResponseWriter, appId int64, key string, lastIndex int) {
-	success := _self.queryAndResponse(w, appId, key, lastIndex, false)
-	if success {
+// GET|POST /api/flags/:key/evaluate
+func (_self *ApiController) evaluateFlag(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// get appData from context
+	appData := c.Data["app"].(*dao.AppData)
+	if appData == nil {
+		http.Error(w, "", http.StatusBadRequest)
 		return
 	}
 
-	messagePoll, pollElement := _self.configService.PullRelease(appId, key, lastIndex)
+	// parse context attributes from url or json body
+	params := r.URL.Query()
+	key := params.Get(":key")
+	attributes := make(map[string]string)
+	if r.Method == http.MethodPost {
+		err := common.ReadJson(r, &attributes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		for name := range params {
+			if name != "token" && !strings.HasPrefix(name, ":") {
+				attributes[name] = params.Get(name)
+			}
+		}
+	}
+
+	// evaluate flag
+	result, recentIndex, err := _self.configService.EvaluateFlag(r.Context(), appData.AppId, key, attributes)
+	if err != nil {
+		apiError(w, err)
+		return
+	}
+	if result == nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["recentIndex"] = recentIndex
+	dataMap["data"] = result
+	common.WriteJson(w, dataMap, http.StatusOK)
+}
+
+// pullAndResponse answers when there are configs newer than lastIndex, it
+// returns the release index written, 0 when none is.
+func (_self *ApiController) pullAndResponse(w http.ResponseWriter, r *http.Request, appData *dao.AppData, key string, filter *service.KeyFilter, lastIndex int) int {
+	recentIndex, success := _self.queryAndResponse(r.Context(), w, appData, key, filter, lastIndex, "", false)
+	if success {
+		return recentIndex
+	}
+
+	messagePoll, pollElement := _self.configService.PullRelease(appData.AppId, key, filter, lastIndex)
 	select {
-	case <-pollElement.Chan():
+	case data := <-pollElement.Chan():
 		messagePoll.Remove(pollElement)
-		_self.queryAndResponse(w, appId, key, 0, true)
+		if data == poll.CLOSED {
+			// shutting down, the client reconnects to another node
+			http.Error(w, "", http.StatusNotModified)
+			return 0
+		}
+		recentIndex, _ = _self.queryAndResponse(r.Context(), w, appData, key, filter, 0, "", true)
+		return recentIndex
 
 	case <-time.After(60 * time.Second):
 		messagePoll.Remove(pollElement)
 		http.Error(w, "", http.StatusNotModified)
 
-	case <-w.(http.CloseNotifier).CloseNotify():
+	case <-r.Context().Done():
 		messagePoll.Remove(pollElement)
 	}
+	return 0
 }
 
-func (_self *ApiController) queryAndResponse(w http.ResponseWriter, appId int64, key string, lastIndex int, lastCall bool) bool {
-	configMap, recentIndex := _self.queryReleaseConfig(appId, lastIndex)
-	if configMap == nil {
+// queryAndResponse writes the released configs and returns their release
+// index, it returns false without writing when there are none newer than
+// lastIndex unless lastCall is set. A failed query is always answered.
+func (_self *ApiController) queryAndResponse(ctx context.Context, w http.ResponseWriter, appData *dao.AppData, key string, filter *service.KeyFilter,
+	lastIndex int, ifNoneMatch string, lastCall bool) (int, bool) {
+	configList, recentIndex, saveTime, err := _self.queryRelease(ctx, appData)
+	if err != nil {
+		apiError(w, err)
+		return 0, true
+	}
+	if configList == nil || recentIndex == lastIndex {
 		if lastCall {
 			http.Error(w, "", http.StatusNotFound)
 		}
-		return false
+		return 0, false
+	}
+
+	// conditional get, the etag only depends on the release so it's checked
+	// before the payload is built
+	scope := key
+	if filter != nil {
+		scope = filter.String()
+	}
+	etag := _self.etag(appData.AppId, scope, recentIndex)
+	if _self.matchETag(ifNoneMatch, etag) {
+		_self.writeHeader(w, etag, saveTime)
+		w.WriteHeader(http.StatusNotModified)
+		return recentIndex, true
 	}
 
+	configMap := _self.configValues(configList, filter)
 	dataMap := make(map[string]interface{})
 	if key != "" {
 		// key watch
@@ -120,42 +227,106 @@ func (_self *ApiController) queryAndResponse(w http.ResponseWriter, appId int64,
 			if lastCall {
 				http.Error(w, "", http.StatusNotFound)
 			}
-			return false
+			return 0, false
 		}
 
 		dataMap["recentIndex"] = recentIndex
 		dataMap["data"] = configValue
 	} else {
-		// app watch
+		// app or subset watch
 		if len(configMap) == 0 {
 			if lastCall {
 				http.Error(w, "", http.StatusNotFound)
 			}
-			return false
+			return 0, false
 		}
 
 		dataMap["recentIndex"] = recentIndex
 		dataMap["data"] = configMap
 	}
 
+	_self.writeHeader(w, etag, saveTime)
 	common.WriteJson(w, dataMap, http.StatusOK)
-	return true
+	return recentIndex, true
 }
 
-func (_self *ApiController) queryReleaseConfig(appId int64, lastIndex int) (map[string]*ConfigValue, int) {
-	configList, releaseIndex := _self.configService.QueryRelease(appId)
-	if configList == nil || releaseIndex == lastIndex {
-		return nil, 0
+// queryRelease returns the released configs. While the database can't be
+// reached it returns those saved by the fallback service and their save time.
+func (_self *ApiController) queryRelease(ctx context.Context, appData *dao.AppData) ([]dao.ConfigData, int, time.Time, error) {
+	configList, releaseIndex, err := _self.configService.QueryRelease(ctx, appData.AppId)
+	if errors.Is(err, daocommon.ErrUnavailable) {
+		fallbackList, fallbackIndex, saveTime, ok := _self.fallbackService.Release(appData.AppId)
+		if ok {
+			return fallbackList, fallbackIndex, saveTime, nil
+		}
+	} else if err == nil && configList != nil {
+		_self.fallbackService.Save(appData, configList, releaseIndex)
 	}
+	return configList, releaseIndex, time.Time{}, err
+}
 
+func (_self *ApiController) configValues(configList []dao.ConfigData, filter *service.KeyFilter) map[string]*ConfigValue {
 	configMap := make(map[string]*ConfigValue)
 	for _, configData := range configList {
+		if filter != nil && !filter.Match(configData.Key) {
+			continue
+		}
 		configMap[configData.Key] = &ConfigValue{
 			Key:       configData.Key,
 			Value:     configData.Value,
+			Type:      configData.Type,
 			Timestamp: configData.UpdateTime.Unix(),
 		}
 	}
+	return configMap
+}
 
-	return configMap, releaseIndex
+func (_self *ApiController) writeHeader(w http.ResponseWriter, etag string, saveTime time.Time) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if !saveTime.IsZero() {
+		w.Header().Set("Varconf-Stale", saveTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (_self *ApiController) reportClient(r *http.Request, appId int64, lastIndex int) {
+	_self.clientService.Report(dao.ClientData{
+		AppId:      appId,
+		InstanceId: r.Header.Get("Varconf-Instance-Id"),
+		Ip:         common.RemoteIP(r, _self.trustedProxies),
+		Hostname:   r.Header.Get("Varconf-Hostname"),
+		SdkVersion: r.Header.Get("Varconf-Sdk-Version"),
+		LastIndex:  lastIndex,
+	})
+}
+
+func (_self *ApiController) etag(appId int64, scope string, releaseIndex int) string {
+	hash := md5.New()
+	io.WriteString(hash, fmt.Sprintf("%d:%d:%s", appId, releaseIndex, scope))
+	return fmt.Sprintf("\"%x\"", hash.Sum(nil))
+}
+
+func (_self *ApiController) matchETag(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, value := range strings.Split(ifNoneMatch, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || strings.TrimPrefix(value, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// apiError answers a failed request of a client, the database errors only with
+// their status text so the driver messages don't leak to the clients.
+func apiError(w http.ResponseWriter, err error) {
+	status := common.ErrorStatus(err)
+	message := err.Error()
+	if status != http.StatusBadRequest {
+		message = http.StatusText(status)
+	}
+	http.Error(w, message, status)
 }
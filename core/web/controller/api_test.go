@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"varconf-server/core/dao"
+	daocommon "varconf-server/core/dao/common"
+	"varconf-server/core/moudle/bus"
+	"varconf-server/core/moudle/cache"
+	"varconf-server/core/moudle/router"
+	"varconf-server/core/service"
+	"varconf-server/core/web/interceptor"
+)
+
+type apiTest struct {
+	server        *httptest.Server
+	configService *service.ConfigService
+	app           *dao.AppData
+	closeFunc     func()
+}
+
+// newApiTest serves the client api of one app over a migrated sqlite database.
+func newApiTest(t *testing.T) *apiTest {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "varconf-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := dao.OpenStorage("sqlite", "file:"+filepath.Join(dir, "varconf.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	if _, err = dao.NewSchemaDao(db).MigrateUp(context.Background(), 0); err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	eventHub := service.NewEventHub()
+	releaseBus := bus.NewLocalBus()
+	configService := service.NewConfigService(db, releaseBus, cache.NewLruCache(16, time.Minute), eventHub)
+	fallbackService := service.NewFallbackService(db, eventHub, releaseBus, configService, dir)
+	authService := service.NewAuthService(db)
+	s := router.NewRouter()
+	interceptor.InitApiAuthInterceptor(s, authService, fallbackService)
+	InitApiController(s, authService, configService, service.NewClientService(db), fallbackService, nil)
+	server := httptest.NewServer(s)
+
+	app := &dao.AppData{Name: "a", Code: "a", ApiKey: "key-a", Public: dao.APP_PRIVATE,
+		CreateTime: daocommon.NowJsonTime(), UpdateTime: daocommon.NowJsonTime()}
+	if _, err = dao.NewAppDao(db).InsertApp(context.Background(), app); err != nil {
+		t.Fatal(err)
+	}
+	return &apiTest{server: server, configService: configService, app: app, closeFunc: func() {
+		server.Close()
+		configService.Stop()
+		db.Close()
+		os.RemoveAll(dir)
+	}}
+}
+
+// release creates the keys of values in the app and releases them.
+func (_self *apiTest) release(t *testing.T, values map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	for key, value := range values {
+		config := &dao.ConfigData{AppId: _self.app.AppId, Key: key, Value: value, CreateBy: "alice", UpdateBy: "alice"}
+		if err := _self.configService.CreateConfig(ctx, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := _self.configService.ReleaseConfig(ctx, _self.app.AppId, "alice"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (_self *apiTest) get(t *testing.T, query string, header http.Header) (*http.Response, map[string]*ConfigValue) {
+	t.Helper()
+	request, err := http.NewRequest(http.MethodGet, _self.server.URL+"/api/config?token="+_self.app.ApiKey+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return response, nil
+	}
+	body := struct {
+		Data map[string]*ConfigValue `json:"data"`
+	}{}
+	if err = json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return response, body.Data
+}
+
+func TestWatchAppFilter(t *testing.T) {
+	api := newApiTest(t)
+	defer api.closeFunc()
+	api.release(t, map[string]string{"db.host": "h", "db.port": "3306", "kafka.brokers": "k1", "timeout": "1s"})
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		keys   []string
+	}{
+		{"whole app", "", http.StatusOK, []string{"db.host", "db.port", "kafka.brokers", "timeout"}},
+		{"keys", "&keys=timeout,db.port,missing", http.StatusOK, []string{"db.port", "timeout"}},
+		{"prefix", "&prefix=db.", http.StatusOK, []string{"db.host", "db.port"}},
+		{"keys and prefix", "&keys=timeout&prefix=kafka.", http.StatusOK, []string{"kafka.brokers", "timeout"}},
+		{"empty subset", "&keys=missing", http.StatusNotFound, nil},
+		{"blank keys", "&keys=,", http.StatusOK, []string{"db.host", "db.port", "kafka.brokers", "timeout"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, data := api.get(t, test.query, nil)
+			if response.StatusCode != test.status {
+				t.Fatalf("answered %d, want %d", response.StatusCode, test.status)
+			}
+			if len(data) != len(test.keys) {
+				t.Fatalf("answered %d keys, want %v", len(data), test.keys)
+			}
+			for _, key := range test.keys {
+				if data[key] == nil || data[key].Key != key {
+					t.Fatalf("answered %v, want %v", data, test.keys)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchAppETag(t *testing.T) {
+	api := newApiTest(t)
+	defer api.closeFunc()
+	api.release(t, map[string]string{"db.host": "h", "timeout": "1s"})
+
+	response, _ := api.get(t, "", nil)
+	etag := response.Header.Get("ETag")
+	if response.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("answered %d with etag %q", response.StatusCode, etag)
+	}
+	response, _ = api.get(t, "&keys=timeout", nil)
+	subsetETag := response.Header.Get("ETag")
+	if subsetETag == "" || subsetETag == etag {
+		t.Fatalf("subset etag %q, whole app %q", subsetETag, etag)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		ifNoneMatch string
+		status      int
+	}{
+		{"same release", "", etag, http.StatusNotModified},
+		{"weak", "", "W/" + etag, http.StatusNotModified},
+		{"one of many", "", `"other", ` + etag, http.StatusNotModified},
+		{"any", "", "*", http.StatusNotModified},
+		{"other etag", "", `"other"`, http.StatusOK},
+		{"etag of another subset", "&keys=timeout", etag, http.StatusOK},
+		{"same subset", "&keys=timeout", subsetETag, http.StatusNotModified},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, data := api.get(t, test.query, http.Header{"If-None-Match": {test.ifNoneMatch}})
+			if response.StatusCode != test.status {
+				t.Fatalf("answered %d, want %d", response.StatusCode, test.status)
+			}
+			if test.status == http.StatusNotModified && response.Header.Get("ETag") == "" {
+				t.Fatal("304 without its etag")
+			}
+			if test.status == http.StatusOK && len(data) == 0 {
+				t.Fatal("200 without the configs")
+			}
+		})
+	}
+
+	// a new release changes the etag
+	api.release(t, map[string]string{"kafka.brokers": "k1"})
+	response, data := api.get(t, "", http.Header{"If-None-Match": {etag}})
+	if response.StatusCode != http.StatusOK || response.Header.Get("ETag") == etag || data["kafka.brokers"] == nil {
+		t.Fatalf("answered %d with etag %q after a release", response.StatusCode, response.Header.Get("ETag"))
+	}
+}
@@ -15,16 +15,23 @@ type AppController struct {
 
 	appService    *service.AppService
 	configService *service.ConfigService
+	clientService *service.ClientService
 }
 
-func InitAppController(s *router.Router, appService *service.AppService, configService *service.ConfigService) *AppController {
-	appController := AppController{appService: appService, configService: configService}
+func InitAppController(s *router.Router, appService *service.AppService, configService *service.ConfigService,
+	clientService *service.ClientService) *AppController {
+	appController := AppController{appService: appService, configService: configService, clientService: clientService}
 
 	s.Get("/app", appController.list)
 	s.Get("/app/:appId([0-9]+)", appController.detail)
 	s.Delete("/app/:appId([0-9]+)", appController.delete)
 	s.Put("/app", appController.create)
 	s.Patch("/app/:appId([0-9]+)", appController.update)
+	s.Get("/app/:appId([0-9]+)/clients", appController.clients)
+	s.Get("/app/:appId([0-9]+)/links", appController.links)
+	s.Put("/app/:appId([0-9]+)/links/:linkAppId([0-9]+)", appController.link)
+	s.Delete("/app/:appId([0-9]+)/links/:linkAppId([0-9]+)", appController.unlink)
+	s.Get("/app/:appId([0-9]+)/consumers", appController.consumers)
 
 	return &appController
 }
@@ -33,7 +40,12 @@ func InitAppController(s *router.Router, appService *service.AppService, configS
 func (_self *AppController) list(w http.ResponseWriter, r *http.Request, c *router.Context) {
 	// read page
 	pageIndex, pageSize := _self.ReadPageInfo(r)
-	pageData, pageCount, totalCount := _self.appService.PageQuery(r.URL.Query().Get("likeName"), pageIndex, pageSize)
+	public, _ := strconv.Atoi(r.URL.Query().Get("public"))
+	pageData, pageCount, totalCount, err := _self.appService.PageQuery(r.Context(), r.URL.Query().Get("likeName"), public, pageIndex, pageSize)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 
 	// remove ApiKey
 	for _, v := range pageData {
@@ -54,7 +66,11 @@ func (_self *AppController) detail(w http.ResponseWriter, r *http.Request, c *ro
 	}
 
 	// query app
-	appData := _self.appService.QueryApp(appId)
+	appData, err := _self.appService.QueryApp(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 	common.WriteSucceedResponse(w, appData)
 }
 
@@ -68,12 +84,19 @@ func (_self *AppController) delete(w http.ResponseWriter, r *http.Request, c *ro
 		return
 	}
 
-	// delete app
-	success := _self.appService.DeleteApp(appId)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	// delete app and refresh the apps linking it
+	user := c.Data["user"].(*dao.UserData)
+	consumerIds, err := _self.configService.QueryConsumerIds(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
+	err = _self.appService.DeleteApp(r.Context(), appId, user.Name)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	_self.configService.RefreshReleases(consumerIds, user.Name)
 	common.WriteSucceedResponse(w, nil)
 }
 
@@ -88,9 +111,9 @@ func (_self *AppController) create(w http.ResponseWriter, r *http.Request, c *ro
 	}
 
 	// create app
-	success := _self.appService.CreateApp(&appData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.appService.CreateApp(r.Context(), &appData)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, appData)
@@ -100,7 +123,7 @@ func (_self *AppController) create(w http.ResponseWriter, r *http.Request, c *ro
 func (_self *AppController) update(w http.ResponseWriter, r *http.Request, c *router.Context) {
 	// read param
 	appData := dao.AppData{}
-	err := common.ReadJson(r, &appData)
+	fields, err := common.ReadJsonFields(r, &appData)
 	if err != nil {
 		common.WriteErrorResponse(w, err.Error())
 		return
@@ -115,10 +138,147 @@ func (_self *AppController) update(w http.ResponseWriter, r *http.Request, c *ro
 
 	// update app
 	appData.AppId = appId
-	success := _self.appService.SelectedUpdateApp(appData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.appService.SelectedUpdateApp(r.Context(), appData, fields)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
+
+	// answer the bumped version, the next edit is based on it
+	if appData.Version != 0 {
+		appData.Version++
+	} else if current, err := _self.appService.QueryApp(r.Context(), appId); err == nil {
+		appData.Version = current.Version
+	}
 	common.WriteSucceedResponse(w, appData)
 }
+
+// GET /app/:appId([0-9]+)/clients
+func (_self *AppController) clients(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// query clients
+	clients, releaseIndex, err := _self.clientService.QueryClients(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+
+	latest, stale := 0, 0
+	for _, client := range clients {
+		if client.Latest {
+			latest++
+		} else {
+			stale++
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["releaseIndex"] = releaseIndex
+	data["latestCount"] = latest
+	data["staleCount"] = stale
+	data["clients"] = clients
+	common.WriteSucceedResponse(w, data)
+}
+
+// GET /app/:appId([0-9]+)/links
+func (_self *AppController) links(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// query linked namespaces
+	apps, err := _self.configService.QueryLinks(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	for _, v := range apps {
+		v.ApiKey = ""
+	}
+	common.WriteSucceedResponse(w, apps)
+}
+
+// PUT /app/:appId([0-9]+)/links/:linkAppId([0-9]+)
+func (_self *AppController) link(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	linkAppId, err := strconv.ParseInt(params.Get(":linkAppId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// link namespace
+	user := c.Data["user"].(*dao.UserData)
+	err = _self.configService.LinkApp(r.Context(), appId, linkAppId, user.Name)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, nil)
+}
+
+// DELETE /app/:appId([0-9]+)/links/:linkAppId([0-9]+)
+func (_self *AppController) unlink(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	linkAppId, err := strconv.ParseInt(params.Get(":linkAppId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// unlink namespace
+	user := c.Data["user"].(*dao.UserData)
+	err = _self.configService.UnlinkApp(r.Context(), appId, linkAppId, user.Name)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, nil)
+}
+
+// GET /app/:appId([0-9]+)/consumers
+func (_self *AppController) consumers(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// query the apps linking this namespace
+	consumers, err := _self.configService.QueryConsumers(r.Context(), appId, params.Get("key"))
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	for _, v := range consumers {
+		v.App.ApiKey = ""
+	}
+	common.WriteSucceedResponse(w, consumers)
+}
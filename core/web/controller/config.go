@@ -41,7 +41,11 @@ func (_self *ConfigController) list(w http.ResponseWriter, r *http.Request, cont
 
 	// read config
 	pageIndex, pageSize := _self.ReadPageInfo(r)
-	pageData, pageCount, totalCount := _self.configService.PageQuery(appId, params.Get("likeKey"), pageIndex, pageSize)
+	pageData, pageCount, totalCount, err := _self.configService.PageQuery(r.Context(), appId, params.Get("likeKey"), pageIndex, pageSize)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 
 	_self.WritePageData(w, pageData, pageIndex, pageCount, pageSize, totalCount)
 }
@@ -58,9 +62,9 @@ func (_self *ConfigController) release(w http.ResponseWriter, r *http.Request, c
 
 	// release config
 	user := context.Data["user"].(*dao.UserData)
-	success := _self.configService.ReleaseConfig(appId, user.Name)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.configService.ReleaseConfig(r.Context(), appId, user.Name)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, nil)
@@ -83,7 +87,11 @@ func (_self *ConfigController) detail(w http.ResponseWriter, r *http.Request, co
 	}
 
 	// query config
-	configData := _self.configService.QueryConfig(appId, configId)
+	configData, err := _self.configService.QueryConfig(r.Context(), appId, configId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 	common.WriteSucceedResponse(w, configData)
 }
 
@@ -110,9 +118,9 @@ func (_self *ConfigController) delete(w http.ResponseWriter, r *http.Request, co
 	configData.ConfigId = configId
 	configData.UpdateBy = user.Name
 
-	success := _self.configService.DeleteConfig(configData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.configService.DeleteConfig(r.Context(), configData)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, nil)
@@ -135,15 +143,22 @@ func (_self *ConfigController) create(w http.ResponseWriter, r *http.Request, co
 		return
 	}
 
+	// check the value
+	configData.AppId = appId
+	err = _self.configService.ValidateConfig(r.Context(), configData, nil)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+
 	// create config
 	user := context.Data["user"].(*dao.UserData)
-	configData.AppId = appId
 	configData.CreateBy = user.Name
 	configData.UpdateBy = user.Name
 
-	success := _self.configService.CreateConfig(&configData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.configService.CreateConfig(r.Context(), &configData)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, configData)
@@ -153,7 +168,7 @@ func (_self *ConfigController) create(w http.ResponseWriter, r *http.Request, co
 func (_self *ConfigController) update(w http.ResponseWriter, r *http.Request, context *router.Context) {
 	// read param
 	configData := dao.ConfigData{}
-	err := common.ReadJson(r, &configData)
+	fields, err := common.ReadJsonFields(r, &configData)
 	if err != nil {
 		common.WriteErrorResponse(w, err.Error())
 		return
@@ -172,16 +187,32 @@ func (_self *ConfigController) update(w http.ResponseWriter, r *http.Request, co
 		return
 	}
 
-	// update config data
-	user := context.Data["user"].(*dao.UserData)
+	// the version the edit is based on, an edit without it would overwrite blindly
+	if configData.Version <= 0 {
+		common.WriteErrorResponseWithCode(w, "version is required", http.StatusPreconditionRequired)
+		return
+	}
+
+	// check the value
 	configData.AppId = appId
 	configData.ConfigId = configId
+	if fields.Has("key") || fields.Has("value") || fields.Has("type") {
+		err = _self.configService.ValidateConfig(r.Context(), configData, fields)
+		if err != nil {
+			common.WriteError(w, err)
+			return
+		}
+	}
+
+	// update config data
+	user := context.Data["user"].(*dao.UserData)
 	configData.UpdateBy = user.Name
 
-	success := _self.configService.UpdateConfig(configData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.configService.UpdateConfig(r.Context(), configData, fields)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
+	configData.Version++
 	common.WriteSucceedResponse(w, configData)
 }
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/moudle/router"
+	"varconf-server/core/service"
+	"varconf-server/core/web/common"
+)
+
+type GitSyncController struct {
+	common.Controller
+
+	gitSyncService *service.GitSyncService
+}
+
+func InitGitSyncController(s *router.Router, gitSyncService *service.GitSyncService) *GitSyncController {
+	gitSyncController := GitSyncController{gitSyncService: gitSyncService}
+
+	s.Get("/app/:appId([0-9]+)/git-sync", gitSyncController.detail)
+	s.Put("/app/:appId([0-9]+)/git-sync", gitSyncController.save)
+	s.Delete("/app/:appId([0-9]+)/git-sync", gitSyncController.delete)
+	s.Post("/app/:appId([0-9]+)/git-sync/push", gitSyncController.push)
+
+	return &gitSyncController
+}
+
+// GET /app/:appId([0-9]+)/git-sync
+func (_self *GitSyncController) detail(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// query git sync
+	gitSyncData, err := _self.gitSyncService.QueryGitSync(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, gitSyncData)
+}
+
+// PUT /app/:appId([0-9]+)/git-sync
+func (_self *GitSyncController) save(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// permission, the server runs git against the repository with its own credentials
+	operator := c.Data["user"].(*dao.UserData)
+	if operator == nil || operator.Permission != dao.USER_ADMIN {
+		common.WriteErrorResponse(w, nil)
+		return
+	}
+
+	// read param
+	gitSyncData := dao.GitSyncData{}
+	err := common.ReadJson(r, &gitSyncData)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// save git sync
+	gitSyncData.AppId = appId
+	gitSyncData.CreateBy = operator.Name
+	err = _self.gitSyncService.SaveGitSync(r.Context(), &gitSyncData)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, gitSyncData)
+}
+
+// DELETE /app/:appId([0-9]+)/git-sync
+func (_self *GitSyncController) delete(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// delete git sync
+	err = _self.gitSyncService.DeleteGitSync(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, nil)
+}
+
+// POST /app/:appId([0-9]+)/git-sync/push
+func (_self *GitSyncController) push(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// push the current release
+	user := c.Data["user"].(*dao.UserData)
+	err = _self.gitSyncService.Push(r.Context(), appId, user.Name)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, nil)
+}
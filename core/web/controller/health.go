@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"net/http"
+
+	"varconf-server/core/moudle/router"
+	"varconf-server/core/service"
+	"varconf-server/core/web/common"
+)
+
+type HealthController struct {
+	common.Controller
+
+	healthService *service.HealthService
+}
+
+func InitHealthController(s *router.Router, healthService *service.HealthService) *HealthController {
+	healthController := HealthController{healthService: healthService}
+
+	s.Get("/healthz", healthController.healthz)
+	s.Get("/readyz", healthController.readyz)
+
+	return &healthController
+}
+
+// GET /healthz
+func (_self *HealthController) healthz(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	common.WriteJson(w, map[string]interface{}{"status": "ok"}, http.StatusOK)
+}
+
+// GET /readyz
+func (_self *HealthController) readyz(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	ready, checks := _self.healthService.Ready()
+
+	status := http.StatusOK
+	statusText := "ok"
+	if !ready {
+		status = http.StatusServiceUnavailable
+		statusText = "unavailable"
+	}
+	common.WriteJson(w, map[string]interface{}{"status": statusText, "checks": checks}, status)
+}
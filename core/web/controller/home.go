@@ -11,18 +11,30 @@ import (
 type HomeController struct {
 	common.Controller
 
-	homeService *service.HomeService
+	homeService   *service.HomeService
+	configService *service.ConfigService
 }
 
-func InitHomeController(s *router.Router, homeService *service.HomeService) *HomeController {
-	homeController := HomeController{homeService: homeService}
+func InitHomeController(s *router.Router, homeService *service.HomeService, configService *service.ConfigService) *HomeController {
+	homeController := HomeController{homeService: homeService, configService: configService}
 
 	s.Get("/home/overall", homeController.overall)
+	s.Get("/home/cache", homeController.cache)
 
 	return &homeController
 }
 
 // GET /home/overall
 func (_self *HomeController) overall(w http.ResponseWriter, r *http.Request, c *router.Context) {
-	common.WriteSucceedResponse(w, _self.homeService.Overall())
+	dataMap, err := _self.homeService.Overall(r.Context())
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, dataMap)
+}
+
+// GET /home/cache
+func (_self *HomeController) cache(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	common.WriteSucceedResponse(w, _self.configService.CacheStats())
 }
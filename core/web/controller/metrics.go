@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"net/http"
+
+	"varconf-server/core/moudle/router"
+	"varconf-server/core/service"
+	"varconf-server/core/web/common"
+)
+
+type MetricsController struct {
+	common.Controller
+
+	metricsService *service.MetricsService
+}
+
+func InitMetricsController(s *router.Router, metricsService *service.MetricsService) *MetricsController {
+	metricsController := MetricsController{metricsService: metricsService}
+
+	s.Get("/metrics", metricsController.metrics)
+
+	return &metricsController
+}
+
+// GET /metrics
+func (_self *MetricsController) metrics(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_self.metricsService.Write(w)
+}
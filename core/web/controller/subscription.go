@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/moudle/router"
+	"varconf-server/core/service"
+	"varconf-server/core/web/common"
+)
+
+type SubscriptionController struct {
+	common.Controller
+
+	notifyService *service.NotifyService
+}
+
+func InitSubscriptionController(s *router.Router, notifyService *service.NotifyService) *SubscriptionController {
+	subscriptionController := SubscriptionController{notifyService: notifyService}
+
+	s.Get("/app/:appId([0-9]+)/subscriptions", subscriptionController.list)
+	s.Put("/app/:appId([0-9]+)/subscriptions", subscriptionController.create)
+	s.Delete("/app/:appId([0-9]+)/subscriptions/:subscriptionId([0-9]+)", subscriptionController.delete)
+
+	return &subscriptionController
+}
+
+// GET /app/:appId([0-9]+)/subscriptions
+func (_self *SubscriptionController) list(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// query subscriptions
+	subscriptions, err := _self.notifyService.QuerySubscriptions(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, subscriptions)
+}
+
+// PUT /app/:appId([0-9]+)/subscriptions
+func (_self *SubscriptionController) create(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	subscriptionData := dao.SubscriptionData{}
+	err := common.ReadJson(r, &subscriptionData)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// create subscription
+	user := c.Data["user"].(*dao.UserData)
+	subscriptionData.AppId = appId
+	subscriptionData.CreateBy = user.Name
+	err = _self.notifyService.CreateSubscription(r.Context(), &subscriptionData)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, subscriptionData)
+}
+
+// DELETE /app/:appId([0-9]+)/subscriptions/:subscriptionId([0-9]+)
+func (_self *SubscriptionController) delete(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	subscriptionId, err := strconv.ParseInt(params.Get(":subscriptionId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// delete subscription
+	err = _self.notifyService.DeleteSubscription(r.Context(), appId, subscriptionId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, nil)
+}
@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"varconf-server/core/dao"
+	daocommon "varconf-server/core/dao/common"
 	"varconf-server/core/moudle/router"
 	"varconf-server/core/service"
 	"varconf-server/core/web/common"
@@ -42,11 +44,15 @@ func (_self *UserController) login(w http.ResponseWriter, r *http.Request, c *ro
 	password := params.Get("password")
 
 	// login
-	success, token := _self.authService.Login(name, password)
-	if !success {
+	token, err := _self.authService.Login(r.Context(), name, password)
+	if errors.Is(err, service.ErrDenied) {
 		common.WriteErrorResponse(w, nil)
 		return
 	}
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 	http.SetCookie(w, &http.Cookie{Name: "token", Value: token, Path: "/", Expires: time.Now().AddDate(0, 0, 1)})
 	common.WriteSucceedResponse(w, token)
 }
@@ -76,7 +82,11 @@ func (_self *UserController) passwd(w http.ResponseWriter, r *http.Request, c *r
 	}
 
 	// query user detail
-	userData := _self.userService.QueryUser(operator.UserId)
+	userData, err := _self.userService.QueryUser(r.Context(), operator.UserId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 	if userData.Password != password1 {
 		common.WriteErrorResponse(w, nil)
 		return
@@ -84,9 +94,9 @@ func (_self *UserController) passwd(w http.ResponseWriter, r *http.Request, c *r
 
 	// passwd user
 	userData.Password = password2
-	success := _self.userService.SelectedUpdateUser(*userData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.userService.SelectedUpdateUser(r.Context(), *userData, daocommon.NewFields("password"))
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, nil)
@@ -96,7 +106,11 @@ func (_self *UserController) passwd(w http.ResponseWriter, r *http.Request, c *r
 func (_self *UserController) list(w http.ResponseWriter, r *http.Request, c *router.Context) {
 	// read page
 	pageIndex, pageSize := _self.ReadPageInfo(r)
-	pageData, pageCount, totalCount := _self.userService.PageQuery(r.URL.Query().Get("likeName"), pageIndex, pageSize)
+	pageData, pageCount, totalCount, err := _self.userService.PageQuery(r.Context(), r.URL.Query().Get("likeName"), pageIndex, pageSize)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 	for i := range pageData {
 		pageData[i].Password = ""
 	}
@@ -127,7 +141,11 @@ func (_self *UserController) detail(w http.ResponseWriter, r *http.Request, c *r
 	}
 
 	// query user detail
-	userData := _self.userService.QueryUser(userId)
+	userData, err := _self.userService.QueryUser(r.Context(), userId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
 	common.WriteSucceedResponse(w, userData)
 }
 
@@ -149,9 +167,9 @@ func (_self *UserController) delete(w http.ResponseWriter, r *http.Request, c *r
 	}
 
 	// delete user
-	success := _self.userService.DeleteUser(userId)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.userService.DeleteUser(r.Context(), userId)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, nil)
@@ -175,9 +193,9 @@ func (_self *UserController) create(w http.ResponseWriter, r *http.Request, c *r
 	}
 
 	// create user
-	success := _self.userService.CreateUser(&userData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.userService.CreateUser(r.Context(), &userData)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, userData)
@@ -202,17 +220,22 @@ func (_self *UserController) update(w http.ResponseWriter, r *http.Request, c *r
 
 	// query user
 	userData := dao.UserData{}
-	err = common.ReadJson(r, &userData)
+	fields, err := common.ReadJsonFields(r, &userData)
 	if err != nil {
 		common.WriteErrorResponse(w, err.Error())
 		return
 	}
 
+	// only an admin grants permissions, a user editing itself keeps its own
+	if operator.Permission != dao.USER_ADMIN {
+		fields = fields.Only("name", "password")
+	}
+
 	// update user
 	userData.UserId = userId
-	success := _self.userService.SelectedUpdateUser(userData)
-	if !success {
-		common.WriteErrorResponse(w, nil)
+	err = _self.userService.SelectedUpdateUser(r.Context(), userData, fields)
+	if err != nil {
+		common.WriteError(w, err)
 		return
 	}
 	common.WriteSucceedResponse(w, nil)
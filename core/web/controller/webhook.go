@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/moudle/router"
+	"varconf-server/core/service"
+	"varconf-server/core/web/common"
+)
+
+type WebhookController struct {
+	common.Controller
+
+	webhookService *service.WebhookService
+}
+
+func InitWebhookController(s *router.Router, webhookService *service.WebhookService) *WebhookController {
+	webhookController := WebhookController{webhookService: webhookService}
+
+	s.Get("/app/:appId([0-9]+)/webhooks", webhookController.list)
+	s.Put("/app/:appId([0-9]+)/webhooks", webhookController.create)
+	s.Patch("/app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)", webhookController.update)
+	s.Delete("/app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)", webhookController.delete)
+	s.Get("/app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)/deliveries", webhookController.deliveries)
+	s.Post("/app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)/deliveries/:deliveryId([0-9]+)/redeliver", webhookController.redeliver)
+
+	return &webhookController
+}
+
+// GET /app/:appId([0-9]+)/webhooks
+func (_self *WebhookController) list(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// query webhooks and hide secret
+	webhooks, err := _self.webhookService.QueryWebhooks(r.Context(), appId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	for _, v := range webhooks {
+		v.Secret = ""
+	}
+	common.WriteSucceedResponse(w, webhooks)
+}
+
+// PUT /app/:appId([0-9]+)/webhooks
+func (_self *WebhookController) create(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	webhookData := dao.WebhookData{}
+	err := common.ReadJson(r, &webhookData)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// create webhook
+	user := c.Data["user"].(*dao.UserData)
+	webhookData.AppId = appId
+	webhookData.CreateBy = user.Name
+	err = _self.webhookService.CreateWebhook(r.Context(), &webhookData)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	webhookData.Secret = ""
+	common.WriteSucceedResponse(w, webhookData)
+}
+
+// PATCH /app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)
+func (_self *WebhookController) update(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	webhookData := dao.WebhookData{}
+	fields, err := common.ReadJsonFields(r, &webhookData)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	webhookId, err := strconv.ParseInt(params.Get(":webhookId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// update webhook
+	webhookData.AppId = appId
+	webhookData.WebhookId = webhookId
+	err = _self.webhookService.UpdateWebhook(r.Context(), webhookData, fields)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, nil)
+}
+
+// DELETE /app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)
+func (_self *WebhookController) delete(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	webhookId, err := strconv.ParseInt(params.Get(":webhookId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// delete webhook
+	err = _self.webhookService.DeleteWebhook(r.Context(), appId, webhookId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, nil)
+}
+
+// GET /app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)/deliveries
+func (_self *WebhookController) deliveries(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	webhookId, err := strconv.ParseInt(params.Get(":webhookId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// read delivery log
+	pageIndex, pageSize := _self.ReadPageInfo(r)
+	pageData, pageCount, totalCount, err := _self.webhookService.PageQueryDeliveries(r.Context(), appId, webhookId, pageIndex, pageSize)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+
+	_self.WritePageData(w, pageData, pageIndex, pageCount, pageSize, totalCount)
+}
+
+// POST /app/:appId([0-9]+)/webhooks/:webhookId([0-9]+)/deliveries/:deliveryId([0-9]+)/redeliver
+func (_self *WebhookController) redeliver(w http.ResponseWriter, r *http.Request, c *router.Context) {
+	// read param
+	params := r.URL.Query()
+	appId, err := strconv.ParseInt(params.Get(":appId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	webhookId, err := strconv.ParseInt(params.Get(":webhookId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	deliveryId, err := strconv.ParseInt(params.Get(":deliveryId"), 10, 64)
+	if err != nil {
+		common.WriteErrorResponse(w, err.Error())
+		return
+	}
+
+	// redeliver payload
+	delivery, err := _self.webhookService.Redeliver(r.Context(), appId, webhookId, deliveryId)
+	if err != nil {
+		common.WriteError(w, err)
+		return
+	}
+	common.WriteSucceedResponse(w, delivery)
+}
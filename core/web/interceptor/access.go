@@ -0,0 +1,20 @@
+package interceptor
+
+import (
+	"net/http"
+
+	"varconf-server/core/dao"
+	"varconf-server/core/moudle/router"
+)
+
+// AccessFields adds the user or app authenticated by the interceptors to the access line.
+func AccessFields(r *http.Request, c *router.Context) []interface{} {
+	fields := make([]interface{}, 0, 4)
+	if user, ok := c.Data["user"].(*dao.UserData); ok && user != nil {
+		fields = append(fields, "user", user.Name)
+	}
+	if app, ok := c.Data["app"].(*dao.AppData); ok && app != nil {
+		fields = append(fields, "app_id", app.AppId, "app", app.Code)
+	}
+	return fields
+}
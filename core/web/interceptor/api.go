@@ -1,18 +1,21 @@
 package interceptor
 
 import (
+	"errors"
 	"net/http"
 
+	daocommon "varconf-server/core/dao/common"
 	"varconf-server/core/moudle/router"
 	"varconf-server/core/service"
 )
 
 type ApiAuthInterceptor struct {
-	authService *service.AuthService
+	authService     *service.AuthService
+	fallbackService *service.FallbackService
 }
 
-func InitApiAuthInterceptor(s *router.Router, authService *service.AuthService) *ApiAuthInterceptor {
-	apiAuthInterceptor := ApiAuthInterceptor{authService: authService}
+func InitApiAuthInterceptor(s *router.Router, authService *service.AuthService, fallbackService *service.FallbackService) *ApiAuthInterceptor {
+	apiAuthInterceptor := ApiAuthInterceptor{authService: authService, fallbackService: fallbackService}
 
 	s.AddFilter("/api(.*)", []string{}, &apiAuthInterceptor)
 
@@ -27,9 +30,15 @@ func (_self *ApiAuthInterceptor) PreHandleFunc(w http.ResponseWriter, r *http.Re
 		return false
 	}
 
-	success, appData := _self.authService.ApiAuth(token)
-	if !success {
-		http.Error(w, "Permission deny!", http.StatusForbidden)
+	appData, err := _self.authService.ApiAuth(r.Context(), token)
+	if errors.Is(err, daocommon.ErrUnavailable) {
+		// the database is down, trust the apps served before
+		if fallbackApp, ok := _self.fallbackService.App(token); ok {
+			appData, err = fallbackApp, nil
+		}
+	}
+	if err != nil {
+		denyError(w, err)
 		return false
 	}
 
@@ -39,3 +48,13 @@ func (_self *ApiAuthInterceptor) PreHandleFunc(w http.ResponseWriter, r *http.Re
 
 func (_self *ApiAuthInterceptor) PostHandleFunc(w http.ResponseWriter, r *http.Request, c *router.Context) {
 }
+
+// denyError answers a failed authentication, 503 while the database can't be
+// reached so that clients retry instead of dropping their token.
+func denyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, daocommon.ErrUnavailable) {
+		http.Error(w, "Service unavailable!", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "Permission deny!", http.StatusForbidden)
+}
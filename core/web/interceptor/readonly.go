@@ -0,0 +1,33 @@
+package interceptor
+
+import (
+	"net/http"
+
+	"varconf-server/core/moudle/router"
+)
+
+// ReadOnlyInterceptor rejects the management writes of a node serving a
+// read-only replica, the client api and reads pass.
+type ReadOnlyInterceptor struct {
+}
+
+func InitReadOnlyInterceptor(s *router.Router) *ReadOnlyInterceptor {
+	readOnlyInterceptor := ReadOnlyInterceptor{}
+
+	s.AddFilter("/(.*)", []string{"/api(.*)", "/user/logout"}, &readOnlyInterceptor)
+
+	return &readOnlyInterceptor
+}
+
+func (_self *ReadOnlyInterceptor) PreHandleFunc(w http.ResponseWriter, r *http.Request, c *router.Context) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	http.Error(w, "Read only!", http.StatusServiceUnavailable)
+	return false
+}
+
+func (_self *ReadOnlyInterceptor) PostHandleFunc(w http.ResponseWriter, r *http.Request, c *router.Context) {
+}
@@ -14,7 +14,7 @@ type UserAuthInterceptor struct {
 func InitUserAuthInterceptor(s *router.Router, authService *service.AuthService) *UserAuthInterceptor {
 	authInterceptor := UserAuthInterceptor{authService: authService}
 
-	s.AddFilter("/(.*)", []string{"/", "/static(.*)", "/api(.*)", "/user/login", "/user/logout"}, &authInterceptor)
+	s.AddFilter("/(.*)", []string{"/", "/static(.*)", "/api(.*)", "/user/login", "/user/logout", "/healthz", "/readyz"}, &authInterceptor)
 
 	return &authInterceptor
 }
@@ -26,9 +26,9 @@ func (_self *UserAuthInterceptor) PreHandleFunc(w http.ResponseWriter, r *http.R
 		return false
 	}
 
-	success, userData := _self.authService.Auth(token.Value)
-	if !success {
-		http.Error(w, "Permission deny!", http.StatusForbidden)
+	userData, err := _self.authService.Auth(r.Context(), token.Value)
+	if err != nil {
+		denyError(w, err)
 		return false
 	}
 
@@ -1,8 +1,11 @@
 package resolver
 
 import (
+	"errors"
 	"net/http"
 
+	daocommon "varconf-server/core/dao/common"
+	"varconf-server/core/moudle/logger"
 	"varconf-server/core/moudle/router"
 	"varconf-server/core/web/common"
 )
@@ -18,6 +21,14 @@ func InitErrorRecover(s *router.Router) *ErrorResolver {
 	return &errorRecover
 }
 
+// Error answers a panicked handler, a database error by its kind and anything
+// else as an internal error whose text is only logged.
 func (_self *ErrorResolver) Error(w http.ResponseWriter, r *http.Request, err error) {
-	common.WriteErrorResponse(w, err.Error())
+	var daoErr *daocommon.Error
+	if errors.As(err, &daoErr) {
+		common.WriteError(w, err)
+		return
+	}
+	logger.Error("web: handler panic", "method", r.Method, "path", r.URL.Path, "error", err)
+	common.WriteErrorResponseWithCode(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }